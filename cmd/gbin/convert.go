@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+func dumpCmd(args []string, stdin io.Reader, stdout io.Writer) error {
+	data, err := readInput(args, stdin)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(stdout)
+	err = gbin.Dump(w, data)
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+func jsonCmd(args []string, stdin io.Reader, stdout io.Writer) error {
+	data, err := readInput(args, stdin)
+	if err != nil {
+		return err
+	}
+	converted, err := gbin.ToJSON(data)
+	if err != nil {
+		return err
+	}
+	out := bytes.Buffer{}
+	err = json.Indent(&out, converted, "", "  ")
+	if err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = stdout.Write(out.Bytes())
+	return err
+}
+
+func encodeCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("encode", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	schemaPath := flags.String("schema", "", "file encoded with a schema, describing the type to encode as")
+	compact := flags.Bool("compact", false, "write the compact format")
+	withSchema := flags.Bool("with-schema", false, "write a schema ahead of the value")
+	output := flags.String("o", "", "output file; default standard output")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *schemaPath == "" {
+		return fmt.Errorf("encode requires -schema")
+	}
+	described, err := os.ReadFile(*schemaPath)
+	if err != nil {
+		return err
+	}
+	schema, err := gbin.ReadSchema(described)
+	if err != nil {
+		return fmt.Errorf("reading schema from %s: %w", *schemaPath, err)
+	}
+	input, err := readInput(flags.Args(), stdin)
+	if err != nil {
+		return err
+	}
+	// numbers are kept as written, so that integers keep their precision
+	dec := json.NewDecoder(bytes.NewReader(input))
+	dec.UseNumber()
+	var tree any
+	err = dec.Decode(&tree)
+	if err != nil {
+		return err
+	}
+	opts := []gbin.Option{}
+	if *compact {
+		opts = append(opts, gbin.WithCompact())
+	}
+	if *withSchema {
+		opts = append(opts, gbin.WithSchema())
+	}
+	encoded, err := gbin.EncodeDynamic(tree, schema, opts...)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		return os.WriteFile(*output, encoded, 0o644)
+	}
+	_, err = stdout.Write(encoded)
+	return err
+}
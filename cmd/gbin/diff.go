@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+func diffCmd(args []string, stdout io.Writer) error {
+	if len(args) != 2 {
+		return fmt.Errorf("diff expects two files, got %d", len(args))
+	}
+	trees := [2]any{}
+	for i, path := range args {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		trees[i], err = gbin.DecodeDynamic(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	d := &differ{seen: map[[2]uintptr]bool{}}
+	d.diff("", trees[0], trees[1])
+	for _, line := range d.lines {
+		_, err := fmt.Fprintln(stdout, line)
+		if err != nil {
+			return err
+		}
+	}
+	if len(d.lines) > 0 {
+		return errDiffer
+	}
+	return nil
+}
+
+// differ compares generic trees, describing each difference with a line
+// holding its path: "- path: value" for values only in the first tree,
+// "+ path: value" for values only in the second, and "~ path: a -> b" for
+// values which changed
+type differ struct {
+	lines []string
+	// seen holds the pairs of containers compared, so that shared and
+	// cyclic values are compared once
+	seen map[[2]uintptr]bool
+}
+
+func (d *differ) add(op string, path string, format string, a ...any) {
+	if path == "" {
+		path = "(root)"
+	}
+	d.lines = append(d.lines, fmt.Sprintf("%s %s: %s", op, path, fmt.Sprintf(format, a...)))
+}
+
+// compared reports whether the containers a and b have already been compared,
+// marking them as compared
+func (d *differ) compared(a, b any) bool {
+	pair := [2]uintptr{reflect.ValueOf(a).Pointer(), reflect.ValueOf(b).Pointer()}
+	if d.seen[pair] {
+		return true
+	}
+	d.seen[pair] = true
+	return false
+}
+
+func (d *differ) diff(path string, a, b any) {
+	switch x := a.(type) {
+	case map[string]any:
+		if y, ok := b.(map[string]any); ok {
+			if !d.compared(x, y) {
+				d.diff_entries(path, toEntries(x), toEntries(y), fieldPath)
+			}
+			return
+		}
+	case map[any]any:
+		if y, ok := b.(map[any]any); ok {
+			if !d.compared(x, y) {
+				d.diff_entries(path, x, y, keyPath)
+			}
+			return
+		}
+	case []any:
+		if y, ok := b.([]any); ok {
+			if !d.compared(x, y) {
+				d.diff_list(path, x, y)
+			}
+			return
+		}
+	}
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+	as, bs := describe(a), describe(b)
+	if as == bs {
+		as, bs = fmt.Sprintf("%s (%T)", as, a), fmt.Sprintf("%s (%T)", bs, b)
+	}
+	d.add("~", path, "%s -> %s", as, bs)
+}
+
+func (d *differ) diff_entries(path string, x, y map[any]any, join func(string, any) string) {
+	keys := []any{}
+	for k := range x {
+		keys = append(keys, k)
+	}
+	for k := range y {
+		if _, ok := x[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	for _, k := range keys {
+		xv, inX := x[k]
+		yv, inY := y[k]
+		switch {
+		case !inY:
+			d.add("-", join(path, k), "%s", describe(xv))
+		case !inX:
+			d.add("+", join(path, k), "%s", describe(yv))
+		default:
+			d.diff(join(path, k), xv, yv)
+		}
+	}
+}
+
+func (d *differ) diff_list(path string, x, y []any) {
+	for i := 0; i < len(x) || i < len(y); i++ {
+		el := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= len(y):
+			d.add("-", el, "%s", describe(x[i]))
+		case i >= len(x):
+			d.add("+", el, "%s", describe(y[i]))
+		default:
+			d.diff(el, x[i], y[i])
+		}
+	}
+}
+
+func toEntries(node map[string]any) map[any]any {
+	entries := make(map[any]any, len(node))
+	for k, v := range node {
+		entries[k] = v
+	}
+	return entries
+}
+
+func fieldPath(path string, name any) string {
+	if path == "" {
+		return fmt.Sprint(name)
+	}
+	return fmt.Sprintf("%s.%v", path, name)
+}
+
+func keyPath(path string, key any) string {
+	return fmt.Sprintf("%s[%s]", path, describe(key))
+}
+
+// describe formats a value of a tree, summarising containers
+func describe(v any) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case map[string]any:
+		return fmt.Sprintf("struct with %d %s", len(v), plural(len(v), "field", "fields"))
+	case map[any]any:
+		return fmt.Sprintf("map of %d %s", len(v), plural(len(v), "entry", "entries"))
+	case []any:
+		return fmt.Sprintf("list of %d %s", len(v), plural(len(v), "element", "elements"))
+	case nil:
+		return "nil"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,80 @@
+// gbin inspects and converts gbin encoded files.
+//
+//	gbin dump [file]                  print the structure of the encoding with offsets
+//	gbin json [file]                  convert to JSON
+//	gbin encode -schema data [file]   convert JSON back, as the type described by
+//	                                  the schema written ahead of data
+//	gbin diff a b                     print the differences between two files
+//
+// Files default to the standard input where they are optional. The schema
+// used by encode is that of any data encoded WithSchema, such as the file the
+// JSON was converted from; its own encoding is written with -compact and
+// -with-schema. Values of recursive types cannot be converted back from JSON.
+// diff exits with status 1 if the files differ.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errDiffer is returned by diff when the files differ
+var errDiffer = errors.New("files differ")
+
+const usage = `usage:
+	gbin dump [file]
+	gbin json [file]
+	gbin encode -schema data [-compact] [-with-schema] [-o output] [file]
+	gbin diff a b
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command given by args, returning its exit status
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "dump":
+		err = dumpCmd(args[1:], stdin, stdout)
+	case "json":
+		err = jsonCmd(args[1:], stdin, stdout)
+	case "encode":
+		err = encodeCmd(args[1:], stdin, stdout, stderr)
+	case "diff":
+		err = diffCmd(args[1:], stdout)
+	default:
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	if errors.Is(err, errDiffer) {
+		return 1
+	} else if errors.Is(err, flag.ErrHelp) {
+		return 2
+	} else if err != nil {
+		fmt.Fprintf(stderr, "gbin: %s\n", err)
+		return 2
+	}
+	return 0
+}
+
+// readInput reads the file named by the only argument in args, or stdin if
+// there is none
+func readInput(args []string, stdin io.Reader) ([]byte, error) {
+	switch len(args) {
+	case 0:
+		return io.ReadAll(stdin)
+	case 1:
+		return os.ReadFile(args[0])
+	default:
+		return nil, fmt.Errorf("expected at most one file, got %d", len(args))
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+type record struct {
+	Name   string `gbin:"name"`
+	Count  int64
+	Ratio  float32
+	Scores []float64
+	Labels map[uint16]string
+	Child  *child
+	Cells  map[child]string
+}
+
+type child struct {
+	Flags [2]bool
+}
+
+type node struct {
+	Value int
+	Next  *node
+}
+
+// write encodes v to a file in dir, returning its path
+func write(t *testing.T, dir string, name string, v record, opts ...gbin.Option) string {
+	encoded, err := gbin.NewEncoder[record](opts...).Encode(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	err = os.WriteFile(path, encoded, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func runCmd(t *testing.T, stdin []byte, args ...string) (int, string) {
+	stdout, stderr := bytes.NewBuffer([]byte{}), bytes.NewBuffer([]byte{})
+	code := run(args, bytes.NewReader(stdin), stdout, stderr)
+	if code == 2 {
+		t.Logf("gbin %s: %s", strings.Join(args, " "), stderr)
+	}
+	return code, stdout.String()
+}
+
+func TestDump(t *testing.T) {
+	path := write(t, t.TempDir(), "a.gbin", record{Name: "a", Scores: []float64{}, Labels: map[uint16]string{}}, gbin.WithSchema())
+	code, out := runCmd(t, nil, "dump", path)
+	if code != 0 {
+		t.Fatalf("expected status 0, got %d", code)
+	}
+	if !strings.HasPrefix(out, "00000000  schema struct { name string; Count int64;") || !strings.Contains(out, "string (1 bytes) \"a\"\n") {
+		t.Fatalf("unexpected dump\n%s", out)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	data := record{
+		Name:   "a",
+		Count:  math.MaxInt64,
+		Ratio:  float32(math.Inf(1)),
+		Scores: []float64{0.1, math.NaN()},
+		Labels: map[uint16]string{7: "x"},
+		Child:  &child{Flags: [2]bool{true, false}},
+		Cells:  map[child]string{{Flags: [2]bool{false, true}}: "c"},
+	}
+	schema := write(t, dir, "schema.gbin", record{}, gbin.WithSchema())
+	cases := []struct {
+		opts  []gbin.Option
+		flags []string
+	}{
+		{nil, nil},
+		{[]gbin.Option{gbin.WithCompact()}, []string{"-compact"}},
+		{[]gbin.Option{gbin.WithSchema(), gbin.WithCompact()}, []string{"-with-schema", "-compact"}},
+	}
+	for _, c := range cases {
+		path := write(t, dir, "data.gbin", data, c.opts...)
+		code, out := runCmd(t, nil, "json", path)
+		if code != 0 {
+			t.Fatalf("expected status 0, got %d", code)
+		}
+		if !strings.Contains(out, "\"Count\": 9223372036854775807") || !strings.Contains(out, "\"NaN\"") || !strings.Contains(out, `"{\"Flags\":[false,true]}": "c"`) {
+			t.Fatalf("unexpected JSON\n%s", out)
+		}
+		args := append([]string{"encode", "-schema", schema}, c.flags...)
+		code, encoded := runCmd(t, []byte(out), args...)
+		if code != 0 {
+			t.Fatalf("expected status 0, got %d", code)
+		}
+		original, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoded != string(original) {
+			t.Fatalf("expected % x, got % x", original, encoded)
+		}
+	}
+	// recursive types cannot be encoded from their schema
+	encoded, err := gbin.NewEncoder[node](gbin.WithSchema()).Encode(&node{Value: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "node.gbin")
+	err = os.WriteFile(path, encoded, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := runCmd(t, []byte(`{"Value": 1}`), "encode", "-schema", path); code != 2 {
+		t.Fatalf("expected status 2 encoding a recursive type, got %d", code)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	a := record{Name: "a", Count: 1, Scores: []float64{1, 2}, Labels: map[uint16]string{1: "x", 2: "y"}}
+	b := record{Name: "b", Count: 1, Scores: []float64{1}, Labels: map[uint16]string{1: "x", 3: "z"}, Child: &child{}}
+	pathA := write(t, dir, "a.gbin", a)
+	pathB := write(t, dir, "b.gbin", b, gbin.WithCompact())
+	code, out := runCmd(t, nil, "diff", pathA, pathB)
+	if code != 1 {
+		t.Fatalf("expected status 1, got %d", code)
+	}
+	expected := strings.Join([]string{
+		"~ Child: nil -> struct with 1 field",
+		"- Labels[2]: \"y\"",
+		"+ Labels[3]: \"z\"",
+		"- Scores[1]: 2",
+		"~ name: \"a\" -> \"b\"",
+		"",
+	}, "\n")
+	if out != expected {
+		t.Fatalf("expected diff\n%s\ngot\n%s", expected, out)
+	}
+	same := write(t, dir, "same.gbin", a, gbin.WithCompact())
+	if code, out := runCmd(t, nil, "diff", pathA, same); code != 0 || out != "" {
+		t.Fatalf("expected no differences, got status %d and\n%s", code, out)
+	}
+}
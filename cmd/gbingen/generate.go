@@ -0,0 +1,917 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"math"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const generatedHeader = "// Code generated by gbingen. DO NOT EDIT."
+
+const gbinPath = "github.com/lspaccatrosi16/go-libs/gbin"
+
+// generate returns the source of the methods for the named types of the
+// package in dir
+func generate(dir string, names []string) ([]byte, error) {
+	pkg, err := load(dir)
+	if err != nil {
+		return nil, err
+	}
+	g := newGenerator(pkg, names)
+	for _, name := range names {
+		err := g.generate_type(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g.source()
+}
+
+// load type checks the package in dir, leaving out files written by gbingen
+// as they may be out of date
+func load(dir string) (*types.Package, error) {
+	bp, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	files := []*ast.File{}
+	for _, name := range bp.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if len(f.Comments) > 0 && f.Comments[0].Pos() < f.Package && strings.HasPrefix(f.Comments[0].List[0].Text, generatedHeader) {
+			continue
+		}
+		files = append(files, f)
+	}
+	// errors are tolerated, as other files may use the methods being generated
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	pkg, _ := conf.Check(bp.ImportPath, fset, files, nil)
+	return pkg, nil
+}
+
+type generator struct {
+	pkg     *types.Package
+	names   map[string]bool
+	prefix  string
+	imports map[string]string
+	zeros   []string
+	zeroIdx map[string]int
+	body    bytes.Buffer
+	depth   int
+}
+
+func newGenerator(pkg *types.Package, names []string) *generator {
+	g := &generator{
+		pkg:     pkg,
+		names:   map[string]bool{},
+		prefix:  "gbin" + strings.ToUpper(names[0][:1]) + names[0][1:],
+		imports: map[string]string{},
+		zeroIdx: map[string]int{},
+	}
+	for _, name := range names {
+		g.names[name] = true
+	}
+	g.use(gbinPath, "gbin")
+	return g
+}
+
+func (g *generator) printf(format string, a ...any) {
+	fmt.Fprintf(&g.body, format, a...)
+	g.body.WriteByte('\n')
+}
+
+// use records an import, returning the name it is imported under
+func (g *generator) use(path, name string) string {
+	if existing, ok := g.imports[path]; ok {
+		return existing
+	}
+	taken := map[string]bool{}
+	for _, other := range g.imports {
+		taken[other] = true
+	}
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	g.imports[path] = unique
+	return unique
+}
+
+func (g *generator) typeString(t types.Type) string {
+	return types.TypeString(t, func(p *types.Package) string {
+		if p == g.pkg {
+			return ""
+		}
+		return g.use(p.Path(), p.Name())
+	})
+}
+
+// zero returns the variable holding the zero value header of t
+func (g *generator) zero(t types.Type) string {
+	ts := g.typeString(t)
+	idx, ok := g.zeroIdx[ts]
+	if !ok {
+		idx = len(g.zeros)
+		g.zeroIdx[ts] = idx
+		g.zeros = append(g.zeros, ts)
+	}
+	return fmt.Sprintf("%sZero%d", g.prefix, idx)
+}
+
+// nest returns a suffix for the variables of a nested container
+func (g *generator) nest() string {
+	g.depth++
+	return strconv.Itoa(g.depth)
+}
+
+func (g *generator) unnest() {
+	g.depth--
+}
+
+// field is a struct field as encoded by gbin, following its struct tag.
+// Promoted fields are named by their selector, and reached through the
+// embedded pointers in ptrs.
+type field struct {
+	goName     string
+	index      []int
+	ptrs       []embeddedPtr
+	name       string
+	named      bool
+	aliases    []string
+	typ        types.Type
+	omitEmpty  bool
+	defaultVal string
+	hasDefault bool
+}
+
+func (g *generator) generate_type(name string) error {
+	obj, ok := g.pkg.Scope().Lookup(name).(*types.TypeName)
+	if !ok {
+		return fmt.Errorf("type %s not found in package %s", name, g.pkg.Name())
+	}
+	named, ok := obj.Type().(*types.Named)
+	if !ok || named.TypeParams().Len() > 0 {
+		return fmt.Errorf("%s is not a non generic defined type", name)
+	}
+	st, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		return fmt.Errorf("%s is not a struct type", name)
+	}
+	if custom(named) {
+		return fmt.Errorf("%s already has marshaling methods", name)
+	}
+	if g.recursive(named) {
+		return fmt.Errorf("%s is a recursive type, which gbingen does not support", name)
+	}
+	fields, nested, err := structFields(st)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err.Error())
+	}
+	for _, f := range fields {
+		for _, ptr := range f.ptrs {
+			if named, ok := ptr.elem.(*types.Named); ok && named.Obj().Pkg() != g.pkg && !named.Obj().Exported() {
+				return fmt.Errorf("%s: field %s is promoted from %s, which cannot be allocated outside of its package", name, f.goName, named)
+			}
+		}
+	}
+	plain := "gbinPlain" + name
+
+	g.printf("// MarshalGbin encodes x in the standard format without reflection")
+	g.printf("func (x %s) MarshalGbin() ([]byte, error) {", name)
+	g.printf("w := gbin.NewWriter()")
+	g.printf("x.gbinEncode(w)")
+	g.printf("return w.Bytes()")
+	g.printf("}\n")
+
+	g.printf("// UnmarshalGbin decodes data into x without reflection, falling back to")
+	g.printf("// reflection for data which is not laid out as %s is encoded", name)
+	g.printf("func (x *%s) UnmarshalGbin(data []byte) error {", name)
+	g.printf("var v %s", name)
+	g.printf("r := gbin.NewReader(data)")
+	g.printf("if v.gbinDecode(r) && r.Done() {")
+	g.printf("*x = v")
+	g.printf("return nil")
+	g.printf("}")
+	g.printf("return gbin.Unmarshal(data, (*%s)(x))", plain)
+	g.printf("}\n")
+
+	g.printf("// %s has the fields of %s without its methods, for decoding with reflection", plain, name)
+	g.printf("type %s %s\n", plain, name)
+
+	g.printf("func (x *%s) gbinEncode(w *gbin.Writer) {", name)
+	g.printf("start := w.Begin(gbin.STRUCT)")
+	for _, f := range fields {
+		expr := "x." + f.goName
+		conds := []string{}
+		for _, ptr := range f.ptrs {
+			conds = append(conds, fmt.Sprintf("x.%s != nil", ptr.expr))
+		}
+		if f.omitEmpty {
+			if empty := g.empty(expr, f.typ); empty != "" {
+				conds = append(conds, fmt.Sprintf("!(%s)", empty))
+			}
+		}
+		if len(conds) > 0 {
+			g.printf("if %s {", strings.Join(conds, " && "))
+		}
+		g.printf("w.String(%q)", f.name)
+		g.encode(expr, f.typ)
+		if len(conds) > 0 {
+			g.printf("}")
+		}
+	}
+	g.printf("w.End(start)")
+	g.printf("}\n")
+
+	g.printf("func (x *%s) gbinDecode(r *gbin.Reader) bool {", name)
+	g.printf("end, ok := r.Begin(gbin.STRUCT)")
+	g.printf("if !ok {")
+	g.printf("return false")
+	g.printf("}")
+	g.printf("*x = %s{}", name)
+	for _, f := range fields {
+		if f.hasDefault {
+			g.allocate(f.ptrs)
+			err := g.defaultAssign("x."+f.goName, f.typ, f.defaultVal)
+			if err != nil {
+				return fmt.Errorf("%s: field %s has invalid default: %s", name, f.goName, err.Error())
+			}
+		}
+	}
+	g.printf("var key string")
+	g.printf("for r.More(end) {")
+	g.printf("if !r.String(&key) {")
+	g.printf("return false")
+	g.printf("}")
+	g.printf("switch key {")
+	for _, f := range fields {
+		keys := []string{}
+		for _, k := range append([]string{f.name}, f.aliases...) {
+			keys = append(keys, strconv.Quote(k))
+		}
+		g.printf("case %s:", strings.Join(keys, ", "))
+		g.allocate(f.ptrs)
+		g.decode("x."+f.goName, f.typ)
+	}
+	if len(nested) > 0 {
+		// embedded structs encoded as fields are left to reflection
+		keys := []string{}
+		for _, k := range nested {
+			keys = append(keys, strconv.Quote(k))
+		}
+		g.printf("case %s:", strings.Join(keys, ", "))
+		g.printf("return false")
+	}
+	g.printf("default:")
+	g.printf("if !r.Skip() {")
+	g.printf("return false")
+	g.printf("}")
+	g.printf("}")
+	g.printf("}")
+	g.printf("return r.End(end)")
+	g.printf("}\n")
+	return nil
+}
+
+// embedding is a struct whose fields are promoted into the struct methods
+// are generated for, reached from it by path through the pointers in ptrs
+type embedding struct {
+	st    *types.Struct
+	typ   types.Type
+	path  string
+	index []int
+	ptrs  []embeddedPtr
+}
+
+// embeddedPtr is an embedded pointer which promoted fields are reached
+// through, which must be allocated before they are decoded
+type embeddedPtr struct {
+	expr string
+	elem types.Type
+}
+
+// structFields returns the encoded fields of st, as gbin finds them, and the
+// names of the structs embedded in it which earlier versions of gbin encoded
+// as fields
+func structFields(st *types.Struct) ([]field, []string, error) {
+	candidates := []field{}
+	next := []embedding{{st: st}}
+	count, nextCount := map[types.Type]int{}, map[types.Type]int{}
+	visited := map[types.Type]bool{}
+	nested := []string{}
+	for len(next) > 0 {
+		current := next
+		next = nil
+		count, nextCount = nextCount, map[types.Type]int{}
+		for _, outer := range current {
+			if outer.typ != nil {
+				if visited[outer.typ] {
+					continue
+				}
+				visited[outer.typ] = true
+			}
+			for i := 0; i < outer.st.NumFields(); i++ {
+				v := outer.st.Field(i)
+				if basic, ok := v.Type().(*types.Basic); ok && basic.Kind() == types.Invalid {
+					return nil, nil, fmt.Errorf("the type of field %s could not be determined", v.Name())
+				}
+				ft := v.Type()
+				ptr, embeddedByPtr := ft.(*types.Pointer)
+				if v.Embedded() && embeddedByPtr {
+					ft = ptr.Elem()
+				}
+				inner, promoted := ft.Underlying().(*types.Struct)
+				promoted = promoted && v.Embedded()
+				if !v.Exported() && !promoted {
+					continue
+				}
+				tag, tagged := reflect.StructTag(outer.st.Tag(i)).Lookup("gbin")
+				if tag == "-" {
+					continue
+				}
+				f := field{
+					goName: outer.path + v.Name(),
+					name:   v.Name(),
+					typ:    v.Type(),
+					index:  append(append([]int{}, outer.index...), i),
+					ptrs:   outer.ptrs,
+				}
+				if tagged {
+					err := f.parseTag(v, tag)
+					if err != nil {
+						return nil, nil, err
+					}
+				}
+				if promoted && !f.named {
+					if outer.typ == nil && v.Exported() {
+						nested = append(nested, v.Name())
+					}
+					nextCount[ft]++
+					if nextCount[ft] == 1 {
+						ptrs := f.ptrs
+						if embeddedByPtr {
+							ptrs = append(append([]embeddedPtr{}, f.ptrs...), embeddedPtr{f.goName, ft})
+						}
+						next = append(next, embedding{inner, ft, f.goName + ".", f.index, ptrs})
+					}
+					continue
+				} else if !v.Exported() {
+					continue
+				}
+				candidates = append(candidates, f)
+				if count[outer.typ] > 1 {
+					// the fields of a struct embedded twice at the same depth
+					// conflict with each other
+					candidates = append(candidates, f)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.name != b.name {
+			return a.name < b.name
+		} else if len(a.index) != len(b.index) {
+			return len(a.index) < len(b.index)
+		}
+		return a.named && !b.named
+	})
+	fields := []field{}
+	for i := 0; i < len(candidates); {
+		j := i + 1
+		for j < len(candidates) && candidates[j].name == candidates[i].name {
+			j++
+		}
+		if j-i > 1 && len(candidates[i+1].index) == 1 {
+			return nil, nil, fmt.Errorf("fields %s and %s are both encoded as %s", candidates[i].goName, candidates[i+1].goName, candidates[i].name)
+		}
+		if j-i == 1 || len(candidates[i].index) < len(candidates[i+1].index) || candidates[i].named != candidates[i+1].named {
+			fields = append(fields, candidates[i])
+		}
+		i = j
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return slices.Compare(fields[i].index, fields[j].index) < 0
+	})
+
+	seen := map[string]string{}
+	for _, f := range fields {
+		for _, name := range append([]string{f.name}, f.aliases...) {
+			if other, found := seen[name]; found {
+				return nil, nil, fmt.Errorf("fields %s and %s are both encoded as %s", other, f.goName, name)
+			}
+			seen[name] = f.goName
+		}
+	}
+	old := []string{}
+	for _, name := range nested {
+		if _, found := seen[name]; !found {
+			old = append(old, name)
+		}
+	}
+	return fields, old, nil
+}
+
+func (f *field) parseTag(v *types.Var, tag string) error {
+	opts := strings.Split(tag, ",")
+	if opts[0] != "" {
+		f.name, f.named = opts[0], true
+	}
+	for _, opt := range opts[1:] {
+		key, val, _ := strings.Cut(opt, "=")
+		switch key {
+		case "omitempty":
+			f.omitEmpty = true
+		case "alias":
+			if val == "" {
+				return fmt.Errorf("field %s has an empty alias", v.Name())
+			}
+			f.aliases = append(f.aliases, val)
+		case "default":
+			f.defaultVal, f.hasDefault = val, true
+		default:
+			return fmt.Errorf("field %s has unknown gbin tag option %s", v.Name(), key)
+		}
+	}
+	if f.omitEmpty && f.hasDefault {
+		return fmt.Errorf("field %s cannot be both omitempty and have a default, as its zero value would be decoded as the default", v.Name())
+	}
+	return nil
+}
+
+// allocate writes the code allocating the embedded pointers which a promoted
+// field is reached through
+func (g *generator) allocate(ptrs []embeddedPtr) {
+	for _, ptr := range ptrs {
+		g.printf("if x.%s == nil {", ptr.expr)
+		g.printf("x.%s = new(%s)", ptr.expr, g.typeString(ptr.elem))
+		g.printf("}")
+	}
+}
+
+// custom reports whether values of t are encoded by their own methods
+func custom(t types.Type) bool {
+	switch t.Underlying().(type) {
+	case *types.Interface, *types.Pointer:
+		return false
+	}
+	methods := types.NewMethodSet(types.NewPointer(t))
+	for _, name := range []string{"MarshalGbin", "UnmarshalGbin", "MarshalBinary", "UnmarshalBinary", "MarshalText", "UnmarshalText"} {
+		if methods.Lookup(nil, name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// recursive reports whether target can contain values of its own type
+func (g *generator) recursive(target *types.Named) bool {
+	seen := map[*types.Named]bool{}
+	var walk func(t types.Type) bool
+	walk = func(t types.Type) bool {
+		switch t := t.(type) {
+		case *types.Named:
+			if t == target {
+				return true
+			} else if t.Obj().Pkg() != g.pkg || seen[t] {
+				return false
+			}
+			seen[t] = true
+			return walk(t.Underlying())
+		case *types.Pointer:
+			return walk(t.Elem())
+		case *types.Slice:
+			return walk(t.Elem())
+		case *types.Array:
+			return walk(t.Elem())
+		case *types.Map:
+			return walk(t.Key()) || walk(t.Elem())
+		case *types.Struct:
+			for i := 0; i < t.NumFields(); i++ {
+				if walk(t.Field(i).Type()) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return walk(target.Underlying())
+}
+
+// generated reports whether methods are being generated for t
+func (g *generator) generated(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() == g.pkg && g.names[named.Obj().Name()]
+}
+
+// basicMethods names the Writer and Reader methods for each basic kind
+var basicMethods = map[types.BasicKind]string{
+	types.String:     "String",
+	types.Bool:       "Bool",
+	types.Int:        "Int",
+	types.Int8:       "Int8",
+	types.Int16:      "Int16",
+	types.Int32:      "Int32",
+	types.Int64:      "Int64",
+	types.Uint:       "Uint",
+	types.Uint8:      "Uint8",
+	types.Uint16:     "Uint16",
+	types.Uint32:     "Uint32",
+	types.Uint64:     "Uint64",
+	types.Uintptr:    "Uintptr",
+	types.Float32:    "Float32",
+	types.Float64:    "Float64",
+	types.Complex64:  "Complex64",
+	types.Complex128: "Complex128",
+}
+
+// native returns the underlying type of t if the generated code encodes it
+// itself, or nil if it is left to reflection
+func (g *generator) native(t types.Type) types.Type {
+	if custom(t) {
+		return nil
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		if _, ok := basicMethods[u.Kind()]; ok {
+			return u
+		}
+	case *types.Pointer, *types.Slice, *types.Array, *types.Map:
+		return u
+	}
+	return nil
+}
+
+// receiver returns expr in a form which methods can be called on, dropping
+// the dereference of pointer variables
+func receiver(expr string) string {
+	return strings.TrimPrefix(expr, "*")
+}
+
+// address returns the address of expr
+func address(expr string) string {
+	if strings.HasPrefix(expr, "*") {
+		return expr[1:]
+	}
+	return "&" + expr
+}
+
+// index returns an index expression into expr
+func index(expr, i string) string {
+	if strings.HasPrefix(expr, "*") {
+		expr = "(" + expr + ")"
+	}
+	return fmt.Sprintf("%s[%s]", expr, i)
+}
+
+// encode writes the code encoding expr, which must be addressable
+func (g *generator) encode(expr string, t types.Type) {
+	if g.generated(t) {
+		g.printf("%s.gbinEncode(w)", receiver(expr))
+		return
+	}
+	switch u := g.native(t).(type) {
+	case *types.Basic:
+		if !types.Identical(t, u) {
+			expr = fmt.Sprintf("%s(%s)", u.Name(), expr)
+		}
+		g.printf("w.%s(%s)", basicMethods[u.Kind()], expr)
+	case *types.Pointer:
+		n := g.nest()
+		g.printf("{")
+		g.printf("start%s := w.Begin(gbin.PTR)", n)
+		g.printf("w.Zero(%s)", g.zero(u.Elem()))
+		g.printf("if %s == nil {", expr)
+		g.printf("w.Nil()")
+		g.printf("} else {")
+		g.printf("p%s := %s", n, expr)
+		// nil within a pointer is read as a nil pointer, so nil slices and
+		// maps it points to are written empty, as gbin writes them
+		switch e := g.native(u.Elem()).(type) {
+		case *types.Slice:
+			g.encode_elements("*p"+n, "SLICE", e.Elem())
+		case *types.Map:
+			g.encode_map("*p"+n, e)
+		default:
+			g.encode("*p"+n, u.Elem())
+		}
+		g.printf("}")
+		g.printf("w.End(start%s)", n)
+		g.printf("}")
+		g.unnest()
+	case *types.Slice:
+		g.printf("if %s == nil {", expr)
+		g.printf("w.Nil()")
+		g.printf("} else")
+		g.encode_elements(expr, "SLICE", u.Elem())
+	case *types.Array:
+		g.encode_elements(expr, "ARRAY", u.Elem())
+	case *types.Map:
+		g.printf("if %s == nil {", expr)
+		g.printf("w.Nil()")
+		g.printf("} else")
+		g.encode_map(expr, u)
+	default:
+		g.printf("w.Value(%s)", address(expr))
+	}
+}
+
+func (g *generator) encode_map(expr string, u *types.Map) {
+	n := g.nest()
+	g.printf("{")
+	g.printf("start%s := w.Begin(gbin.MAP)", n)
+	g.printf("w.Zero(%s)", g.zero(u.Key()))
+	g.printf("w.Zero(%s)", g.zero(u.Elem()))
+	g.printf("for k%s, v%s := range %s {", n, n, expr)
+	g.encode("k"+n, u.Key())
+	g.encode("v"+n, u.Elem())
+	g.printf("}")
+	g.printf("w.End(start%s)", n)
+	g.printf("}")
+	g.unnest()
+}
+
+func (g *generator) encode_elements(expr, objectType string, elem types.Type) {
+	n := g.nest()
+	g.printf("{")
+	g.printf("start%s := w.Begin(gbin.%s)", n, objectType)
+	g.printf("w.Zero(%s)", g.zero(elem))
+	g.printf("for i%s := range %s {", n, expr)
+	g.encode(index(expr, "i"+n), elem)
+	g.printf("}")
+	g.printf("w.End(start%s)", n)
+	g.printf("}")
+	g.unnest()
+}
+
+// decode writes the code decoding into expr, which must be addressable and
+// hold the zero value of its type
+func (g *generator) decode(expr string, t types.Type) {
+	if g.generated(t) {
+		g.printf("if !%s.gbinDecode(r) {", receiver(expr))
+		g.printf("return false")
+		g.printf("}")
+		return
+	}
+	switch u := g.native(t).(type) {
+	case *types.Basic:
+		ptr := address(expr)
+		if !types.Identical(t, u) {
+			ptr = fmt.Sprintf("(*%s)(%s)", u.Name(), ptr)
+		}
+		g.printf("if !r.%s(%s) {", basicMethods[u.Kind()], ptr)
+		g.printf("return false")
+		g.printf("}")
+	case *types.Pointer:
+		n := g.nest()
+		g.printf("{")
+		g.printf("end%s, ok := r.Begin(gbin.PTR)", n)
+		g.printf("if !ok || !r.Skip() {")
+		g.printf("return false")
+		g.printf("}")
+		g.printf("if !r.Nil() {")
+		g.printf("p%s := new(%s)", n, g.typeString(u.Elem()))
+		g.decode("*p"+n, u.Elem())
+		g.printf("%s = p%s", expr, n)
+		g.printf("}")
+		g.end(n)
+		g.unnest()
+	case *types.Slice:
+		// nil is left as the zero value
+		n := g.nest()
+		g.printf("if !r.Nil() {")
+		g.header(n, "SLICE", 1)
+		g.printf("%s = make(%s, 0)", expr, g.typeString(t))
+		g.printf("for r.More(end%s) {", n)
+		g.printf("var el%s %s", n, g.typeString(u.Elem()))
+		g.decode("el"+n, u.Elem())
+		g.printf("%s = append(%s, el%s)", expr, expr, n)
+		g.printf("}")
+		g.footer(n)
+		g.printf("}")
+		g.unnest()
+	case *types.Array:
+		n := g.nest()
+		g.begin(n, "ARRAY", 1)
+		g.printf("for i%s := range %s {", n, expr)
+		g.printf("if !r.More(end%s) {", n)
+		g.printf("return false")
+		g.printf("}")
+		g.decode(index(expr, "i"+n), u.Elem())
+		g.printf("}")
+		g.end(n)
+		g.unnest()
+	case *types.Map:
+		n := g.nest()
+		g.printf("if !r.Nil() {")
+		g.header(n, "MAP", 2)
+		g.printf("%s = make(%s)", expr, g.typeString(t))
+		g.printf("for r.More(end%s) {", n)
+		g.printf("var k%s %s", n, g.typeString(u.Key()))
+		g.decode("k"+n, u.Key())
+		g.printf("var v%s %s", n, g.typeString(u.Elem()))
+		g.decode("v"+n, u.Elem())
+		g.printf("%s = v%s", index(expr, "k"+n), n)
+		g.printf("}")
+		g.footer(n)
+		g.printf("}")
+		g.unnest()
+	default:
+		g.printf("if !r.Value(%s) {", address(expr))
+		g.printf("return false")
+		g.printf("}")
+	}
+}
+
+// begin writes the code reading the header of a container and skipping the
+// zero values which prefix its contents, in a new block
+func (g *generator) begin(n, objectType string, zeros int) {
+	g.printf("{")
+	g.header(n, objectType, zeros)
+}
+
+// header writes the code reading the header of a container and skipping the
+// zero values which prefix its contents
+func (g *generator) header(n, objectType string, zeros int) {
+	g.printf("end%s, ok := r.Begin(gbin.%s)", n, objectType)
+	g.printf("if !ok%s {", strings.Repeat(" || !r.Skip()", zeros))
+	g.printf("return false")
+	g.printf("}")
+}
+
+// end writes the code checking that a container has been read to its end,
+// closing the block begin opened
+func (g *generator) end(n string) {
+	g.footer(n)
+	g.printf("}")
+}
+
+// footer writes the code checking that a container has been read to its end
+func (g *generator) footer(n string) {
+	g.printf("if !r.End(end%s) {", n)
+	g.printf("return false")
+	g.printf("}")
+}
+
+// empty returns a condition testing whether expr is empty for the purposes of
+// omitempty, or "" if values of its type are never empty
+func (g *generator) empty(expr string, t types.Type) string {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsString != 0:
+			return fmt.Sprintf("len(%s) == 0", expr)
+		case u.Info()&types.IsBoolean != 0:
+			return "!" + expr
+		case u.Info()&types.IsInteger != 0:
+			return expr + " == 0"
+		case u.Info()&types.IsFloat != 0:
+			// negative zero is not empty
+			return fmt.Sprintf("%s.Float64bits(float64(%s)) == 0", g.use("math", "math"), expr)
+		case u.Info()&types.IsComplex != 0:
+			m := g.use("math", "math")
+			return fmt.Sprintf("%s.Float64bits(real(complex128(%s))) == 0 && %s.Float64bits(imag(complex128(%s))) == 0", m, expr, m, expr)
+		}
+	case *types.Slice, *types.Map, *types.Array:
+		return fmt.Sprintf("len(%s) == 0", expr)
+	case *types.Pointer, *types.Interface:
+		return expr + " == nil"
+	}
+	return ""
+}
+
+// defaultAssign writes the code setting expr to the default value given in a
+// struct tag, which is parsed as gbin parses it
+func (g *generator) defaultAssign(expr string, t types.Type, s string) error {
+	target := t
+	if ptr, ok := t.Underlying().(*types.Pointer); ok {
+		target = ptr.Elem()
+	}
+	u, ok := target.Underlying().(*types.Basic)
+	if !ok {
+		return fmt.Errorf("defaults are not supported for type %s", t)
+	}
+	bits := 64
+	switch u.Kind() {
+	case types.Int8, types.Uint8:
+		bits = 8
+	case types.Int16, types.Uint16:
+		bits = 16
+	case types.Int32, types.Uint32, types.Float32:
+		bits = 32
+	case types.Complex64:
+		bits = 64
+	case types.Complex128:
+		bits = 128
+	}
+	var lit string
+	info := u.Info()
+	switch {
+	case info&types.IsString != 0:
+		lit = strconv.Quote(s)
+	case info&types.IsBoolean != 0:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		lit = strconv.FormatBool(b)
+	case info&types.IsUnsigned != 0:
+		v, err := strconv.ParseUint(s, 0, bits)
+		if err != nil {
+			return err
+		}
+		lit = strconv.FormatUint(v, 10)
+	case info&types.IsInteger != 0:
+		v, err := strconv.ParseInt(s, 0, bits)
+		if err != nil {
+			return err
+		}
+		lit = strconv.FormatInt(v, 10)
+	case info&types.IsFloat != 0:
+		v, err := strconv.ParseFloat(s, bits)
+		if err != nil {
+			return err
+		}
+		lit = g.floatLiteral(v, bits)
+	case info&types.IsComplex != 0:
+		v, err := strconv.ParseComplex(s, bits)
+		if err != nil {
+			return err
+		}
+		lit = fmt.Sprintf("complex(%s, %s)", g.floatLiteral(real(v), bits/2), g.floatLiteral(imag(v), bits/2))
+	default:
+		return fmt.Errorf("defaults are not supported for type %s", t)
+	}
+	value := fmt.Sprintf("%s(%s)", g.typeString(target), lit)
+	if target != t {
+		g.printf("%s = new(%s)", expr, g.typeString(target))
+		g.printf("*%s = %s", expr, value)
+	} else {
+		g.printf("%s = %s", expr, value)
+	}
+	return nil
+}
+
+// floatLiteral returns an expression giving exactly the float v
+func (g *generator) floatLiteral(v float64, bits int) string {
+	m := g.use("math", "math")
+	if bits == 32 {
+		return fmt.Sprintf("%s.Float32frombits(0x%08x)", m, math.Float32bits(float32(v)))
+	}
+	return fmt.Sprintf("%s.Float64frombits(0x%016x)", m, math.Float64bits(v))
+}
+
+// source assembles and formats the generated file
+func (g *generator) source() ([]byte, error) {
+	buf := bytes.NewBufferString(generatedHeader + "\n\n")
+	fmt.Fprintf(buf, "package %s\n\n", g.pkg.Name())
+	paths := []string{}
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	buf.WriteString("import (\n")
+	for _, path := range paths {
+		name := g.imports[path]
+		if name == filepath.Base(path) {
+			fmt.Fprintf(buf, "%q\n", path)
+		} else {
+			fmt.Fprintf(buf, "%s %q\n", name, path)
+		}
+	}
+	buf.WriteString(")\n\n")
+	if len(g.zeros) > 0 {
+		buf.WriteString("// zero values which prefix the contents of containers\n")
+		buf.WriteString("var (\n")
+		for i, ts := range g.zeros {
+			fmt.Fprintf(buf, "%sZero%d = gbin.ZeroOf[%s]()\n", g.prefix, i, ts)
+		}
+		buf.WriteString(")\n\n")
+	}
+	buf.Write(g.body.Bytes())
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid code: %s", err.Error())
+	}
+	return src, nil
+}
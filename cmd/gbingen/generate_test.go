@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpToDate(t *testing.T) {
+	dir := filepath.Join("..", "..", "gbin", "internal", "gentest")
+	src, err := generate(dir, []string{"Point", "Shape", "Record", "Entry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := os.ReadFile(filepath.Join(dir, "gen_gbin.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, committed) {
+		t.Fatal("gen_gbin.go is out of date, run go generate in gbin/internal/gentest")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	cases := []struct {
+		src     string
+		names   []string
+		message string
+	}{
+		{"type A struct{}", []string{"B"}, "not found"},
+		{"type A int", []string{"A"}, "not a struct"},
+		{"type A[T any] struct{ V T }", []string{"A"}, "generic"},
+		{"type A struct{ Next *A }", []string{"A"}, "recursive"},
+		{"type A struct{ B []B }\ntype B struct{ A map[string]A }", []string{"A"}, "recursive"},
+		{"type A struct{ V int `gbin:\",default=x\"`}", []string{"A"}, "invalid default"},
+		{"type A struct{ V int `gbin:\",bogus\"`}", []string{"A"}, "unknown gbin tag option"},
+		{"type A struct{ V int `gbin:\",omitempty,default=5\"`}", []string{"A"}, "both omitempty and have a default"},
+		{"type A struct{ V, W int `gbin:\"V\"`}", []string{"A"}, "both encoded as V"},
+		{"type A struct{ V Missing }", []string{"A"}, "could not be determined"},
+		{"type A struct{}\nfunc (A) MarshalText() ([]byte, error) { return nil, nil }", []string{"A"}, "already has marshaling methods"},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n\n"+c.src+"\n"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = generate(dir, c.names)
+		if err == nil || !strings.Contains(err.Error(), c.message) {
+			t.Errorf("expected an error containing %q for %q, got %v", c.message, c.src, err)
+		}
+	}
+}
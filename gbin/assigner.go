@@ -1,229 +1,462 @@
 package gbin
 
 import (
-	"bytes"
 	"fmt"
 	"reflect"
-
-	"github.com/lspaccatrosi16/go-libs/structures/set"
-	"github.com/lspaccatrosi16/go-libs/structures/stack"
 )
 
-type assigner[T any] struct {
-	stack stack.Stack[string]
-}
-
-func newAssigner[T any]() *assigner[T] {
-	return &assigner[T]{}
+// assigner decodes values straight into a target of a known type, reading
+// them from a decodeTransformer as it walks the target. Only interface values
+// are decoded without reference to a target type.
+//
+// Targets are decoded in place and must hold the zero value of their type,
+// unless reuse is set. Then any value may be held, and the slices and maps in
+// it are reused to hold the decoded values.
+type assigner struct {
+	stack *trace
+	tf    *decodeTransformer
+	reuse bool
 }
 
-func (a *assigner[T]) trace() string {
-	buf := bytes.NewBufferString("/")
-	a.stack.Reverse()
-	for {
-		val, ok := a.stack.Pop()
-		if !ok {
-			break
-		}
-		formatted := fmt.Sprintf("%s/", val)
-		buf.WriteString(formatted)
+func newAssigner(tf *decodeTransformer) *assigner {
+	return &assigner{
+		stack: tf.stack,
+		tf:    tf,
 	}
-	return buf.String()
 }
 
-func (a *assigner[T]) assign(decoded *reflect.Value) (*T, error) {
-	refType := reflect.TypeOf(*new(T))
-	assigned, err := a.visit(refType, decoded)
+// assign decodes the next value into target, which must be settable and may
+// hold any value. Unless values are being reused, target is left unchanged if
+// decoding fails.
+func (a *assigner) assign(target reflect.Value) error {
+	if a.reuse {
+		return a.visit(target)
+	}
+	decoded := reflect.New(target.Type()).Elem()
+	err := a.visit(decoded)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	converted := assigned.Interface().(T)
-	return &converted, nil
+	target.Set(decoded)
+	return nil
 }
 
-func scalars() *set.Set[reflect.Kind] {
-	sc := set.NewSet[reflect.Kind]()
-	sc.Add(reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64, reflect.Uint, reflect.Uint64, reflect.Uint8)
-	return sc
+// scalars are the kinds which are decoded as a single value
+var scalars = map[reflect.Kind]bool{
+	reflect.String: true, reflect.Bool: true, reflect.Int: true, reflect.Int64: true, reflect.Float64: true,
+	reflect.Uint: true, reflect.Uint64: true, reflect.Uint8: true, reflect.Int8: true, reflect.Int16: true,
+	reflect.Int32: true, reflect.Uint16: true, reflect.Uint32: true, reflect.Uintptr: true, reflect.Float32: true,
+	reflect.Complex64: true, reflect.Complex128: true,
 }
 
-func (a *assigner[T]) visit(ref reflect.Type, decoded *reflect.Value) (*reflect.Value, error) {
-	if !a.matches(ref, decoded) {
-		return nil, fmt.Errorf("type %s does not match reference type of %s", decoded.Kind(), ref.Kind())
+// visit reads the next value and stores it in target, which must be settable
+func (a *assigner) visit(target reflect.Value) error {
+	err := a.tf.descend()
+	if err != nil {
+		return err
 	}
-	var visited *reflect.Value
-	var visitError error
-	if scalars().Contains(decoded.Kind()) {
-		visited, visitError = a.visit_scalar(ref, decoded)
-	} else {
-		switch decoded.Kind() {
-		case reflect.Interface:
-			visited, visitError = a.visit_interface(ref, decoded)
-		case reflect.Map:
-			visited, visitError = a.visit_map(ref, decoded)
-		case reflect.Struct:
-			visited, visitError = a.visit_struct(ref, decoded)
-		case reflect.Pointer:
-			visited, visitError = a.visit_ptr(ref, decoded)
-		case reflect.Slice:
-			visited, visitError = a.visit_slice(ref, decoded)
-		default:
-			return nil, fmt.Errorf("type: %s is not currently supported for serialization", decoded.Kind())
+	defer a.tf.ascend()
+	if custom, err := a.visit_custom(target); custom || err != nil {
+		return err
+	}
+	objectType, payloadLen, err := a.tf.header()
+	if err != nil {
+		return err
+	}
+	return a.visit_payload(target, objectType, payloadLen)
+}
+
+func (a *assigner) visit_payload(target reflect.Value, objectType EncodedType, payloadLen uint64) error {
+	ref := target.Type()
+	if objectType == INVALID {
+		return a.visit_nil(target, payloadLen)
+	} else if ref.Kind() == reflect.Interface {
+		return a.visit_interface(target, objectType, payloadLen)
+	} else if objectType == INTERFACE {
+		a.stack.Push("interface")
+		err := a.visit(target)
+		if err != nil {
+			return err
 		}
+		a.stack.Pop()
+		return nil
+	} else if objectType == TYPED {
+		return a.visit_typed(target, payloadLen)
+	}
+	switch objectType {
+	case BYTES:
+		return a.visit_bytes(target, payloadLen)
+	case REFPTR:
+		return a.visit_ref_ptr(target, payloadLen)
+	case REF:
+		return a.visit_ref(target, payloadLen)
+	case EMBEDDED:
+		return a.visit_embedded(target, payloadLen)
+	}
+	decodedKind, ok := controlKind[objectType]
+	if !ok {
+		return fmt.Errorf("encoding error: unknown type code 0x%x", byte(objectType))
+	}
+	if !a.matches(ref, decodedKind) {
+		return mismatch(ref.Kind(), decodedKind, "type %s does not match reference type of %s", decodedKind, ref.Kind())
+	}
+	if scalars[decodedKind] {
+		return a.visit_scalar(target, objectType, payloadLen)
 	}
-	if visitError != nil {
-		return nil, visitError
-	} else if visited == nil {
-		return nil, fmt.Errorf("visited value is nil")
+	switch decodedKind {
+	case reflect.Map:
+		return a.visit_map(target, payloadLen)
+	case reflect.Struct:
+		return a.visit_struct(target, payloadLen)
+	case reflect.Pointer:
+		return a.visit_ptr(target, payloadLen)
+	case reflect.Slice:
+		return a.visit_slice(target, payloadLen)
+	case reflect.Array:
+		return a.visit_array(target, payloadLen)
+	default:
+		return unsupportedError("type: %s is not currently supported for serialization", decodedKind)
 	}
-	return visited, nil
 }
 
-func (a *assigner[T]) visit_nil(ref reflect.Type, decoded *reflect.Value) (*reflect.Value, error) {
-	val := reflect.New(ref).Elem()
-	fmt.Printf("new value created %s %v\n", val.Kind(), val.Interface())
-	return &val, nil
+func (a *assigner) visit_nil(target reflect.Value, payloadLen uint64) error {
+	err := a.tf.skip(payloadLen)
+	if err != nil {
+		return err
+	}
+	target.Set(reflect.Zero(target.Type()))
+	return nil
 }
 
-func (a *assigner[T]) visit_interface(ref reflect.Type, decoded *reflect.Value) (*reflect.Value, error) {
+// visit_interface decodes a value without reference to a target type, as the
+// concrete type held by the interface is not known ahead of time
+func (a *assigner) visit_interface(target reflect.Value, objectType EncodedType, payloadLen uint64) error {
 	a.stack.Push("interface")
-	decInner := decoded.Elem()
-	visited, err := a.visit(decInner.Type(), &decInner)
+	decoded, err := a.tf.decode_payload(objectType, payloadLen)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if decoded.Kind() == reflect.Interface {
+		*decoded = decoded.Elem()
+	}
+	if !decoded.IsValid() {
+		target.Set(reflect.Zero(target.Type()))
+	} else if decoded.Type().AssignableTo(target.Type()) {
+		target.Set(*decoded)
+	} else if objectType != TYPED {
+		return mismatch(target.Type(), decoded.Type(), "decoded type %s cannot be assigned to %s, as its concrete type was not registered with gbin.Register when encoded", decoded.Type(), target.Type())
+	} else {
+		return mismatch(target.Type(), decoded.Type(), "decoded type %s cannot be assigned to %s", decoded.Type(), target.Type())
 	}
-	outer := reflect.New(reflect.TypeOf(visited.Interface())).Elem()
-	outer.Set(*visited)
 	a.stack.Pop()
-	return &outer, nil
+	return nil
 }
 
-func (a *assigner[T]) visit_map(ref reflect.Type, decoded *reflect.Value) (*reflect.Value, error) {
+func (a *assigner) visit_map(target reflect.Value, payloadLen uint64) error {
+	ref := target.Type()
 	keyType := ref.Key()
 	valType := ref.Elem()
-	stackEntry := fmt.Sprintf("map[%s]%s", keyType.Kind(), valType.Kind())
-	a.stack.Push(stackEntry)
-	iter := decoded.MapRange()
-	newMap := reflect.MakeMap(ref)
-	for {
-		if !iter.Next() {
-			break
-		}
-		k := iter.Key()
-		kEntry := fmt.Sprintf("key[%v]", k.Interface())
-		a.stack.Push(kEntry)
-		kVisited, err := a.visit(keyType, &k)
+	a.stack.PushType("map", ref)
+	end := a.tf.offset + payloadLen
+	descs, err := a.tf.element_types(2)
+	if err != nil {
+		return err
+	}
+	newMap := target
+	if a.reuse && !target.IsNil() {
+		newMap.Clear()
+	} else {
+		newMap = reflect.MakeMap(ref)
+	}
+	// entries are copied into the map, so one key and value are decoded into
+	// for every entry
+	k := reflect.New(keyType).Elem()
+	v := reflect.New(valType).Elem()
+	for count := 1; a.tf.offset < end; count++ {
+		err = a.tf.check_count(count)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		k.SetZero()
+		a.stack.Push("key")
+		err := a.visit_element(k, descs[0])
+		if err != nil {
+			return err
 		}
 		a.stack.Pop()
-		vEntry := fmt.Sprintf("val[%v]", k.Interface())
-		a.stack.Push(vEntry)
-
-		v := iter.Value()
-		vVisited, err := a.visit(valType, &v)
+		a.stack.Key(k)
+		v.SetZero()
+		err = a.visit_element(v, descs[1])
 		if err != nil {
-			return nil, err
+			return err
 		}
 		a.stack.Pop()
-		newMap.SetMapIndex(*kVisited, *vVisited)
+		newMap.SetMapIndex(k, v)
 	}
+	err = a.tf.expectEnd(end)
+	if err != nil {
+		return err
+	}
+	target.Set(newMap)
 	a.stack.Pop()
-	return &newMap, nil
+	return nil
 }
 
-func (a *assigner[T]) visit_struct(ref reflect.Type, decoded *reflect.Value) (*reflect.Value, error) {
+func (a *assigner) visit_struct(target reflect.Value, payloadLen uint64) error {
 	a.stack.Push("struct")
-	n := decoded.NumField()
-	decType := decoded.Type()
-	newStruct := reflect.New(ref).Elem()
-	for i := 0; i < n; i++ {
-		dFieldT := decType.Field(i)
-		dFieldV := decoded.Field(i)
-		name := dFieldT.Name
-		fEntry := fmt.Sprintf("field[%s]", name)
-		a.stack.Push(fEntry)
-		rField, found := ref.FieldByName(name)
-		if !found {
-			return nil, fmt.Errorf("decoded struct has field of name %s but not found in reference type", name)
-		}
-		neededType := rField.Type
+	ref := target.Type()
+	end := a.tf.offset + payloadLen
+	plan := planOf(ref)
+	fields := plan.fieldsFor(a.tf.opts)
+	if fields.err != nil {
+		return fields.err
+	}
+	// fields which are absent from the data must end up zero, so a reused
+	// struct is cleared, keeping a copy of it to reuse the fields which are
+	// present
+	var previous reflect.Value
+	if a.reuse {
+		previous = reflect.New(ref).Elem()
+		previous.Set(target)
+		target.SetZero()
+	}
+	for _, field := range fields.fields {
+		if field.defaultVal != nil {
+			fieldVal, err := settableField(target, field.index)
+			if err != nil {
+				return err
+			}
+			fieldVal.Set(field.defaultValue())
+		}
+	}
+	for count := 1; a.tf.offset < end; count++ {
+		err := a.tf.check_count(count)
+		if err != nil {
+			return err
+		}
+		name, err := a.tf.read_name("struct key")
+		if err != nil {
+			return err
+		}
+		a.stack.Field(name)
+		field, found := fields.byName[name]
+		if index, ok := plan.embedded[name]; !found && ok {
+			// embedded structs were encoded as fields before their fields
+			// were promoted
+			field, found = fieldInfo{index: []int{index}}, true
+		} else if !found {
+			// fields which no longer exist in the reference type are skipped
+			err = a.tf.skip_value()
+			if err != nil {
+				return err
+			}
+			a.stack.Pop()
+			continue
+		}
 		a.stack.Push("val")
-		visited, vErr := a.visit(neededType, &dFieldV)
-		if vErr != nil {
-			return nil, vErr
+		fieldVal, err := settableField(target, field.index)
+		if err != nil {
+			return err
+		}
+		if a.reuse && !field.indirect {
+			fieldVal.Set(previous.FieldByIndex(field.index))
+		} else {
+			fieldVal.SetZero()
+		}
+		err = a.visit(fieldVal)
+		if err != nil {
+			return err
 		}
 		a.stack.Pop()
 		a.stack.Pop()
-		newStruct.FieldByName(name).Set(*visited)
+	}
+	err := a.tf.expectEnd(end)
+	if err != nil {
+		return err
 	}
 	a.stack.Pop()
-	return &newStruct, nil
+	return nil
 }
 
-func (a *assigner[T]) visit_ptr(ref reflect.Type, decoded *reflect.Value) (*reflect.Value, error) {
+// visit_ptr decodes into a newly allocated value, even when values are being
+// reused, as the value previously pointed to may be shared
+func (a *assigner) visit_ptr(target reflect.Value, payloadLen uint64) error {
 	a.stack.Push("ptr")
-	refPointedAt := ref.Elem()
-	decPointedAt := decoded.Elem()
-	if decPointedAt.Kind() == reflect.Invalid {
-		targetVal := reflect.New(ref).Elem().Interface()
-		targetReflect := reflect.ValueOf(targetVal)
-		return &targetReflect, nil
+	end := a.tf.offset + payloadLen
+	err := a.skip_zeros(1)
+	if err != nil {
+		return err
+	}
+	ptr := reflect.New(target.Type().Elem())
+	objectType, err := a.tf.peek_type()
+	if err != nil {
+		return err
+	}
+	if objectType == INVALID {
+		err = a.tf.skip_value()
+		ptr = reflect.Zero(target.Type())
 	} else {
-		visited, err := a.visit(refPointedAt, &decPointedAt)
+		err = a.visit(ptr.Elem())
+	}
+	if err != nil {
+		return err
+	}
+	err = a.tf.expectEnd(end)
+	if err != nil {
+		return err
+	}
+	target.Set(ptr)
+	a.stack.Pop()
+	return nil
+}
+
+func (a *assigner) visit_slice(target reflect.Value, payloadLen uint64) error {
+	ref := target.Type()
+	a.stack.PushType("slice", ref)
+	end := a.tf.offset + payloadLen
+	descs, err := a.tf.element_types(1)
+	if err != nil {
+		return err
+	}
+	var newSlice reflect.Value
+	if a.reuse && !target.IsNil() {
+		newSlice = target.Slice(0, 0)
+	} else {
+		newSlice = reflect.MakeSlice(ref, 0, 0)
+	}
+	zero := reflect.Zero(ref.Elem())
+	for i := 0; a.tf.offset < end; i++ {
+		err = a.tf.check_count(i + 1)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		// fmt.Printf("got inner value %s %v\n", visited.Kind(), visited.Interface())
-		var ptr reflect.Value
-		if visited.CanAddr() {
-			ptr = visited.Addr()
+		a.stack.Index(i)
+		// elements are decoded in place, reusing those already in the backing
+		// array when there is capacity for them
+		if i < newSlice.Cap() {
+			newSlice = newSlice.Slice(0, i+1)
+			if !a.reuse {
+				newSlice.Index(i).SetZero()
+			}
 		} else {
-			ptr = reflect.New(visited.Type())
-			ptr.Elem().Set(*visited)
+			newSlice = reflect.Append(newSlice, zero)
+		}
+		err := a.visit_element(newSlice.Index(i), descs[0])
+		if err != nil {
+			return err
 		}
 		a.stack.Pop()
-		// fmt.Printf("visit ptr %s %v %s %v\n", ptr.Kind(), ptr.Interface(), decoded.Kind(), decoded.Interface())
-		return &ptr, nil
 	}
+	err = a.tf.expectEnd(end)
+	if err != nil {
+		return err
+	}
+	target.Set(newSlice)
+	a.stack.Pop()
+	return nil
 }
 
-func (a *assigner[T]) visit_slice(ref reflect.Type, decoded *reflect.Value) (*reflect.Value, error) {
-	a.stack.Push(fmt.Sprintf("slice[%s]", decoded.Type().Elem().Kind()))
-	n := decoded.Len()
-	newSlice := reflect.MakeSlice(ref, 0, 0)
-	for i := 0; i < n; i++ {
-		el := decoded.Index(i)
-		a.stack.Push(fmt.Sprintf("el%d", i))
-		visited, err := a.visit(ref.Elem(), &el)
+func (a *assigner) visit_array(target reflect.Value, payloadLen uint64) error {
+	ref := target.Type()
+	a.stack.PushType("array", ref)
+	end := a.tf.offset + payloadLen
+	descs, err := a.tf.element_types(1)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for ; a.tf.offset < end; n++ {
+		if n >= ref.Len() {
+			return mismatch(ref, "longer array", "decoded array is longer than reference type length %d", ref.Len())
+		}
+		err = a.tf.check_count(n + 1)
+		if err != nil {
+			return err
+		}
+		a.stack.Index(n)
+		err := a.visit_element(target.Index(n), descs[0])
 		if err != nil {
-			return nil, err
+			return err
 		}
-		newSlice = reflect.Append(newSlice, *visited)
 		a.stack.Pop()
 	}
+	if n != ref.Len() {
+		return mismatch(ref, fmt.Sprintf("array of %d", n), "decoded array has length %d but reference type has length %d", n, ref.Len())
+	}
+	err = a.tf.expectEnd(end)
+	if err != nil {
+		return err
+	}
 	a.stack.Pop()
-	return &newSlice, nil
+	return nil
+}
+
+// visit_bytes decodes an opaque byte payload, written by a codec or marshaler
+// that the target type does not have, into a byte slice
+func (a *assigner) visit_bytes(target reflect.Value, payloadLen uint64) error {
+	ref := target.Type()
+	if ref.Kind() != reflect.Slice || ref.Elem().Kind() != reflect.Uint8 {
+		return mismatch(ref, controlName(BYTES), "type %s does not match reference type of %s", controlName(BYTES), ref)
+	}
+	data, err := a.tf.readN(payloadLen)
+	if err != nil {
+		return err
+	}
+	target.Set(reflect.ValueOf(data).Convert(ref))
+	return nil
 }
 
-func (a *assigner[T]) visit_scalar(ref reflect.Type, decoded *reflect.Value) (*reflect.Value, error) {
-	a.stack.Push(fmt.Sprintf("scalar[%s]", decoded.Type().Kind()))
+func (a *assigner) visit_scalar(target reflect.Value, objectType EncodedType, payloadLen uint64) error {
+	err := a.tf.read_scalar(target, objectType, payloadLen)
+	if err != nil {
+		// scalars are leaves, so only need to be traced on failure
+		a.stack.PushType("scalar", target.Type())
+	}
+	return err
+}
+
+// set_scalar converts a decoded scalar to the type of target and stores it
+func (a *assigner) set_scalar(target reflect.Value, decoded *reflect.Value) error {
+	ref := target.Type()
+	if ref.Kind() == reflect.Interface {
+		if !decoded.Type().AssignableTo(ref) {
+			return mismatch(ref, decoded.Type(), "decoded type %s cannot be assigned to %s", decoded.Type(), ref)
+		}
+		target.Set(*decoded)
+		return nil
+	}
+	a.stack.PushType("scalar", decoded.Type())
 	if !decoded.CanConvert(ref) {
-		return nil, fmt.Errorf("cannot convert type %s to %s", decoded.Kind(), ref.Kind())
+		return mismatch(ref.Kind(), decoded.Kind(), "cannot convert type %s to %s", decoded.Kind(), ref.Kind())
 	}
-	converted := decoded.Convert(ref)
-	newVal := reflect.New(ref).Elem()
-	newVal.Set(converted)
+	switch ref.Kind() {
+	case reflect.Int:
+		if target.OverflowInt(decoded.Int()) {
+			return fmt.Errorf("value %d overflows %s on this platform", decoded.Int(), ref)
+		}
+	case reflect.Uint, reflect.Uintptr:
+		if target.OverflowUint(decoded.Uint()) {
+			return fmt.Errorf("value %d overflows %s on this platform", decoded.Uint(), ref)
+		}
+	}
+	target.Set(decoded.Convert(ref))
 	a.stack.Pop()
-	return &newVal, nil
+	return nil
+}
+
+// skip_zeros discards the zero values that prefix container payloads, which
+// are only needed when decoding without a target type
+func (a *assigner) skip_zeros(n int) error {
+	_, err := a.tf.element_types(n)
+	return err
 }
 
-func (a *assigner[T]) matches(x reflect.Type, y *reflect.Value) bool {
-	if x.Kind() == reflect.Invalid || y.Kind() == reflect.Invalid {
+func (a *assigner) matches(x reflect.Type, y reflect.Kind) bool {
+	if x.Kind() == reflect.Invalid || y == reflect.Invalid {
 		return true
 	}
-	if (x.Kind() == reflect.Int || x.Kind() == reflect.Int64) && ((*y).Kind() == reflect.Int || (*y).Kind() == reflect.Int64) {
+	if (x.Kind() == reflect.Int || x.Kind() == reflect.Int64) && (y == reflect.Int || y == reflect.Int64) {
 		return true
 	}
-	return x.Kind() == (*y).Kind()
+	return x.Kind() == y
 }
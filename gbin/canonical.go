@@ -0,0 +1,120 @@
+package gbin
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// Hash returns the SHA-256 hash of the canonical encoding of v, so that equal
+// values have equal hashes however their maps were built. Other options are
+// applied to the encoding as given.
+func Hash(v any, opts ...Option) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	w := bufio.NewWriter(h)
+	opts = append(opts[:len(opts):len(opts)], WithCanonical())
+	err := encodeValue(w, addressableValue(v), newOptions(opts))
+	if err != nil {
+		return sum, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return sum, writeError(err)
+	}
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// sort_entries sorts the entries of a map of type mt by the encodings of their
+// keys, breaking ties between keys which encode the same, such as distinct
+// pointers to equal values or NaNs, by the encodings of their values
+func (t *encodeTransformer) sort_entries(mt reflect.Type, entries []mapEntry) error {
+	keyPacked, valPacked := t.element_packed(mt.Key()), t.element_packed(mt.Elem())
+	encodedKeys := make([][]byte, len(entries))
+	for i, entry := range entries {
+		var err error
+		encodedKeys[i], err = t.encoded(entry.key, keyPacked)
+		if err != nil {
+			return err
+		}
+	}
+	encodedVals := map[int][]byte{}
+	var err error
+	encodedVal := func(i int) []byte {
+		if encoded, ok := encodedVals[i]; ok || err != nil {
+			return encoded
+		}
+		encodedVals[i], err = t.encoded(entries[i].value, valPacked)
+		return encodedVals[i]
+	}
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		x, y := order[i], order[j]
+		if c := bytes.Compare(encodedKeys[x], encodedKeys[y]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(encodedVal(x), encodedVal(y)) < 0
+	})
+	if err != nil {
+		return err
+	}
+	sorted := make([]mapEntry, len(entries))
+	for i, index := range order {
+		sorted[i] = entries[index]
+	}
+	copy(entries, sorted)
+	return nil
+}
+
+// encoded returns the encoding of v on its own, as an element of a container
+func (t *encodeTransformer) encoded(v reflect.Value, packed bool) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{})
+	w := bufio.NewWriter(buf)
+	inner := newEncodeTransformer(w, t.opts)
+	err := inner.encode_element(v, packed)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// canonicalScalar returns v with negative zero and NaN floats replaced by
+// positive zero and the standard NaN, which are otherwise written as their
+// differing bits
+func canonicalScalar(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f != 0 && !math.IsNaN(f) {
+			return v
+		}
+		canonical := reflect.New(v.Type()).Elem()
+		canonical.SetFloat(canonicalFloat(f))
+		return canonical
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		canonical := reflect.New(v.Type()).Elem()
+		canonical.SetComplex(complex(canonicalFloat(real(c)), canonicalFloat(imag(c))))
+		return canonical
+	}
+	return v
+}
+
+func canonicalFloat(f float64) float64 {
+	if f == 0 {
+		return 0
+	} else if math.IsNaN(f) {
+		return math.NaN()
+	}
+	return f
+}
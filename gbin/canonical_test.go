@@ -0,0 +1,192 @@
+package gbin_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+type canonicalRecord struct {
+	Counts map[string]int
+	Nested map[int16]map[string][]float64
+	Any    any
+}
+
+// canonicalRecords returns two equal records whose maps are built in
+// different orders
+func canonicalRecords() (canonicalRecord, canonicalRecord) {
+	build := func(reverse bool) canonicalRecord {
+		r := canonicalRecord{
+			Counts: map[string]int{},
+			Nested: map[int16]map[string][]float64{},
+			Any:    map[string]any{},
+		}
+		for i := 0; i < 100; i++ {
+			n := i
+			if reverse {
+				n = 99 - i
+			}
+			r.Counts[fmt.Sprint("k", n)] = n
+			r.Nested[int16(n%7)] = map[string][]float64{fmt.Sprint(n % 7): {float64(n % 7)}, "x": {}}
+			r.Any.(map[string]any)[fmt.Sprint(n)] = uint8(n)
+		}
+		return r
+	}
+	return build(false), build(true)
+}
+
+func TestCanonical(t *testing.T) {
+	a, b := canonicalRecords()
+	for _, opts := range [][]gbin.Option{{gbin.WithCanonical()}, {gbin.WithCanonical(), gbin.WithCompact()}, {gbin.WithCanonical(), gbin.WithReferences()}} {
+		if pass := runTest(a, opts...); !pass {
+			t.Fatal("failed to round trip a canonical encoding")
+		}
+		encoder := gbin.NewEncoder[canonicalRecord](opts...)
+		expected, err := encoder.Encode(&a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			encoded, err := encoder.Encode(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(expected, encoded) {
+				t.Fatal("expected equal values to have the same canonical encoding")
+			}
+		}
+	}
+}
+
+func TestCanonicalTies(t *testing.T) {
+	// distinct pointers to equal values encode the same as keys
+	x, y := 1, 1
+	data := map[*int]string{&x: "a", &y: "b"}
+	encoder := gbin.NewEncoder[map[*int]string](gbin.WithCanonical())
+	expected, err := encoder.Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		encoded, err := encoder.Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, encoded) {
+			t.Fatal("expected keys which encode the same to be ordered by their values")
+		}
+	}
+}
+
+func TestCanonicalFloats(t *testing.T) {
+	type floats struct {
+		F float64
+		G []float32
+		C complex64
+		M map[float64]bool
+	}
+	otherNaN := math.Float64frombits(0x7ff8000000000001)
+	a := floats{F: 0, G: []float32{float32(math.NaN())}, C: complex(0, float32(math.NaN())), M: map[float64]bool{0: true}}
+	b := floats{F: math.Copysign(0, -1), G: []float32{float32(otherNaN)}, C: complex(float32(math.Copysign(0, -1)), float32(otherNaN)), M: map[float64]bool{math.Copysign(0, -1): true}}
+	for _, opts := range [][]gbin.Option{{gbin.WithCanonical()}, {gbin.WithCanonical(), gbin.WithCompact()}} {
+		encoder := gbin.NewEncoder[floats](opts...)
+		x, err := encoder.Encode(&a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y, err := encoder.Encode(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(x, y) {
+			t.Fatalf("expected zeros and NaNs to encode the same, got % x and % x", x, y)
+		}
+	}
+}
+
+func TestCanonicalNaNKeys(t *testing.T) {
+	// NaN keys are never equal, so a map can hold several, which must each be
+	// written with their own values in an order which does not depend on the
+	// map's iteration order
+	nan := math.NaN()
+	m := map[float64]string{nan: "b", 1: "c"}
+	m[nan] = "a"
+	encoder := gbin.NewEncoder[map[float64]string](gbin.WithCanonical())
+	first, err := encoder.Encode(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := gbin.Hash(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		encoded, err := encoder.Encode(&m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, first) {
+			t.Fatalf("expected the same encoding each time, got % x and % x", first, encoded)
+		}
+		if h, err := gbin.Hash(m); err != nil || h != hash {
+			t.Fatalf("expected the same hash each time (%v)", err)
+		}
+	}
+	decoded, err := gbin.NewDecoder[map[float64]string]().Decode(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []string{}
+	for k, v := range *decoded {
+		if math.IsNaN(k) {
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	if len(*decoded) != 3 || !reflect.DeepEqual(values, []string{"a", "b"}) {
+		t.Fatalf("expected both NaN keys to keep their values, got %v", *decoded)
+	}
+}
+
+func TestHash(t *testing.T) {
+	a, b := canonicalRecords()
+	hashA, err := gbin.Hash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashB, err := gbin.Hash(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashA != hashB {
+		t.Fatal("expected equal values to have equal hashes")
+	}
+	encoded, err := gbin.NewEncoder[canonicalRecord](gbin.WithCanonical()).Encode(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashA != sha256.Sum256(encoded) {
+		t.Fatal("expected the hash of the canonical encoding")
+	}
+	b.Counts["k0"] = -1
+	hashB, err = gbin.Hash(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashA == hashB {
+		t.Fatal("expected different values to have different hashes")
+	}
+	compact, err := gbin.Hash(a, gbin.WithCompact())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compact == hashA {
+		t.Fatal("expected options to apply to the hashed encoding")
+	}
+}
@@ -0,0 +1,664 @@
+package gbin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// The compact format is selected WithCompact, and begins with a FORMAT byte
+// holding its version. It differs from the standard format in that:
+//
+//   - the length bits of a control byte hold payload lengths below 7 directly,
+//     with 7 meaning that a uvarint length follows
+//   - signed integers are zigzag varints and unsigned integers wider than a
+//     byte are uvarints
+//   - containers are prefixed by type descriptors instead of zero values
+//   - elements of containers whose descriptor is a scalar are packed, written
+//     as bare payloads without control bytes
+//   - the output of Marshalers, which is always in the standard format, is
+//     written as an EMBEDDED value
+//
+// DESCRIPTOR: TYPE CODE, FOLLOWED BY
+//   - PTR, SLICE: ELEMENT DESCRIPTOR
+//   - ARRAY: UVARINT LENGTH, ELEMENT DESCRIPTOR
+//   - MAP: KEY DESCRIPTOR, VALUE DESCRIPTOR
+//   - STRUCT: UVARINT FIELD COUNT, (UVARINT NAME LENGTH, NAME, DESCRIPTOR)...
+//
+// Types with custom encodings are described as INTERFACE, as each of their
+// values carries its own header. A type within its own descriptor is INVALID.
+
+// typeDesc is a decoded type descriptor
+type typeDesc struct {
+	code   EncodedType
+	length uint64
+	key    *typeDesc
+	elem   *typeDesc
+	fields []fieldDesc
+}
+
+type fieldDesc struct {
+	name string
+	desc *typeDesc
+}
+
+// packable reports whether elements described by code are packed
+func packable(code EncodedType) bool {
+	switch code {
+	case STRING, BOOL, INT, INT64, UINT, UINT64, UINT8, FLOAT64, INT8, INT16, INT32, UINT16, UINT32, UINTPTR, FLOAT32, COMPLEX64, COMPLEX128:
+		return true
+	default:
+		return false
+	}
+}
+
+// packed returns the type code of packed elements described by desc, which is
+// nil for the zero values of the standard format
+func packed(desc *typeDesc) (EncodedType, bool) {
+	if desc == nil || !packable(desc.code) {
+		return INVALID, false
+	}
+	return desc.code, true
+}
+
+// begin writes the FORMAT byte which begins compact encodings
+func (t *encodeTransformer) begin() error {
+	if !t.opts.compact {
+		return nil
+	}
+	return t.write([]byte{byte(FORMAT)<<3 | COMPACT_VERSION})
+}
+
+// type_code returns the code describing values of type zt
+func (t *encodeTransformer) type_code(zt reflect.Type) EncodedType {
+	if zt.Kind() != reflect.Pointer && zt.Kind() != reflect.Interface {
+		if _, ok := t.opts.codecs[zt]; ok {
+			return INTERFACE
+		}
+		pt := reflect.PointerTo(zt)
+		for _, iface := range []reflect.Type{marshalerType, binaryMarshalerType, textMarshalerType} {
+			if zt.Implements(iface) || pt.Implements(iface) {
+				return INTERFACE
+			}
+		}
+	}
+	return kindControl[zt.Kind()]
+}
+
+// encode_descriptor writes the type descriptor of zt
+func (t *encodeTransformer) encode_descriptor(zt reflect.Type) error {
+	code := t.type_code(zt)
+	err := t.write([]byte{byte(code)})
+	if err != nil {
+		return err
+	}
+	switch code {
+	case PTR, SLICE:
+		return t.encode_zero(zt.Elem())
+	case ARRAY:
+		err = t.write(binary.AppendUvarint(nil, uint64(zt.Len())))
+		if err != nil {
+			return err
+		}
+		return t.encode_zero(zt.Elem())
+	case MAP:
+		err = t.encode_zero(zt.Key())
+		if err != nil {
+			return err
+		}
+		return t.encode_zero(zt.Elem())
+	case STRUCT:
+		set := planOf(zt).fieldsFor(t.opts)
+		if set.err != nil {
+			return set.err
+		}
+		fields := set.fields
+		err := t.write(binary.AppendUvarint(nil, uint64(len(fields))))
+		if err != nil {
+			return err
+		}
+		for _, field := range fields {
+			name := binary.AppendUvarint(nil, uint64(len(field.name)))
+			err = t.write(append(name, field.name...))
+			if err != nil {
+				return err
+			}
+			err = t.encode_zero(field.typ)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encode_element encodes an element of a container, without a header if its
+// container's elements are packed
+func (t *encodeTransformer) encode_element(v reflect.Value, packed bool) error {
+	if !packed {
+		return t.encode(v)
+	}
+	if t.opts.canonical {
+		v = canonicalScalar(v)
+	}
+	var err error
+	t.scratch, err = appendCompact(t.scratch[:0], scalarData(v))
+	if err != nil {
+		return err
+	}
+	return t.write(t.scratch)
+}
+
+// element_packed reports whether elements of type et are packed
+func (t *encodeTransformer) element_packed(et reflect.Type) bool {
+	return t.opts.compact && packable(t.type_code(et))
+}
+
+// scalarData returns the value of a scalar in the form it is encoded
+func scalarData(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int64:
+		return v.Int()
+	case reflect.Int8:
+		return int8(v.Int())
+	case reflect.Int16:
+		return int16(v.Int())
+	case reflect.Int32:
+		return int32(v.Int())
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	case reflect.Uint8:
+		return uint8(v.Uint())
+	case reflect.Uint16:
+		return uint16(v.Uint())
+	case reflect.Uint32:
+		return uint32(v.Uint())
+	case reflect.Float32:
+		return float32(v.Float())
+	case reflect.Float64:
+		return v.Float()
+	case reflect.Complex64:
+		return complex64(v.Complex())
+	default:
+		return v.Complex()
+	}
+}
+
+// appendCompact appends the compact encoding of a scalar to buf. Strings are
+// prefixed by their length, as is needed when they are packed.
+func appendCompact(buf []byte, data any) ([]byte, error) {
+	switch d := data.(type) {
+	case int8:
+		return binary.AppendVarint(buf, int64(d)), nil
+	case int16:
+		return binary.AppendVarint(buf, int64(d)), nil
+	case int32:
+		return binary.AppendVarint(buf, int64(d)), nil
+	case int64:
+		return binary.AppendVarint(buf, d), nil
+	case uint16:
+		return binary.AppendUvarint(buf, uint64(d)), nil
+	case uint32:
+		return binary.AppendUvarint(buf, uint64(d)), nil
+	case uint64:
+		return binary.AppendUvarint(buf, d), nil
+	case string:
+		buf = binary.AppendUvarint(buf, uint64(len(d)))
+		return append(buf, d...), nil
+	default:
+		return appendFixed(buf, data)
+	}
+}
+
+// begin detects the format of the next value, consuming its schema and the
+// FORMAT byte of compact encodings
+func (t *decodeTransformer) begin() error {
+	t.compact = false
+	t.schema = nil
+	objectType, err := t.peek_type()
+	if err == nil && objectType == SCHEMA {
+		t.schema, err = t.read_schema()
+		if err == nil {
+			objectType, err = t.peek_type()
+		}
+	}
+	if err != nil || objectType != FORMAT {
+		return err
+	}
+	control, err := t.readByte()
+	if err != nil {
+		return err
+	}
+	if version := control & 0b00000111; version != COMPACT_VERSION {
+		return fmt.Errorf("unsupported compact format version %d", version)
+	}
+	t.compact = true
+	return nil
+}
+
+// compact_header reads the length following a compact control byte
+func (t *decodeTransformer) compact_header(control byte, raw *[]byte) (EncodedType, uint64, error) {
+	objectType := EncodedType(control >> 3)
+	payloadLen := uint64(control & 0b00000111)
+	if payloadLen == 7 {
+		var err error
+		payloadLen, err = t.readUvarint()
+		if err != nil {
+			return INVALID, 0, err
+		}
+		if raw != nil {
+			*raw = binary.AppendUvarint(*raw, payloadLen)
+		}
+	}
+	err := t.check_len(payloadLen)
+	if err != nil {
+		return INVALID, 0, err
+	}
+	return objectType, payloadLen, nil
+}
+
+// byteReader reads the input of a decodeTransformer as an io.ByteReader
+type byteReader decodeTransformer
+
+func (r *byteReader) ReadByte() (byte, error) {
+	return (*decodeTransformer)(r).readByte()
+}
+
+func (t *decodeTransformer) readUvarint() (uint64, error) {
+	return binary.ReadUvarint((*byteReader)(t))
+}
+
+func (t *decodeTransformer) readVarint() (int64, error) {
+	return binary.ReadVarint((*byteReader)(t))
+}
+
+// readCompact reads an n byte varint payload into ptr, reporting whether ptr
+// is of a type which the compact format writes as a varint
+func (t *decodeTransformer) readCompact(n uint64, ptr any) (bool, error) {
+	switch ptr.(type) {
+	case *int8, *int16, *int32, *int64:
+		buf, err := t.readN(n)
+		if err != nil {
+			return true, err
+		}
+		v, read := binary.Varint(buf)
+		if read <= 0 || read != len(buf) {
+			return true, fmt.Errorf("malformed varint payload")
+		}
+		return true, storeInt(reflect.ValueOf(ptr).Elem(), v)
+	case *uint16, *uint32, *uint64:
+		buf, err := t.readN(n)
+		if err != nil {
+			return true, err
+		}
+		v, read := binary.Uvarint(buf)
+		if read <= 0 || read != len(buf) {
+			return true, fmt.Errorf("malformed varint payload")
+		}
+		return true, storeUint(reflect.ValueOf(ptr).Elem(), v)
+	default:
+		return false, nil
+	}
+}
+
+func storeInt(target reflect.Value, v int64) error {
+	if target.OverflowInt(v) {
+		return fmt.Errorf("value %d overflows %s", v, target.Type())
+	}
+	target.SetInt(v)
+	return nil
+}
+
+func storeUint(target reflect.Value, v uint64) error {
+	if target.OverflowUint(v) {
+		return fmt.Errorf("value %d overflows %s", v, target.Type())
+	}
+	target.SetUint(v)
+	return nil
+}
+
+// descriptor reads a type descriptor
+func (t *decodeTransformer) descriptor() (*typeDesc, error) {
+	err := t.descend()
+	if err != nil {
+		return nil, err
+	}
+	defer t.ascend()
+	code, err := t.readByte()
+	if err != nil {
+		return nil, err
+	}
+	desc := &typeDesc{code: EncodedType(code)}
+	switch desc.code {
+	case PTR, SLICE:
+		desc.elem, err = t.descriptor()
+	case ARRAY:
+		desc.length, err = t.readUvarint()
+		if err == nil {
+			desc.elem, err = t.descriptor()
+		}
+	case MAP:
+		desc.key, err = t.descriptor()
+		if err == nil {
+			desc.elem, err = t.descriptor()
+		}
+	case STRUCT:
+		var n uint64
+		n, err = t.readUvarint()
+		for i := uint64(0); err == nil && i < n; i++ {
+			err = t.check_count(int(i) + 1)
+			if err != nil {
+				break
+			}
+			var field fieldDesc
+			field.name, err = t.read_string()
+			if err == nil {
+				field.desc, err = t.descriptor()
+			}
+			desc.fields = append(desc.fields, field)
+		}
+	default:
+		if _, ok := controlKind[desc.code]; !ok {
+			return nil, fmt.Errorf("encoding error: unknown type code 0x%x in descriptor", code)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
+
+// read_string reads a string prefixed by its uvarint length
+func (t *decodeTransformer) read_string() (string, error) {
+	n, err := t.readUvarint()
+	if err != nil {
+		return "", err
+	}
+	buf, err := t.readN(n)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// goType creates a Go type for values described by desc
+func (desc *typeDesc) goType() (reflect.Type, error) {
+	switch desc.code {
+	case INVALID:
+		return nil, fmt.Errorf("cannot decode a recursive type without a target type")
+	case INTERFACE:
+		return reflect.TypeOf(iface()).Elem(), nil
+	case PTR, SLICE, ARRAY:
+		elem, err := desc.elem.goType()
+		if err != nil {
+			return nil, err
+		}
+		if desc.code == PTR {
+			return reflect.PointerTo(elem), nil
+		} else if desc.code == SLICE {
+			return reflect.SliceOf(elem), nil
+		}
+		return arrayOf(desc.length, elem)
+	case MAP:
+		key, err := desc.key.goType()
+		if err != nil {
+			return nil, err
+		}
+		if !key.Comparable() {
+			return nil, unsupportedError("found illegal key type for map: %s", key.Kind())
+		}
+		elem, err := desc.elem.goType()
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, elem), nil
+	case STRUCT:
+		fields := []reflect.StructField{}
+		for _, field := range desc.fields {
+			ft, err := field.desc.goType()
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, reflect.StructField{Name: field.name, Type: ft})
+		}
+		return structOf(fields)
+	default:
+		return kindComparableType[controlKind[desc.code]], nil
+	}
+}
+
+// zero reads the zero value or descriptor which prefixes a container's
+// contents, returning the type it describes without building a value of it.
+// The descriptor is nil for the standard format. The zero value of a recursive
+// type is cut short where the type refers to itself, so such types cannot be
+// recreated without a target type.
+func (t *decodeTransformer) zero() (reflect.Type, *typeDesc, error) {
+	var zt reflect.Type
+	var desc *typeDesc
+	if !t.compact {
+		zeroVal, err := t.decode()
+		if err != nil {
+			return nil, nil, err
+		}
+		if zeroVal.IsValid() {
+			zt = zeroVal.Type()
+		}
+	} else {
+		var err error
+		desc, err = t.descriptor()
+		if err != nil {
+			return nil, nil, err
+		}
+		if desc.code != INVALID {
+			zt, err = desc.goType()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if zt == nil {
+		return nil, nil, fmt.Errorf("cannot decode a recursive type without a target type")
+	}
+	return zt, desc, nil
+}
+
+// element_types reads the n zero values or descriptors which prefix a
+// container's contents, returning the descriptors
+func (t *decodeTransformer) element_types(n int) ([]*typeDesc, error) {
+	descs := make([]*typeDesc, n)
+	for i := range descs {
+		var err error
+		if t.compact {
+			descs[i], err = t.descriptor()
+		} else {
+			err = t.skip_value()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return descs, nil
+}
+
+// read_packed reads a packed element, with int, uint and uintptr values
+// widened to 64 bits
+func (t *decodeTransformer) read_packed(code EncodedType) (*reflect.Value, error) {
+	var typ reflect.Type
+	switch code {
+	case INT:
+		typ = reflect.TypeOf(int64(0))
+	case UINT, UINTPTR:
+		typ = reflect.TypeOf(uint64(0))
+	default:
+		typ = kindComparableType[controlKind[code]]
+	}
+	ptr := reflect.New(typ)
+	var err error
+	switch typ.Kind() {
+	case reflect.String:
+		var s string
+		s, err = t.read_string()
+		ptr.Elem().SetString(s)
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var v int64
+		v, err = t.readVarint()
+		if err == nil {
+			err = storeInt(ptr.Elem(), v)
+		}
+	case reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var v uint64
+		v, err = t.readUvarint()
+		if err == nil {
+			err = storeUint(ptr.Elem(), v)
+		}
+	default:
+		err = t.readFixed(uint64(typ.Size()), ptr.Interface())
+	}
+	if err != nil {
+		return nil, err
+	}
+	val := ptr.Elem()
+	return &val, nil
+}
+
+// store_packed reads a packed element straight into target, which must be of
+// the kind decoded from code or, for int and int64, either of them
+func (t *decodeTransformer) store_packed(target reflect.Value, code EncodedType) error {
+	kind := controlKind[code]
+	switch {
+	case kind == reflect.String:
+		s, err := t.read_string()
+		if err != nil {
+			return err
+		}
+		target.SetString(s)
+		return nil
+	case varintSigned[kind]:
+		v, err := t.readVarint()
+		if err != nil {
+			return err
+		}
+		return storeInt(target, v)
+	case varintUnsigned[kind]:
+		v, err := t.readUvarint()
+		if err != nil {
+			return err
+		}
+		return storeUint(target, v)
+	}
+	word, err := t.readWord(fixedWidth[kind])
+	if err != nil {
+		return err
+	}
+	return storeFixed(target, kind, word)
+}
+
+// decode_packed reads a packed element into its native type
+func (t *decodeTransformer) decode_packed(code EncodedType) (*reflect.Value, error) {
+	wide, err := t.read_packed(code)
+	if err != nil {
+		return nil, err
+	}
+	var native reflect.Value
+	switch code {
+	case INT:
+		native = reflect.New(reflect.TypeOf(int(0))).Elem()
+		err = storeInt(native, wide.Int())
+	case UINT:
+		native = reflect.New(reflect.TypeOf(uint(0))).Elem()
+		err = storeUint(native, wide.Uint())
+	case UINTPTR:
+		native = reflect.New(reflect.TypeOf(uintptr(0))).Elem()
+		err = storeUint(native, wide.Uint())
+	default:
+		return wide, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &native, nil
+}
+
+// decode_element decodes an element of a container without a target type
+func (t *decodeTransformer) decode_element(desc *typeDesc) (*reflect.Value, error) {
+	if code, ok := packed(desc); ok {
+		return t.decode_packed(code)
+	}
+	return t.decode()
+}
+
+// embedded returns a transformer reading an EMBEDDED payload, which is in the
+// standard format
+func (t *decodeTransformer) embedded(payloadLen uint64) (*decodeTransformer, error) {
+	payload, err := t.readN(payloadLen)
+	if err != nil {
+		return nil, err
+	}
+	inner := newDecodeTransformer(bufio.NewReader(bytes.NewReader(payload)), t.stack, t.opts)
+	inner.sized, inner.size = true, payloadLen
+	inner.depth = t.depth
+	return inner, nil
+}
+
+func (t *decodeTransformer) decode_embedded(payloadLen uint64) (*reflect.Value, error) {
+	t.stack.Push("embedded")
+	inner, err := t.embedded(payloadLen)
+	if err != nil {
+		return nil, err
+	}
+	val, err := inner.decode()
+	if err != nil {
+		return nil, err
+	}
+	if inner.offset != payloadLen {
+		return nil, fmt.Errorf("embedded value is followed by %d bytes", payloadLen-inner.offset)
+	}
+	t.stack.Pop()
+	return val, nil
+}
+
+// visit_embedded decodes an EMBEDDED value into a target without its own
+// unmarshaling methods
+func (a *assigner) visit_embedded(target reflect.Value, payloadLen uint64) error {
+	a.stack.Push("embedded")
+	inner, err := a.tf.embedded(payloadLen)
+	if err != nil {
+		return err
+	}
+	innerAssigner := newAssigner(inner)
+	innerAssigner.reuse = a.reuse
+	err = innerAssigner.visit(target)
+	if err != nil {
+		return err
+	}
+	if inner.offset != payloadLen {
+		return fmt.Errorf("embedded value is followed by %d bytes", payloadLen-inner.offset)
+	}
+	a.stack.Pop()
+	return nil
+}
+
+// visit_element decodes an element of a container into target
+func (a *assigner) visit_element(target reflect.Value, desc *typeDesc) error {
+	code, ok := packed(desc)
+	if !ok {
+		return a.visit(target)
+	}
+	if target.Kind() != reflect.Interface {
+		if !a.matches(target.Type(), controlKind[code]) {
+			return mismatch(target.Kind(), controlKind[code], "type %s does not match reference type of %s", controlKind[code], target.Kind())
+		}
+		return a.tf.store_packed(target, code)
+	}
+	decoded, err := a.tf.decode_packed(code)
+	if err != nil {
+		return err
+	}
+	return a.set_scalar(target, decoded)
+}
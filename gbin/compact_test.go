@@ -0,0 +1,255 @@
+package gbin_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+func TestCompactRoundTrip(t *testing.T) {
+	compact := gbin.WithCompact()
+	cases := map[string]func() bool{
+		"portable": func() bool { return runTest(portableValue(), compact) },
+		"ints":     func() bool { return runTest([]int64{0, -1, 1, -1 << 40, 1<<63 - 1, -1 << 63}, compact) },
+		"uints":    func() bool { return runTest([]uint64{0, 1, 1<<64 - 1}, compact) },
+		"narrow":   func() bool { return runTest([]int8{-128, 0, 127}, compact) },
+		"native":   func() bool { return runTest([]int{0, -1, 1 << 30}, compact) },
+		"strings":  func() bool { return runTest([]string{"", "a", "long string of more than seven bytes"}, compact) },
+		"bools":    func() bool { return runTest([3]bool{true, false, true}, compact) },
+		"floats":   func() bool { return runTest([]float32{1.5, -0.25}, compact) },
+		"complex":  func() bool { return runTest([]complex64{complex(1, 2)}, compact) },
+		"bytes":    func() bool { return runTest([]byte("raw bytes"), compact) },
+		"nested":   func() bool { return runTest([][]int{{1, 2}, {}, {3}}, compact) },
+		"map":      func() bool { return runTest(map[string][]int{"a": {1}, "b": {2, 3}}, compact) },
+		"mapInt":   func() bool { return runTest(map[int32]string{-1: "neg", 1: "pos"}, compact) },
+		"ptrs":     func() bool { one := 1; return runTest([]*int{&one, nil}, compact) },
+		"any":      func() bool { return runTest([]any{1, "a", []string{"b"}}, compact) },
+		"tree": func() bool {
+			return runTest(refTree{Value: 1, Children: []*refTree{{Value: 2, Children: []*refTree{}}}}, compact)
+		},
+		"marshaler": func() bool {
+			return runTest(map[string]userID{"a": {"usr", 1}}, compact)
+		},
+	}
+	for name, run := range cases {
+		t.Run(name, func(t *testing.T) {
+			if pass := run(); !pass {
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestCompactSize(t *testing.T) {
+	data := make([]int, 1000)
+	for i := range data {
+		data[i] = i - 500
+	}
+	standard, err := gbin.NewEncoder[[]int]().Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compact, err := gbin.NewEncoder[[]int](gbin.WithCompact()).Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compact)*4 > len(standard) {
+		t.Fatalf("compact encoding of %d bytes is not a quarter of the standard %d bytes", len(compact), len(standard))
+	}
+}
+
+func TestCompactCustom(t *testing.T) {
+	type record struct {
+		At     time.Time
+		Point  vendorPoint
+		Points []vendorPoint
+		ID     userID
+		IDs    []userID
+	}
+	data := record{
+		At:     time.Date(2024, 2, 29, 12, 30, 0, 0, time.UTC),
+		Point:  vendorPoint{1, 2},
+		Points: []vendorPoint{{3, 4}},
+		ID:     userID{"usr", 42},
+		IDs:    []userID{{"a", 1}},
+	}
+	encoded, err := gbin.NewEncoder[record](gbin.WithCompact(), gbin.WithCodecs(vendorPointCodec)).Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[record](gbin.WithCodecs(vendorPointCodec)).Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, *decoded) {
+		t.Fatalf("expected %#v but got %#v", data, *decoded)
+	}
+}
+
+func TestCompactReferences(t *testing.T) {
+	shared := &refList{Value: 1}
+	shared.Next = shared
+	data := []*refList{shared, shared}
+	encoded, err := gbin.NewEncoder[[]*refList](gbin.WithCompact(), gbin.WithReferences()).Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[[]*refList]().Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := *decoded
+	if got[0] != got[1] || got[0].Next != got[0] || got[0].Value != 1 {
+		t.Fatalf("references were not restored: %#v", got)
+	}
+}
+
+// TestCompactDynamic checks that decoding without a target type gives the
+// same result for both formats
+func TestCompactDynamic(t *testing.T) {
+	type inner struct {
+		Name  string
+		Score float64
+	}
+	values := []any{
+		[]int{1, -2, 3},
+		map[string]uint16{"a": 1},
+		[2][]string{{"x"}, {}},
+		struct {
+			Inner  inner
+			Ptr    *int8
+			Values []any
+		}{Inner: inner{"n", 1.5}, Values: []any{uint(3), "s"}},
+	}
+	type holder struct {
+		Value any
+	}
+	for i, value := range values {
+		var standard, compact any
+		data := holder{value}
+		encoded, err := gbin.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = gbin.Unmarshal(encoded, &standard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err = gbin.NewEncoder[holder](gbin.WithCompact()).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = gbin.Unmarshal(encoded, &compact)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(standard, compact) {
+			t.Fatalf("value %d decoded as %#v from the standard format but %#v from the compact format", i, standard, compact)
+		}
+	}
+}
+
+func TestCompactStream(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	standard := gbin.NewEncoder[[]int]().NewStreamWriter(buf)
+	compact := gbin.NewEncoder[[]int](gbin.WithCompact()).NewStreamWriter(buf)
+	values := [][]int{{1}, {2, 3}, {}, {4}}
+	for i := range values {
+		w := standard
+		if i%2 == 0 {
+			w = compact
+		}
+		if err := w.Write(&values[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := gbin.NewDecoder[[]int]().NewStreamReader(buf)
+	for i := 0; ; i++ {
+		decoded, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(values[i], *decoded) {
+			t.Fatalf("expected %v but got %v", values[i], *decoded)
+		}
+	}
+}
+
+func TestCompactVersion(t *testing.T) {
+	data := []int{1}
+	encoded, err := gbin.NewEncoder[[]int](gbin.WithCompact()).Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded[0]++
+	if _, err := gbin.NewDecoder[[]int]().Decode(encoded); err == nil {
+		t.Fatal("expected an unknown compact version to be rejected")
+	}
+}
+
+func benchmarkFormats[T any](b *testing.B, data T) {
+	formats := map[string][]gbin.Option{
+		"standard": nil,
+		"compact":  {gbin.WithCompact()},
+	}
+	for name, opts := range formats {
+		encoder := gbin.NewEncoder[T](opts...)
+		decoder := gbin.NewDecoder[T]()
+		encoded, err := encoder.Encode(&data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("%s/encode", name), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(len(encoded)), "bytes")
+			for i := 0; i < b.N; i++ {
+				if err := encoder.EncodeTo(io.Discard, &data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("%s/decode", name), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := decoder.Decode(encoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFormatInts(b *testing.B) {
+	data := make([]int, 10000)
+	for i := range data {
+		data[i] = i * 7
+	}
+	benchmarkFormats(b, data)
+}
+
+func BenchmarkFormatMap(b *testing.B) {
+	data := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		data[fmt.Sprintf("key%d", i)] = i
+	}
+	benchmarkFormats(b, data)
+}
+
+func BenchmarkFormatStructs(b *testing.B) {
+	type point struct {
+		X, Y int32
+		Tag  string
+	}
+	data := make([]point, 1000)
+	for i := range data {
+		data[i] = point{int32(i), int32(-i), "p"}
+	}
+	benchmarkFormats(b, data)
+}
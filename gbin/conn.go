@@ -0,0 +1,165 @@
+package gbin
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// A Conn carries a stream of messages, each a value encoded with the options
+// of the Conn and framed by its length, so that a message can be discarded
+// without decoding it and one which fails to decode leaves the stream at the
+// start of the next.
+//
+// FRAME: UINT32 PAYLOAD LENGTH, PAYLOAD
+
+// frameHeaderLen is the length of a frame before its payload
+const frameHeaderLen = 4
+
+// Conn sends and receives values of any type over a stream such as a
+// net.Conn. Sending and receiving are each serialised, so a Conn is safe for
+// concurrent use by multiple goroutines.
+type Conn struct {
+	rwc  io.ReadWriteCloser
+	opts *options
+	rmu  sync.Mutex
+	r    *bufio.Reader
+	rbuf bytes.Buffer
+	wmu  sync.Mutex
+	w    *bufio.Writer
+	wbuf bytes.Buffer
+}
+
+// NewConn creates a Conn sending and receiving messages over rwc. Options
+// limiting decoding apply to each message received, and WithMaxSize limits
+// the length of their frames.
+func NewConn(rwc io.ReadWriteCloser, opts ...Option) *Conn {
+	return &Conn{
+		rwc:  rwc,
+		opts: newOptions(opts),
+		r:    bufio.NewReader(rwc),
+		w:    bufio.NewWriter(rwc),
+	}
+}
+
+// Send encodes values and writes them as consecutive messages. Pointers are
+// followed, so a value and a pointer to it are sent alike. If any of the
+// values fails to encode, none of them are written.
+func (c *Conn) Send(values ...any) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.wbuf.Reset()
+	bw := getWriter(&c.wbuf)
+	defer putWriter(bw)
+	for _, v := range values {
+		value := addressableValue(v)
+		for value.Kind() == reflect.Pointer && !value.IsNil() {
+			value = value.Elem()
+		}
+		// the length is filled in once the value has been encoded
+		start := c.wbuf.Len()
+		c.wbuf.Write(make([]byte, frameHeaderLen))
+		err := encodeValue(bw, value, c.opts)
+		if err == nil {
+			err = writeError(bw.Flush())
+		}
+		if err != nil {
+			return err
+		}
+		length := c.wbuf.Len() - start - frameHeaderLen
+		if uint64(length) > 1<<32-1 {
+			return wrapEncode(fmt.Errorf("encoding of %d bytes is too long for a message", length))
+		}
+		BYTE_ORDER.PutUint32(c.wbuf.Bytes()[start:], uint32(length))
+	}
+	_, err := c.w.Write(c.wbuf.Bytes())
+	if err == nil {
+		err = c.w.Flush()
+	}
+	if c.wbuf.Cap() > maxPooledBuffer {
+		c.wbuf = bytes.Buffer{}
+	}
+	return writeError(err)
+}
+
+// Receive reads the next message and decodes it into the value pointed to by
+// v, allocating any pointers it holds, or discards it if v is nil. It returns
+// io.EOF if the stream ends between messages. A message which cannot be
+// decoded is still consumed, so the next call receives the message after it.
+func (c *Conn) Receive(v any) error {
+	var target reflect.Value
+	var targetErr error
+	if v != nil {
+		target = reflect.ValueOf(v)
+		if target.Kind() != reflect.Pointer || target.IsNil() {
+			target, targetErr = reflect.Value{}, wrapDecode(fmt.Errorf("receive target must be nil or a non nil pointer, not %T", v))
+		}
+	}
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	var header [frameHeaderLen]byte
+	_, err := io.ReadFull(c.r, header[:])
+	if err == io.EOF {
+		return io.EOF
+	} else if errors.Is(err, io.ErrUnexpectedEOF) {
+		return newError(newTrace(), truncatedError("stream ends within a message header"), 0, false)
+	} else if err != nil {
+		return readError(err, 0)
+	}
+	length := int64(BYTE_ORDER.Uint32(header[:]))
+	if !target.IsValid() || c.opts.maxSize > 0 && uint64(length) > c.opts.maxSize {
+		n, err := io.CopyN(io.Discard, c.r, length)
+		if err != nil {
+			return payloadError(err, n, length)
+		} else if target.IsValid() {
+			return newError(newTrace(), limitError("message of %d bytes is longer than %d bytes", length, c.opts.maxSize), 0, false)
+		}
+		return targetErr
+	}
+	// the payload is read as it arrives rather than allocated up front, as
+	// the length may not be genuine
+	c.rbuf.Reset()
+	defer func() {
+		if c.rbuf.Cap() > maxPooledBuffer {
+			c.rbuf = bytes.Buffer{}
+		}
+	}()
+	n, err := io.CopyN(&c.rbuf, c.r, length)
+	if err != nil {
+		return payloadError(err, n, length)
+	}
+	br := getReader(bytes.NewReader(c.rbuf.Bytes()))
+	defer putReader(br)
+	// values are sent without the pointers to them, so pointers in the
+	// target are allocated
+	decoded := reflect.New(target.Type().Elem())
+	inner := decoded.Elem()
+	for inner.Kind() == reflect.Pointer {
+		inner.Set(reflect.New(inner.Type().Elem()))
+		inner = inner.Elem()
+	}
+	err = decodeValue(br, c.rbuf.Len(), inner, c.opts, false)
+	if err != nil {
+		return err
+	}
+	target.Elem().Set(decoded.Elem())
+	return nil
+}
+
+// payloadError reports a failure to read the payload of a message of length
+// bytes, after n of them were read
+func payloadError(err error, n, length int64) error {
+	if err == io.EOF {
+		return newError(newTrace(), truncatedError("stream ends %d bytes into a message of %d bytes", n, length), uint64(n), false)
+	}
+	return readError(err, uint64(n))
+}
+
+// Close closes the underlying stream
+func (c *Conn) Close() error {
+	return ioError("closing connection", c.rwc.Close())
+}
@@ -0,0 +1,111 @@
+package gbin_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+// connPair returns two Conns connected by net.Pipe
+func connPair(t *testing.T, opts ...gbin.Option) (*gbin.Conn, *gbin.Conn) {
+	a, b := net.Pipe()
+	ca, cb := gbin.NewConn(a, opts...), gbin.NewConn(b, opts...)
+	t.Cleanup(func() {
+		ca.Close()
+		cb.Close()
+	})
+	return ca, cb
+}
+
+// sendAsync sends values from c, reporting the result on the returned channel
+func sendAsync(c *gbin.Conn, values ...any) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Send(values...)
+	}()
+	return done
+}
+
+func TestConn(t *testing.T) {
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}, {gbin.WithCompression(gbin.Gzip)}} {
+		a, b := connPair(t, opts...)
+		records := benchRecords()[:3]
+		done := sendAsync(a, &records[0], records[1], "skipped", []int{1, 2}, &records[2])
+
+		var first, second benchRecord
+		if err := b.Receive(&first); err != nil || !reflect.DeepEqual(first, records[0]) {
+			t.Fatalf("expected %v, got %v (%v)", records[0], first, err)
+		}
+		if err := b.Receive(&second); err != nil || !reflect.DeepEqual(second, records[1]) {
+			t.Fatalf("expected %v, got %v (%v)", records[1], second, err)
+		}
+		if err := b.Receive(nil); err != nil {
+			t.Fatal(err)
+		}
+		// a message which does not decode into its target is consumed
+		var wrong string
+		var mismatch *gbin.Error
+		if err := b.Receive(&wrong); !errors.As(err, &mismatch) || !errors.Is(err, gbin.ErrTypeMismatch) {
+			t.Fatalf("expected a type mismatch, got %v", err)
+		}
+		var third *benchRecord
+		if err := b.Receive(&third); err != nil || !reflect.DeepEqual(*third, records[2]) {
+			t.Fatalf("expected %v, got %v (%v)", records[2], third, err)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		a.Close()
+		if err := b.Receive(&wrong); err != io.EOF {
+			t.Fatalf("expected io.EOF once the stream is closed, got %v", err)
+		}
+	}
+}
+
+func TestConnLimits(t *testing.T) {
+	a, b := connPair(t, gbin.WithMaxSize(64))
+	done := sendAsync(a, make([]int64, 100), 5)
+	var large []int64
+	if err := b.Receive(&large); !errors.Is(err, gbin.ErrLimitExceeded) {
+		t.Fatalf("expected the size limit to be exceeded, got %v", err)
+	}
+	var small int
+	if err := b.Receive(&small); err != nil || small != 5 {
+		t.Fatalf("expected the message after the large one to be received, got %d (%v)", small, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// values which cannot be encoded are not sent, nor are those sent with them
+	if err := a.Send(1, make(chan int)); !errors.Is(err, gbin.ErrUnsupportedType) {
+		t.Fatalf("expected a channel to be rejected, got %v", err)
+	}
+	done = sendAsync(a, 2)
+	if err := b.Receive(&small); err != nil || small != 2 {
+		t.Fatalf("expected 2, got %d (%v)", small, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnTruncated(t *testing.T) {
+	a, b := net.Pipe()
+	conn := gbin.NewConn(b)
+	defer conn.Close()
+	go func() {
+		// a frame promising 10 bytes of payload, of which 2 arrive
+		a.Write([]byte{0, 0, 0, 10, 1, 2})
+		a.Close()
+	}()
+	var v any
+	if err := conn.Receive(&v); !errors.Is(err, gbin.ErrTruncated) {
+		t.Fatalf("expected the message to be truncated, got %v", err)
+	}
+}
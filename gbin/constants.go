@@ -2,10 +2,24 @@ package gbin
 
 import (
 	"encoding/binary"
+	"fmt"
+	"math"
 	"reflect"
 )
 
-const MAX_PAYLOAD_LEN = 0xfffffff
+const MAX_PAYLOAD_LEN = math.MaxInt64
+
+// EXTENDED_LEN in the length bits of a control byte marks an 8 byte payload
+// length, as only lengths of up to 7 bytes can be specified directly
+const EXTENDED_LEN = 0
+
+// DEFAULT_MAX_DEPTH is the deepest nesting of values that is decoded unless
+// set otherwise WithMaxDepth
+const DEFAULT_MAX_DEPTH = 1 << 16
+
+// DEFAULT_MAX_DECOMPRESSED_SIZE is the largest payload of a compressed
+// envelope that is decompressed unless set otherwise WithMaxSize
+const DEFAULT_MAX_DECOMPRESSED_SIZE = 1 << 26
 
 var BYTE_ORDER = binary.BigEndian
 
@@ -26,49 +40,159 @@ const (
 	UINT8
 	FLOAT64
 	INVALID
+	INT8
+	INT16
+	INT32
+	UINT16
+	UINT32
+	UINTPTR
+	FLOAT32
+	COMPLEX64
+	COMPLEX128
+	ARRAY
+	BYTES
+	REFPTR
+	REF
+	EMBEDDED
+	SCHEMA
+	TYPED
+	ENVELOPE
 )
 
+// FORMAT is the type of the byte which begins a compact encoding. Its length
+// bits hold the version of the compact format used.
+const FORMAT EncodedType = 31
+
+const COMPACT_VERSION = 1
+
 var kindControl map[reflect.Kind]EncodedType = map[reflect.Kind]EncodedType{
-	reflect.Invalid:   INVALID,
-	reflect.Interface: INTERFACE,
-	reflect.Map:       MAP,
-	reflect.Struct:    STRUCT,
-	reflect.Pointer:   PTR,
-	reflect.Slice:     SLICE,
-	reflect.String:    STRING,
-	reflect.Bool:      BOOL,
-	reflect.Int:       INT,
-	reflect.Int64:     INT64,
-	reflect.Uint:      UINT,
-	reflect.Uint64:    UINT64,
-	reflect.Uint8:     UINT8,
-	reflect.Float64:   FLOAT64,
+	reflect.Invalid:    INVALID,
+	reflect.Interface:  INTERFACE,
+	reflect.Map:        MAP,
+	reflect.Struct:     STRUCT,
+	reflect.Pointer:    PTR,
+	reflect.Slice:      SLICE,
+	reflect.String:     STRING,
+	reflect.Bool:       BOOL,
+	reflect.Int:        INT,
+	reflect.Int64:      INT64,
+	reflect.Uint:       UINT,
+	reflect.Uint64:     UINT64,
+	reflect.Uint8:      UINT8,
+	reflect.Float64:    FLOAT64,
+	reflect.Int8:       INT8,
+	reflect.Int16:      INT16,
+	reflect.Int32:      INT32,
+	reflect.Uint16:     UINT16,
+	reflect.Uint32:     UINT32,
+	reflect.Uintptr:    UINTPTR,
+	reflect.Float32:    FLOAT32,
+	reflect.Complex64:  COMPLEX64,
+	reflect.Complex128: COMPLEX128,
+	reflect.Array:      ARRAY,
 }
 
 var controlKind map[EncodedType]reflect.Kind = map[EncodedType]reflect.Kind{
-	INTERFACE: reflect.Interface,
-	MAP:       reflect.Map,
-	STRUCT:    reflect.Struct,
-	PTR:       reflect.Pointer,
-	SLICE:     reflect.Slice,
-	STRING:    reflect.String,
-	BOOL:      reflect.Bool,
-	INT:       reflect.Int,
-	INT64:     reflect.Int64,
-	UINT:      reflect.Uint,
-	UINT64:    reflect.Uint64,
-	UINT8:     reflect.Uint8,
-	FLOAT64:   reflect.Float64,
-	INVALID:   reflect.Invalid,
+	INTERFACE:  reflect.Interface,
+	MAP:        reflect.Map,
+	STRUCT:     reflect.Struct,
+	PTR:        reflect.Pointer,
+	SLICE:      reflect.Slice,
+	STRING:     reflect.String,
+	BOOL:       reflect.Bool,
+	INT:        reflect.Int,
+	INT64:      reflect.Int64,
+	UINT:       reflect.Uint,
+	UINT64:     reflect.Uint64,
+	UINT8:      reflect.Uint8,
+	FLOAT64:    reflect.Float64,
+	INVALID:    reflect.Invalid,
+	INT8:       reflect.Int8,
+	INT16:      reflect.Int16,
+	INT32:      reflect.Int32,
+	UINT16:     reflect.Uint16,
+	UINT32:     reflect.Uint32,
+	UINTPTR:    reflect.Uintptr,
+	FLOAT32:    reflect.Float32,
+	COMPLEX64:  reflect.Complex64,
+	COMPLEX128: reflect.Complex128,
+	ARRAY:      reflect.Array,
 }
 
 var kindComparableType map[reflect.Kind]reflect.Type = map[reflect.Kind]reflect.Type{
-	reflect.String:  reflect.TypeOf(""),
-	reflect.Bool:    reflect.TypeOf(true),
-	reflect.Int:     reflect.TypeOf(int(0)),
-	reflect.Int64:   reflect.TypeOf(int64(0)),
-	reflect.Uint:    reflect.TypeOf(uint(0)),
-	reflect.Uint64:  reflect.TypeOf(uint64(0)),
-	reflect.Uint8:   reflect.TypeOf(uint8(0)),
-	reflect.Float64: reflect.TypeOf(float64(0)),
+	reflect.String:     reflect.TypeOf(""),
+	reflect.Bool:       reflect.TypeOf(true),
+	reflect.Int:        reflect.TypeOf(int(0)),
+	reflect.Int64:      reflect.TypeOf(int64(0)),
+	reflect.Uint:       reflect.TypeOf(uint(0)),
+	reflect.Uint64:     reflect.TypeOf(uint64(0)),
+	reflect.Uint8:      reflect.TypeOf(uint8(0)),
+	reflect.Float64:    reflect.TypeOf(float64(0)),
+	reflect.Int8:       reflect.TypeOf(int8(0)),
+	reflect.Int16:      reflect.TypeOf(int16(0)),
+	reflect.Int32:      reflect.TypeOf(int32(0)),
+	reflect.Uint16:     reflect.TypeOf(uint16(0)),
+	reflect.Uint32:     reflect.TypeOf(uint32(0)),
+	reflect.Uintptr:    reflect.TypeOf(uintptr(0)),
+	reflect.Float32:    reflect.TypeOf(float32(0)),
+	reflect.Complex64:  reflect.TypeOf(complex64(0)),
+	reflect.Complex128: reflect.TypeOf(complex128(0)),
+}
+
+// fixedWidth gives the size of scalar payloads in the standard format
+var fixedWidth map[reflect.Kind]uint64 = map[reflect.Kind]uint64{
+	reflect.Bool:       1,
+	reflect.Int:        8,
+	reflect.Int64:      8,
+	reflect.Uint:       8,
+	reflect.Uint64:     8,
+	reflect.Uint8:      1,
+	reflect.Float64:    8,
+	reflect.Int8:       1,
+	reflect.Int16:      2,
+	reflect.Int32:      4,
+	reflect.Uint16:     2,
+	reflect.Uint32:     4,
+	reflect.Uintptr:    8,
+	reflect.Float32:    4,
+	reflect.Complex64:  8,
+	reflect.Complex128: 16,
+}
+
+// varintSigned and varintUnsigned hold the kinds written as varints in the
+// compact format
+var varintSigned map[reflect.Kind]bool = map[reflect.Kind]bool{
+	reflect.Int:   true,
+	reflect.Int64: true,
+	reflect.Int8:  true,
+	reflect.Int16: true,
+	reflect.Int32: true,
+}
+
+var varintUnsigned map[reflect.Kind]bool = map[reflect.Kind]bool{
+	reflect.Uint:    true,
+	reflect.Uint64:  true,
+	reflect.Uint16:  true,
+	reflect.Uint32:  true,
+	reflect.Uintptr: true,
+}
+
+// controlName describes an encoded type for use in error messages
+func controlName(objectType EncodedType) string {
+	if objectType == BYTES {
+		return "bytes"
+	} else if objectType == REFPTR || objectType == REF {
+		return "reference"
+	} else if objectType == EMBEDDED {
+		return "embedded value"
+	} else if objectType == SCHEMA {
+		return "schema"
+	} else if objectType == TYPED {
+		return "typed interface"
+	} else if objectType == ENVELOPE {
+		return "envelope"
+	} else if kind, ok := controlKind[objectType]; ok {
+		return kind.String()
+	}
+	return fmt.Sprintf("unknown type code 0x%x", byte(objectType))
 }
@@ -1,53 +1,189 @@
 package gbin
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"go/token"
+	"io"
+	"math"
 	"reflect"
-
-	"github.com/lspaccatrosi16/go-libs/structures/stack"
 )
 
+// decodeTransformer reads encoded values incrementally from a buffered
+// reader. It only ever holds the payload of a single scalar in memory;
+// containers are consumed element by element up to the end offset given by
+// their length prefix.
 type decodeTransformer struct {
-	data  *bytes.Buffer
-	stack *stack.Stack[string]
+	data    *bufio.Reader
+	offset  uint64
+	stack   *trace
+	opts    *options
+	refs    []reflect.Value
+	compact bool
+	schema  *typeDesc
+	sized   bool
+	size    uint64
+	depth   int
+	// constructed counts the bytes of values created without being read from
+	// the input
+	constructed uint64
+	// detached is set when decoding a value from part way through the input,
+	// where references cannot be resolved
+	detached bool
 }
 
-func newDecodeTransformer(buf bytes.Buffer, stack *stack.Stack[string]) *decodeTransformer {
+func newDecodeTransformer(data *bufio.Reader, stack *trace, opts *options) *decodeTransformer {
 	return &decodeTransformer{
-		data:  &buf,
+		data:  data,
 		stack: stack,
+		opts:  opts,
 	}
 }
 
-func (t *decodeTransformer) trace() string {
-	buf := bytes.NewBufferString("/")
-	t.stack.Reverse()
-	for {
-		val, ok := t.stack.Pop()
-		if !ok {
-			break
+// header reads the control byte and payload length of the next value
+func (t *decodeTransformer) header() (EncodedType, uint64, error) {
+	return t.raw_header(nil)
+}
+
+// raw_header reads the header of the next value, appending its bytes to raw
+// unless it is nil
+func (t *decodeTransformer) raw_header(raw *[]byte) (EncodedType, uint64, error) {
+	err := t.check_size(1)
+	if err != nil {
+		return INVALID, 0, err
+	}
+	control, err := t.data.ReadByte()
+	if err == io.EOF {
+		return INVALID, 0, truncatedError("no header found")
+	} else if err != nil {
+		return INVALID, 0, err
+	}
+	t.offset++
+	if raw != nil {
+		*raw = append(*raw, control)
+	}
+	if t.compact {
+		return t.compact_header(control, raw)
+	}
+	objectType := control >> 3
+	lenLen := lengthBytes(control)
+	payloadLen := uint64(0)
+	for i := uint64(0); i < lenLen; i++ {
+		b, err := t.readByte()
+		if err != nil {
+			return INVALID, 0, err
 		}
-		formatted := fmt.Sprintf("%s/", val)
-		buf.WriteString(formatted)
+		payloadLen = payloadLen<<8 | uint64(b)
+		if raw != nil {
+			*raw = append(*raw, b)
+		}
+	}
+	err = t.check_len(payloadLen)
+	if err != nil {
+		return INVALID, 0, err
+	}
+	return EncodedType(objectType), payloadLen, nil
+}
+
+// lengthBytes returns the number of bytes of payload length following a
+// control byte in the standard format
+func lengthBytes(control byte) uint64 {
+	lenLen := uint64(control & 0b00000111)
+	if lenLen == EXTENDED_LEN {
+		return 8
+	}
+	return lenLen
+}
+
+// check_len rejects payload lengths which are longer than the input that
+// remains, where its length is known, so that corrupt lengths fail before
+// anything is allocated for them
+func (t *decodeTransformer) check_len(payloadLen uint64) error {
+	if payloadLen > MAX_PAYLOAD_LEN {
+		return fmt.Errorf("payload length %d is too long", payloadLen)
+	}
+	if t.sized && payloadLen > t.size-t.offset {
+		return truncatedError("payload length %d exceeds the %d bytes of remaining input", payloadLen, t.size-t.offset)
+	}
+	return t.check_size(payloadLen)
+}
+
+// check_size fails if reading n more bytes would exceed the maximum size of
+// input set WithMaxSize
+func (t *decodeTransformer) check_size(n uint64) error {
+	if t.opts.maxSize > 0 && n > t.opts.maxSize-min(t.offset, t.opts.maxSize) {
+		return limitError("input is longer than %d bytes", t.opts.maxSize)
+	}
+	return nil
+}
+
+// check_count fails if a collection of n elements exceeds the maximum length
+// set WithMaxLength
+func (t *decodeTransformer) check_count(n int) error {
+	if t.opts.maxLength > 0 && n > t.opts.maxLength {
+		return limitError("collection has more than %d elements", t.opts.maxLength)
+	}
+	return nil
+}
+
+// descend enters a nested value, failing if values are nested deeper than
+// the maximum depth. Each call must be paired with a call to ascend.
+func (t *decodeTransformer) descend() error {
+	t.depth++
+	if t.opts.maxDepth > 0 && t.depth > t.opts.maxDepth {
+		return limitError("values are nested more than %d deep", t.opts.maxDepth)
+	}
+	return nil
+}
+
+func (t *decodeTransformer) ascend() {
+	t.depth--
+}
+
+// peek_type returns the type of the next value without consuming it
+func (t *decodeTransformer) peek_type() (EncodedType, error) {
+	control, err := t.data.Peek(1)
+	if err == io.EOF {
+		return INVALID, truncatedError("no header found")
+	} else if err != nil {
+		return INVALID, err
+	}
+	return EncodedType(control[0] >> 3), nil
+}
+
+// read_raw reads the next complete value, returning its encoded bytes
+func (t *decodeTransformer) read_raw() ([]byte, error) {
+	raw := []byte{}
+	_, payloadLen, err := t.raw_header(&raw)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := t.readN(payloadLen)
+	if err != nil {
+		return nil, err
 	}
-	return buf.String()
+	return append(raw, payload...), nil
 }
 
+// decode reads the next value without reference to a target type, creating
+// Go types for it from the encoded data
 func (t *decodeTransformer) decode() (*reflect.Value, error) {
-	if t.data.Len() < 1 {
-		return nil, fmt.Errorf("no header found")
+	err := t.descend()
+	if err != nil {
+		return nil, err
 	}
-	control, _ := t.data.ReadByte()
-	objectType := control >> 3
-	lenLen := uint64(control & 0b00000111)
-	payloadLenBuff, _ := t.readN(lenLen)
-	payloadLenBuffBytes := payloadLenBuff.Bytes()
-	payloadLenArr := make([]byte, 8-lenLen)
-	payloadLenArr = append(payloadLenArr, payloadLenBuffBytes...)
-	payloadLen := binary.BigEndian.Uint64(payloadLenArr)
-	switch EncodedType(objectType) {
+	defer t.ascend()
+	objectType, payloadLen, err := t.header()
+	if err != nil {
+		return nil, err
+	}
+	return t.decode_payload(objectType, payloadLen)
+}
+
+func (t *decodeTransformer) decode_payload(objectType EncodedType, payloadLen uint64) (*reflect.Value, error) {
+	switch objectType {
 	case INTERFACE:
 		return t.decode_interface(payloadLen)
 	case MAP:
@@ -74,10 +210,40 @@ func (t *decodeTransformer) decode() (*reflect.Value, error) {
 		return t.decode_uint8(payloadLen)
 	case FLOAT64:
 		return t.decode_float(payloadLen)
+	case INT8:
+		return t.decode_fixed("int8", payloadLen, new(int8))
+	case INT16:
+		return t.decode_fixed("int16", payloadLen, new(int16))
+	case INT32:
+		return t.decode_fixed("int32", payloadLen, new(int32))
+	case UINT16:
+		return t.decode_fixed("uint16", payloadLen, new(uint16))
+	case UINT32:
+		return t.decode_fixed("uint32", payloadLen, new(uint32))
+	case UINTPTR:
+		return t.decode_uintptr(payloadLen)
+	case FLOAT32:
+		return t.decode_fixed("float32", payloadLen, new(float32))
+	case COMPLEX64:
+		return t.decode_fixed("complex64", payloadLen, new(complex64))
+	case COMPLEX128:
+		return t.decode_fixed("complex128", payloadLen, new(complex128))
+	case ARRAY:
+		return t.decode_array(payloadLen)
+	case BYTES:
+		return t.decode_bytes(payloadLen)
+	case REFPTR:
+		return t.decode_ref_ptr(payloadLen)
+	case REF:
+		return t.decode_ref(payloadLen)
+	case EMBEDDED:
+		return t.decode_embedded(payloadLen)
+	case TYPED:
+		return t.decode_typed(payloadLen)
 	case INVALID:
 		return t.decode_nil(payloadLen)
 	default:
-		return nil, fmt.Errorf("encoding error: unknown type code 0x%x", control)
+		return nil, fmt.Errorf("encoding error: unknown type code 0x%x", byte(objectType))
 	}
 }
 
@@ -88,12 +254,12 @@ func iface() []interface{} {
 
 func (t *decodeTransformer) decode_interface(stop uint64) (*reflect.Value, error) {
 	t.stack.Push("interface")
-	buf, err := t.readN(stop)
+	end := t.offset + stop
+	inner, err := t.decode()
 	if err != nil {
 		return nil, err
 	}
-	dec := newDecodeTransformer(*buf, t.stack)
-	inner, err := dec.decode()
+	err = t.expectEnd(end)
 	if err != nil {
 		return nil, err
 	}
@@ -110,62 +276,57 @@ func (t *decodeTransformer) decode_interface(stop uint64) (*reflect.Value, error
 
 func (t *decodeTransformer) decode_map(stop uint64) (*reflect.Value, error) {
 	t.stack.Push("map")
-	buf, err := t.readN(stop)
+	end := t.offset + stop
+	kType, keyDesc, err := t.zero()
 	if err != nil {
 		return nil, err
 	}
-	dec := newDecodeTransformer(*buf, t.stack)
-	zeroKey, err := dec.decode()
+	vType, valDesc, err := t.zero()
 	if err != nil {
 		return nil, err
 	}
-	zeroVal, err := dec.decode()
-	if err != nil {
-		return nil, err
+	// scalar keys are decoded as their unnamed types, and struct and array
+	// keys as the types created for them, which must be comparable
+	if scalar, ok := kindComparableType[kType.Kind()]; ok {
+		kType = scalar
+	} else if !kType.Comparable() {
+		return nil, unsupportedError("found illegal key type for map: %s", kType)
 	}
-	kType := zeroKey.Type()
-	vType := zeroVal.Type()
-	kKind := kType.Kind()
-	vKind := vType.Kind()
-	keyArr := []*reflect.Value{}
-	valArr := []*reflect.Value{}
+	mapType := reflect.MapOf(kType, vType)
+	m := reflect.MakeMap(mapType)
 	count := 0
-	for {
-		if dec.data.Len() == 0 {
-			break
+	for t.offset < end {
+		err = t.check_count(count + 1)
+		if err != nil {
+			return nil, err
 		}
-		t.stack.Push(fmt.Sprintf("key%d", count))
-		k, err := dec.decode()
+		t.stack.Push("key")
+		k, err := t.decode_element(keyDesc)
 		if err != nil {
 			return nil, err
 		}
 		t.stack.Pop()
-		t.stack.Push(fmt.Sprintf("%v", k.Interface()))
-		v, err := dec.decode()
+		t.stack.Key(*k)
+		v, err := t.decode_element(valDesc)
 		if err != nil {
 			return nil, err
 		}
 		t.stack.Pop()
 
-		if k.Kind() != kKind {
-			return nil, fmt.Errorf("map key types must be consistent (found %s but expected %s)", k.Kind(), kKind)
-		} else if v.Kind() != vKind {
-			return nil, fmt.Errorf("maps to interfaces are not supported")
+		key, err := t.element(k, kType, "map key")
+		if err != nil {
+			return nil, err
 		}
-
-		keyArr = append(keyArr, k)
-		valArr = append(valArr, v)
+		val, err := t.element(v, vType, "map value")
+		if err != nil {
+			return nil, err
+		}
+		m.SetMapIndex(key, val)
 		count++
 	}
-	kType, fk := kindComparableType[kKind]
-	if !fk {
-		return nil, fmt.Errorf("found illegal key type for map: %s", kKind)
-	}
-	mapType := reflect.MapOf(kType, vType)
-	m := reflect.MakeMap(mapType)
-	for i, k := range keyArr {
-		v := valArr[i]
-		m.SetMapIndex(*k, *v)
+	err = t.expectEnd(end)
+	if err != nil {
+		return nil, err
 	}
 	t.stack.Pop()
 	return &m, nil
@@ -173,45 +334,52 @@ func (t *decodeTransformer) decode_map(stop uint64) (*reflect.Value, error) {
 
 func (t *decodeTransformer) decode_struct(stop uint64) (*reflect.Value, error) {
 	t.stack.Push("struct")
-	buf, err := t.readN(stop)
-	if err != nil {
-		return nil, err
-	}
+	end := t.offset + stop
 	fields := []reflect.StructField{}
-	kvMap := map[string]*reflect.Value{}
-	dec := newDecodeTransformer(*buf, t.stack)
+	vals := []*reflect.Value{}
 	count := 0
-	for {
-		if dec.data.Len() == 0 {
-			break
+	for t.offset < end {
+		err := t.check_count(count + 1)
+		if err != nil {
+			return nil, err
 		}
-		t.stack.Push(fmt.Sprintf("key%d", count))
-		key, err := dec.decode()
+		t.stack.Push("key")
+		key, err := t.decode()
 		if err != nil {
 			return nil, err
 		}
 		if key.Kind() != reflect.String {
-			return nil, fmt.Errorf("encoded struct key must be of type string, not %s", key.Kind())
+			return nil, mismatch(reflect.String, key.Kind(), "encoded struct key must be of type string, not %s", key.Kind())
 		}
 		t.stack.Pop()
-		t.stack.Push(fmt.Sprintf("%v", key.String()))
-		val, err := dec.decode()
+		t.stack.Field(key.String())
+		val, err := t.decode()
 		if err != nil {
 			return nil, err
 		}
 		t.stack.Pop()
+		if !val.IsValid() {
+			*val = reflect.New(reflect.TypeOf(iface()).Elem()).Elem()
+		}
 		field := reflect.StructField{
 			Name: key.String(),
 			Type: val.Type(),
 		}
 		fields = append(fields, field)
-		kvMap[key.String()] = val
+		vals = append(vals, val)
+		count++
+	}
+	err := t.expectEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	strType, err := structOf(fields)
+	if err != nil {
+		return nil, err
 	}
-	count++
-	strType := reflect.StructOf(fields)
 	str := reflect.New(strType).Elem()
-	for k, v := range kvMap {
-		str.FieldByName(k).Set(*v)
+	for i, v := range vals {
+		str.Field(i).Set(*v)
 	}
 	t.stack.Pop()
 	return &str, nil
@@ -219,31 +387,28 @@ func (t *decodeTransformer) decode_struct(stop uint64) (*reflect.Value, error) {
 
 func (t *decodeTransformer) decode_ptr(stop uint64) (*reflect.Value, error) {
 	t.stack.Push("ptr")
-	buf, err := t.readN(stop)
+	end := t.offset + stop
+	elType, _, err := t.zero()
 	if err != nil {
 		return nil, err
 	}
-	dec := newDecodeTransformer(*buf, t.stack)
-	zeroVal, err := dec.decode()
+	inner, err := t.decode()
 	if err != nil {
 		return nil, err
 	}
-	inner, err := dec.decode()
+	err = t.expectEnd(end)
 	if err != nil {
 		return nil, err
 	}
-	outer := reflect.New(zeroVal.Type())
+	// a nil pointer is not allocated, so that a small input cannot demand a
+	// large value
+	outer := reflect.Zero(reflect.PointerTo(elType))
 	if inner.Kind() != reflect.Invalid {
-		outer.Elem().Set(*inner)
-	} else {
-		if zeroVal.CanAddr() {
-			nilVal := reflect.New(zeroVal.Addr().Type()).Elem()
-			outer = nilVal
-		} else {
-			iface := zeroVal.Interface()
-			nilVal := reflect.New(reflect.TypeOf(&iface)).Elem()
-			outer = nilVal
+		if !inner.Type().AssignableTo(elType) {
+			return nil, mismatch(elType, inner.Type(), "pointer to %s cannot point at %s", elType, inner.Type())
 		}
+		outer = reflect.New(elType)
+		outer.Elem().Set(*inner)
 	}
 	t.stack.Pop()
 	return &outer, nil
@@ -251,40 +416,139 @@ func (t *decodeTransformer) decode_ptr(stop uint64) (*reflect.Value, error) {
 
 func (t *decodeTransformer) decode_slice(stop uint64) (*reflect.Value, error) {
 	t.stack.Push("slice")
-	buf, err := t.readN(stop)
+	end := t.offset + stop
+	sliceType, elDesc, err := t.zero()
 	if err != nil {
 		return nil, err
 	}
-	dec := newDecodeTransformer(*buf, t.stack)
-	zeroVal, err := dec.decode()
+	slice := reflect.MakeSlice(reflect.SliceOf(sliceType), 0, 0)
+	count := 0
+	for t.offset < end {
+		err = t.check_count(count + 1)
+		if err != nil {
+			return nil, err
+		}
+		t.stack.Index(count)
+		val, err := t.decode_element(elDesc)
+		if err != nil {
+			return nil, err
+		}
+		el, err := t.element(val, sliceType, "slice element")
+		if err != nil {
+			return nil, err
+		}
+		t.stack.Pop()
+		slice = reflect.Append(slice, el)
+		count++
+	}
+	err = t.expectEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	t.stack.Pop()
+	return &slice, nil
+}
+
+func (t *decodeTransformer) decode_array(stop uint64) (*reflect.Value, error) {
+	t.stack.Push("array")
+	end := t.offset + stop
+	elType, elDesc, err := t.zero()
 	if err != nil {
 		return nil, err
 	}
-	count := 0
 	vals := []*reflect.Value{}
-	sliceType := zeroVal.Type()
-	for {
-		if dec.data.Len() == 0 {
-			break
+	for t.offset < end {
+		err = t.check_count(len(vals) + 1)
+		if err != nil {
+			return nil, err
 		}
-		t.stack.Push(fmt.Sprintf("el%d", count))
-		val, err := dec.decode()
+		t.stack.Index(len(vals))
+		val, err := t.decode_element(elDesc)
 		if err != nil {
 			return nil, err
 		}
-		if val.Kind() != sliceType.Kind() {
-			return nil, fmt.Errorf("slice key types must be consistent (found %s but expected %s)", val.Kind(), sliceType.Kind())
+		el, err := t.element(val, elType, "array element")
+		if err != nil {
+			return nil, err
 		}
 		t.stack.Pop()
-		vals = append(vals, val)
-		count++
+		vals = append(vals, &el)
 	}
-	slice := reflect.New(reflect.SliceOf(sliceType)).Elem()
-	for _, val := range vals {
-		slice = reflect.Append(slice, *val)
+	err = t.expectEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	arr := reflect.New(reflect.ArrayOf(len(vals), elType)).Elem()
+	for i, val := range vals {
+		arr.Index(i).Set(*val)
 	}
 	t.stack.Pop()
-	return &slice, nil
+	return &arr, nil
+}
+
+// element checks that a value decoded without a target type can be stored in
+// a container of elements of type typ. Nil values give the zero value of typ,
+// as they do when decoding into a target, which is charged to the bytes
+// created without input.
+func (t *decodeTransformer) element(val *reflect.Value, typ reflect.Type, what string) (reflect.Value, error) {
+	if !val.IsValid() {
+		err := t.construct(typ)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.Zero(typ), nil
+	} else if !val.Type().AssignableTo(typ) {
+		return reflect.Value{}, mismatch(typ, val.Type(), "%s types must be consistent (found %s but expected %s)", what, val.Type(), typ)
+	}
+	return *val, nil
+}
+
+// maxTypeSize is the largest type that is created from a description in the
+// input, so that a few bytes of input cannot demand a huge allocation
+const maxTypeSize = 1 << 24
+
+// constructedPerByte is the number of bytes of values which are created without
+// being read from the input, such as nil elements of a large type, allowed for
+// each byte of input read
+const constructedPerByte = 64
+
+// construct charges a value of type typ which is created without being read
+// from the input. The total may not exceed maxTypeSize and constructedPerByte
+// for each byte read, so that it is bounded by the size of the input.
+func (t *decodeTransformer) construct(typ reflect.Type) error {
+	t.constructed += uint64(typ.Size())
+	if t.constructed > maxTypeSize+constructedPerByte*t.offset {
+		return limitError("%d bytes of values created from %d bytes of input exceeds the limit", t.constructed, t.offset)
+	}
+	return nil
+}
+
+// structOf creates a struct type from fields decoded without a target type,
+// checking that they form a valid struct
+func structOf(fields []reflect.StructField) (reflect.Type, error) {
+	seen := map[string]bool{}
+	size := uintptr(0)
+	for _, field := range fields {
+		if !token.IsIdentifier(field.Name) || !token.IsExported(field.Name) {
+			return nil, fmt.Errorf("cannot create a struct with field %q without a target type", field.Name)
+		} else if seen[field.Name] {
+			return nil, fmt.Errorf("struct has more than one field named %q", field.Name)
+		}
+		seen[field.Name] = true
+		size += field.Type.Size()
+		if size > maxTypeSize {
+			return nil, fmt.Errorf("struct is too large to create without a target type")
+		}
+	}
+	return reflect.StructOf(fields), nil
+}
+
+// arrayOf creates an array type from a description in the input
+func arrayOf(length uint64, elem reflect.Type) (reflect.Type, error) {
+	if length > math.MaxInt32 || (elem.Size() > 0 && length > maxTypeSize/uint64(elem.Size())) {
+		return nil, fmt.Errorf("array of %d %s is too large to create without a target type", length, elem)
+	}
+	return reflect.ArrayOf(int(length), elem), nil
 }
 
 func (t *decodeTransformer) decode_string(stop uint64) (*reflect.Value, error) {
@@ -293,7 +557,7 @@ func (t *decodeTransformer) decode_string(stop uint64) (*reflect.Value, error) {
 	if err != nil {
 		return nil, err
 	}
-	str := string(buf.Bytes())
+	str := string(buf)
 
 	val := reflect.New(reflect.TypeOf(str)).Elem()
 	val.SetString(str)
@@ -301,142 +565,353 @@ func (t *decodeTransformer) decode_string(stop uint64) (*reflect.Value, error) {
 	return &val, nil
 }
 
-func (t *decodeTransformer) decode_bool(stop uint64) (*reflect.Value, error) {
-	t.stack.Push("bool")
+func (t *decodeTransformer) decode_bytes(stop uint64) (*reflect.Value, error) {
+	t.stack.Push("bytes")
 	buf, err := t.readN(stop)
 	if err != nil {
 		return nil, err
 	}
-	var bVal bool
-	err = binary.Read(buf, BYTE_ORDER, &bVal)
-	if err != nil {
-		return nil, err
-	}
-	val := reflect.New(reflect.TypeOf(bVal)).Elem()
-	val.SetBool(bVal)
+	val := reflect.ValueOf(buf)
 	t.stack.Pop()
 	return &val, nil
 }
 
+func (t *decodeTransformer) decode_bool(stop uint64) (*reflect.Value, error) {
+	return t.decode_fixed("bool", stop, new(bool))
+}
+
 func (t *decodeTransformer) decode_int(stop uint64) (*reflect.Value, error) {
 	t.stack.Push("int")
-	buf, err := t.readN(stop)
-	if err != nil {
-		return nil, err
-	}
 	var iVal int64
-	err = binary.Read(buf, BYTE_ORDER, &iVal)
+	err := t.readFixed(stop, &iVal)
 	if err != nil {
 		return nil, err
 	}
-	val := reflect.New(reflect.TypeOf(int(iVal))).Elem()
+	val := reflect.New(reflect.TypeOf(int(0))).Elem()
+	if val.OverflowInt(iVal) {
+		return nil, fmt.Errorf("value %d overflows int on this platform", iVal)
+	}
 	val.SetInt(iVal)
 	t.stack.Pop()
 	return &val, nil
 }
 
 func (t *decodeTransformer) decode_int64(stop uint64) (*reflect.Value, error) {
-	t.stack.Push("int64")
-	buf, err := t.readN(stop)
-	if err != nil {
-		return nil, err
-	}
-	var iVal int64
-	err = binary.Read(buf, BYTE_ORDER, &iVal)
-	if err != nil {
-		return nil, err
-	}
-	val := reflect.New(reflect.TypeOf(iVal)).Elem()
-	val.SetInt(iVal)
-	t.stack.Pop()
-	return &val, nil
+	return t.decode_fixed("int64", stop, new(int64))
 }
+
 func (t *decodeTransformer) decode_uint(stop uint64) (*reflect.Value, error) {
 	t.stack.Push("uint")
-	buf, err := t.readN(stop)
-	if err != nil {
-		return nil, err
-	}
 	var uVal uint64
-	err = binary.Read(buf, BYTE_ORDER, &uVal)
+	err := t.readFixed(stop, &uVal)
 	if err != nil {
 		return nil, err
 	}
-	val := reflect.New(reflect.TypeOf(uint(uVal))).Elem()
+	val := reflect.New(reflect.TypeOf(uint(0))).Elem()
+	if val.OverflowUint(uVal) {
+		return nil, fmt.Errorf("value %d overflows uint on this platform", uVal)
+	}
 	val.SetUint(uVal)
 	t.stack.Pop()
 	return &val, nil
 }
 
 func (t *decodeTransformer) decode_uint64(stop uint64) (*reflect.Value, error) {
-	t.stack.Push("uint64")
-	buf, err := t.readN(stop)
+	return t.decode_fixed("uint64", stop, new(uint64))
+}
+
+func (t *decodeTransformer) decode_uint8(stop uint64) (*reflect.Value, error) {
+	return t.decode_fixed("uint8", stop, new(uint8))
+}
+
+func (t *decodeTransformer) decode_float(stop uint64) (*reflect.Value, error) {
+	return t.decode_fixed("float64", stop, new(float64))
+}
+
+// decode_fixed reads a fixed width value into ptr, which must point at a type
+// supported by encoding/binary
+func (t *decodeTransformer) decode_fixed(name string, stop uint64, ptr any) (*reflect.Value, error) {
+	t.stack.Push(name)
+	err := t.readFixed(stop, ptr)
 	if err != nil {
 		return nil, err
 	}
+	val := reflect.ValueOf(ptr).Elem()
+	t.stack.Pop()
+	return &val, nil
+}
+
+func (t *decodeTransformer) decode_uintptr(stop uint64) (*reflect.Value, error) {
+	t.stack.Push("uintptr")
 	var uVal uint64
-	err = binary.Read(buf, BYTE_ORDER, &uVal)
+	err := t.readFixed(stop, &uVal)
 	if err != nil {
 		return nil, err
 	}
-	val := reflect.New(reflect.TypeOf(uVal)).Elem()
+	val := reflect.New(reflect.TypeOf(uintptr(0))).Elem()
+	if val.OverflowUint(uVal) {
+		return nil, fmt.Errorf("value %d overflows uintptr on this platform", uVal)
+	}
 	val.SetUint(uVal)
 	t.stack.Pop()
 	return &val, nil
 }
 
-func (t *decodeTransformer) decode_uint8(stop uint64) (*reflect.Value, error) {
-	t.stack.Push("uint8")
-	buf, err := t.readN(stop)
-	if err != nil {
-		return nil, err
-	}
-	var uVal uint8
-	err = binary.Read(buf, BYTE_ORDER, &uVal)
+func (t *decodeTransformer) decode_nil(stop uint64) (*reflect.Value, error) {
+	t.stack.Push("invalid")
+	err := t.skip(stop)
 	if err != nil {
 		return nil, err
 	}
-	val := reflect.New(reflect.TypeOf(uVal)).Elem()
-	val.SetUint(uint64(uVal))
+	val := reflect.ValueOf((*interface{})(nil)).Elem()
 	t.stack.Pop()
 	return &val, nil
 }
 
-func (t *decodeTransformer) decode_float(stop uint64) (*reflect.Value, error) {
-	t.stack.Push("float64")
-	buf, err := t.readN(stop)
+// readFixed reads a payload of n bytes into ptr, which must point at a type
+// supported by encoding/binary of exactly that size
+func (t *decodeTransformer) readFixed(n uint64, ptr any) error {
+	if t.compact {
+		if isVarint, err := t.readCompact(n, ptr); isVarint {
+			return err
+		}
+	}
+	if size := binary.Size(ptr); size < 0 || uint64(size) != n {
+		return fmt.Errorf("payload of %d bytes does not match fixed width %d", n, size)
+	}
+	word, err := t.readWord(n)
+	if err != nil {
+		return err
+	}
+	switch v := ptr.(type) {
+	case *bool:
+		*v = word[0] != 0
+	case *int8:
+		*v = int8(word[0])
+	case *uint8:
+		*v = word[0]
+	case *int16:
+		*v = int16(BYTE_ORDER.Uint16(word[:]))
+	case *uint16:
+		*v = BYTE_ORDER.Uint16(word[:])
+	case *int32:
+		*v = int32(BYTE_ORDER.Uint32(word[:]))
+	case *uint32:
+		*v = BYTE_ORDER.Uint32(word[:])
+	case *int64:
+		*v = int64(BYTE_ORDER.Uint64(word[:]))
+	case *uint64:
+		*v = BYTE_ORDER.Uint64(word[:])
+	case *float32:
+		*v = math.Float32frombits(BYTE_ORDER.Uint32(word[:]))
+	case *float64:
+		*v = math.Float64frombits(BYTE_ORDER.Uint64(word[:]))
+	case *complex64:
+		*v = complex(math.Float32frombits(BYTE_ORDER.Uint32(word[:])), math.Float32frombits(BYTE_ORDER.Uint32(word[4:])))
+	case *complex128:
+		*v = complex(math.Float64frombits(BYTE_ORDER.Uint64(word[:])), math.Float64frombits(BYTE_ORDER.Uint64(word[8:])))
+	default:
+		return binary.Read(bytes.NewReader(append([]byte{}, word[:n]...)), BYTE_ORDER, ptr)
+	}
+	return nil
+}
+
+// readWord reads a payload of at most 16 bytes a byte at a time, which unlike
+// readN does not allocate
+func (t *decodeTransformer) readWord(n uint64) ([16]byte, error) {
+	var word [16]byte
+	if n > uint64(len(word)) {
+		return word, fmt.Errorf("payload of %d bytes is too long for a scalar", n)
+	}
+	for i := range word[:n] {
+		b, err := t.readByte()
+		if err != nil {
+			return word, err
+		}
+		word[i] = b
+	}
+	return word, nil
+}
+
+// read_scalar reads a scalar payload of objectType straight into target,
+// which must be of the kind decoded from objectType or, for int and int64,
+// either of them
+func (t *decodeTransformer) read_scalar(target reflect.Value, objectType EncodedType, payloadLen uint64) error {
+	kind := controlKind[objectType]
+	if objectType == STRING {
+		buf, err := t.readN(payloadLen)
+		if err != nil {
+			return err
+		}
+		target.SetString(string(buf))
+		return nil
+	} else if t.compact && (varintSigned[kind] || varintUnsigned[kind]) {
+		word, err := t.readWord(payloadLen)
+		if err != nil {
+			return err
+		}
+		var read int
+		if varintSigned[kind] {
+			var v int64
+			v, read = binary.Varint(word[:payloadLen])
+			err = storeInt(target, v)
+		} else {
+			var v uint64
+			v, read = binary.Uvarint(word[:payloadLen])
+			err = storeUint(target, v)
+		}
+		if read <= 0 || uint64(read) != payloadLen {
+			return fmt.Errorf("malformed varint payload")
+		}
+		return err
+	}
+	width, ok := fixedWidth[kind]
+	if !ok || width != payloadLen {
+		return fmt.Errorf("payload of %d bytes does not match fixed width %d", payloadLen, width)
+	}
+	word, err := t.readWord(payloadLen)
+	if err != nil {
+		return err
+	}
+	return storeFixed(target, kind, word)
+}
+
+// storeFixed stores a scalar of kind read from its fixed width payload
+func storeFixed(target reflect.Value, kind reflect.Kind, word [16]byte) error {
+	switch kind {
+	case reflect.Bool:
+		target.SetBool(word[0] != 0)
+	case reflect.Int8:
+		target.SetInt(int64(int8(word[0])))
+	case reflect.Int16:
+		target.SetInt(int64(int16(BYTE_ORDER.Uint16(word[:]))))
+	case reflect.Int32:
+		target.SetInt(int64(int32(BYTE_ORDER.Uint32(word[:]))))
+	case reflect.Int, reflect.Int64:
+		return storeInt(target, int64(BYTE_ORDER.Uint64(word[:])))
+	case reflect.Uint8:
+		target.SetUint(uint64(word[0]))
+	case reflect.Uint16:
+		target.SetUint(uint64(BYTE_ORDER.Uint16(word[:])))
+	case reflect.Uint32:
+		target.SetUint(uint64(BYTE_ORDER.Uint32(word[:])))
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return storeUint(target, BYTE_ORDER.Uint64(word[:]))
+	case reflect.Float32:
+		target.SetFloat(float64(math.Float32frombits(BYTE_ORDER.Uint32(word[:]))))
+	case reflect.Float64:
+		target.SetFloat(math.Float64frombits(BYTE_ORDER.Uint64(word[:])))
+	case reflect.Complex64:
+		target.SetComplex(complex128(complex(math.Float32frombits(BYTE_ORDER.Uint32(word[:])), math.Float32frombits(BYTE_ORDER.Uint32(word[4:])))))
+	case reflect.Complex128:
+		target.SetComplex(complex(math.Float64frombits(BYTE_ORDER.Uint64(word[:])), math.Float64frombits(BYTE_ORDER.Uint64(word[8:]))))
+	}
+	return nil
+}
+
+func (t *decodeTransformer) readN(n uint64) ([]byte, error) {
+	if n > math.MaxInt {
+		return nil, fmt.Errorf("payload length %d overflows int on this platform", n)
+	}
+	err := t.check_len(n)
 	if err != nil {
 		return nil, err
 	}
-	var fVal float64
-	err = binary.Read(buf, BYTE_ORDER, &fVal)
+	if n > readChunk && !t.sized {
+		// the length of input is not known to cover n, so the buffer is grown
+		// as data arrives rather than allocated for n bytes up front
+		buf := bytes.NewBuffer([]byte{})
+		read, err := io.CopyN(buf, t.data, int64(n))
+		t.offset += uint64(read)
+		if err == io.EOF {
+			err = errUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	arr := make([]byte, n)
+	read, err := io.ReadFull(t.data, arr)
+	t.offset += uint64(read)
+	if err == io.EOF {
+		err = errUnexpectedEOF
+	}
 	if err != nil {
 		return nil, err
 	}
-	val := reflect.New(reflect.TypeOf(fVal)).Elem()
-	val.SetFloat(fVal)
-	t.stack.Pop()
-	return &val, nil
+	return arr, nil
 }
 
-func (t *decodeTransformer) decode_nil(stop uint64) (*reflect.Value, error) {
-	t.stack.Push("invalid")
-	val := reflect.ValueOf((*interface{})(nil)).Elem()
-	t.stack.Pop()
-	return &val, nil
+// readChunk is the longest payload allocated for in full before it is read
+// from input of unknown length
+const readChunk = 1 << 16
+
+// skip discards the next n bytes of input
+func (t *decodeTransformer) skip(n uint64) error {
+	if n > math.MaxInt {
+		return fmt.Errorf("payload length %d overflows int on this platform", n)
+	}
+	err := t.check_len(n)
+	if err != nil {
+		return err
+	}
+	discarded, err := t.data.Discard(int(n))
+	t.offset += uint64(discarded)
+	if err == io.EOF {
+		err = errUnexpectedEOF
+	}
+	return err
 }
 
-func (t *decodeTransformer) readN(n uint64) (*bytes.Buffer, error) {
-	arr := []byte{}
-	for i := uint64(0); i < n; i++ {
-		b, e := t.data.ReadByte()
-		if e != nil {
-			return nil, e
-		}
+// readByte reads a single byte of input
+func (t *decodeTransformer) readByte() (byte, error) {
+	err := t.check_size(1)
+	if err != nil {
+		return 0, err
+	}
+	b, err := t.data.ReadByte()
+	if err == io.EOF {
+		return 0, errUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	t.offset++
+	return b, nil
+}
 
-		arr = append(arr, b)
+// read_name reads a STRING value naming a struct field or type, described by
+// what in errors
+func (t *decodeTransformer) read_name(what string) (string, error) {
+	objectType, payloadLen, err := t.header()
+	if err != nil {
+		return "", err
+	}
+	if objectType != STRING {
+		return "", mismatch(reflect.String, controlName(objectType), "encoded %s must be of type string, not %s", what, controlName(objectType))
+	}
+	buf, err := t.readN(payloadLen)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
 
+// skip_value discards the next complete value
+func (t *decodeTransformer) skip_value() error {
+	_, payloadLen, err := t.header()
+	if err != nil {
+		return err
 	}
-	buf := bytes.NewBuffer(arr)
-	return buf, nil
+	return t.skip(payloadLen)
+}
+
+// expectEnd checks that exactly the payload of a container was consumed
+func (t *decodeTransformer) expectEnd(end uint64) error {
+	if t.offset > end {
+		return fmt.Errorf("encoded value overran its container by %d bytes", t.offset-end)
+	} else if t.offset < end {
+		return fmt.Errorf("container has %d bytes left after its encoded value", end-t.offset)
+	}
+	return nil
 }
@@ -0,0 +1,271 @@
+package gbin
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Dump writes the structure of data to w, one line per value giving its
+// offset into data, its type and payload length, and the value of scalars.
+// The zero values and type descriptors prefixing containers are included, and
+// data holding a sequence of values is dumped in full. The values in
+// compressed envelopes are given offsets into their decompressed payload.
+// Options limiting decoding apply.
+func Dump(w io.Writer, data []byte, opts ...Option) (err error) {
+	tf := newDecodeTransformer(bufio.NewReader(bytes.NewReader(data)), newTrace(), newOptions(opts))
+	tf.sized, tf.size = true, uint64(len(data))
+	defer recoverError(&err, func(err error) error {
+		return decodeError(tf, err)
+	})
+	d := &dumper{tf: tf, w: w}
+	for tf.offset < tf.size {
+		err = d.next()
+		if err != nil {
+			return decodeError(tf, err)
+		}
+	}
+	return nil
+}
+
+// dumper writes the structure of values read from a decodeTransformer
+type dumper struct {
+	tf    *decodeTransformer
+	w     io.Writer
+	depth int
+	// base is the offset of the input of tf within the dumped data
+	base uint64
+}
+
+func (d *dumper) line(offset uint64, format string, a ...any) error {
+	_, err := fmt.Fprintf(d.w, "%08x  %s%s\n", d.base+offset, strings.Repeat("  ", d.depth), fmt.Sprintf(format, a...))
+	return err
+}
+
+// next dumps the next value, and the envelope holding it if there is one
+func (d *dumper) next() error {
+	offset := d.tf.offset
+	env, err := d.tf.open_envelope()
+	if err != nil {
+		return err
+	} else if env == nil {
+		err = d.begin()
+		if err == nil {
+			err = d.value("")
+		}
+		return err
+	}
+	compression, base := "uncompressed", d.base+env.offset
+	if env.compression != nil {
+		compression, base = fmt.Sprintf("%s, %d bytes decompressed", env.compression.Name, env.payload.size), 0
+	}
+	err = d.line(offset, "envelope v%d (%d bytes, %s)", ENVELOPE_VERSION, env.stored, compression)
+	if err != nil {
+		return err
+	}
+	nested := &dumper{tf: env.payload, w: d.w, depth: d.depth + 1, base: base}
+	err = nested.begin()
+	if err == nil {
+		err = nested.value("")
+	}
+	if err == nil {
+		err = env.end()
+	}
+	return err
+}
+
+// begin dumps the schema and format of the next value, as the decoder's begin
+// consumes them
+func (d *dumper) begin() error {
+	d.tf.compact = false
+	offset := d.tf.offset
+	objectType, err := d.tf.peek_type()
+	if err == nil && objectType == SCHEMA {
+		var desc *typeDesc
+		desc, err = d.tf.read_schema()
+		if err != nil {
+			return err
+		}
+		err = d.line(offset, "schema %s", schemaOf(desc))
+		if err == nil {
+			offset = d.tf.offset
+			objectType, err = d.tf.peek_type()
+		}
+	}
+	if err != nil || objectType != FORMAT {
+		return err
+	}
+	control, err := d.tf.readByte()
+	if err != nil {
+		return err
+	}
+	if version := control & 0b00000111; version != COMPACT_VERSION {
+		return fmt.Errorf("unsupported compact format version %d", version)
+	}
+	d.tf.compact = true
+	return d.line(offset, "format compact v%d", COMPACT_VERSION)
+}
+
+// value dumps the next value, labelling its line with prefix
+func (d *dumper) value(prefix string) error {
+	err := d.tf.descend()
+	if err != nil {
+		return err
+	}
+	defer d.tf.ascend()
+	offset := d.tf.offset
+	objectType, payloadLen, err := d.tf.header()
+	if err != nil {
+		return err
+	}
+	name := prefix + controlName(objectType)
+	switch objectType {
+	case INTERFACE, TYPED, STRUCT, MAP, SLICE, ARRAY, PTR, REFPTR:
+		err = d.line(offset, "%s (%d bytes)", name, payloadLen)
+		if err == nil {
+			err = d.container(objectType, d.tf.offset+payloadLen)
+		}
+		return err
+	case REF:
+		var id uint64
+		err = d.tf.readFixed(payloadLen, &id)
+		if err != nil {
+			return err
+		}
+		return d.line(offset, "%s %d", name, id)
+	case EMBEDDED:
+		err = d.line(offset, "%s (%d bytes)", name, payloadLen)
+		if err != nil {
+			return err
+		}
+		return d.embedded(payloadLen)
+	case INVALID:
+		err = d.tf.skip(payloadLen)
+		if err != nil {
+			return err
+		}
+		return d.line(offset, "%s", name)
+	}
+	if _, ok := controlKind[objectType]; !ok && objectType != BYTES {
+		return fmt.Errorf("encoding error: unknown type code 0x%x", byte(objectType))
+	}
+	scalar, err := d.tf.decode_payload(objectType, payloadLen)
+	if err != nil {
+		return err
+	}
+	return d.line(offset, "%s (%d bytes) %s", name, payloadLen, formatScalar(scalar.Interface()))
+}
+
+// container dumps the contents of a container ending at end
+func (d *dumper) container(objectType EncodedType, end uint64) error {
+	d.depth++
+	defer func() { d.depth-- }()
+	var descs []*typeDesc
+	var err error
+	switch objectType {
+	case MAP:
+		descs, err = d.element_types(2)
+	case SLICE, ARRAY, PTR, REFPTR:
+		descs, err = d.element_types(1)
+	}
+	if err != nil {
+		return err
+	}
+	for count := 1; d.tf.offset < end; count++ {
+		err = d.tf.check_count(count)
+		if err != nil {
+			return err
+		}
+		switch objectType {
+		case STRUCT:
+			err = d.value("field ")
+			if err == nil {
+				err = d.value("")
+			}
+		case MAP:
+			err = d.element("key ", descs[0])
+			if err == nil {
+				err = d.element("val ", descs[1])
+			}
+		case INTERFACE, TYPED, PTR, REFPTR:
+			err = d.value("")
+		default:
+			err = d.element("", descs[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return d.tf.expectEnd(end)
+}
+
+// element_types dumps the n zero values or descriptors prefixing the contents
+// of a container, returning the descriptors
+func (d *dumper) element_types(n int) ([]*typeDesc, error) {
+	descs := make([]*typeDesc, n)
+	for i := range descs {
+		if !d.tf.compact {
+			err := d.value("zero ")
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		offset := d.tf.offset
+		desc, err := d.tf.descriptor()
+		if err != nil {
+			return nil, err
+		}
+		descs[i] = desc
+		err = d.line(offset, "type %s", schemaOf(desc))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return descs, nil
+}
+
+// element dumps an element of a container, which may be packed
+func (d *dumper) element(prefix string, desc *typeDesc) error {
+	code, ok := packed(desc)
+	if !ok {
+		return d.value(prefix)
+	}
+	offset := d.tf.offset
+	scalar, err := d.tf.decode_packed(code)
+	if err != nil {
+		return err
+	}
+	return d.line(offset, "%spacked %s %s", prefix, controlName(code), formatScalar(scalar.Interface()))
+}
+
+// embedded dumps an EMBEDDED payload, which is in the standard format
+func (d *dumper) embedded(payloadLen uint64) error {
+	base := d.base + d.tf.offset
+	inner, err := d.tf.embedded(payloadLen)
+	if err != nil {
+		return err
+	}
+	nested := &dumper{tf: inner, w: d.w, depth: d.depth + 1, base: base}
+	err = nested.value("")
+	if err != nil {
+		return err
+	}
+	if inner.offset != payloadLen {
+		return fmt.Errorf("embedded value is followed by %d bytes", payloadLen-inner.offset)
+	}
+	return nil
+}
+
+func formatScalar(v any) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []byte:
+		return fmt.Sprintf("%x", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,99 @@
+package gbin_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+func TestDump(t *testing.T) {
+	type record struct {
+		Name string `gbin:"name"`
+		Tags []int8
+	}
+	data := record{Name: "a", Tags: []int8{1, -1}}
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"00000000  struct (26 bytes)",
+		"00000002    field string (4 bytes) \"name\"",
+		"00000008    string (1 bytes) \"a\"",
+		"0000000b    field string (4 bytes) \"Tags\"",
+		"00000011    slice (9 bytes)",
+		"00000013      zero int8 (1 bytes) 0",
+		"00000016      int8 (1 bytes) 1",
+		"00000019      int8 (1 bytes) -1",
+		"",
+	}, "\n")
+	out := bytes.NewBuffer([]byte{})
+	err = gbin.Dump(out, encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected {
+		t.Fatalf("expected dump\n%s\ngot\n%s", expected, out)
+	}
+}
+
+func TestDumpCompact(t *testing.T) {
+	data := map[string]userID{"a": {"usr", 1}}
+	encoded, err := gbin.NewEncoder[map[string]userID](gbin.WithSchema(), gbin.WithCompact()).Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a sequence of values is dumped in full
+	encoded = append(encoded, encoded...)
+	out := bytes.NewBuffer([]byte{})
+	err = gbin.Dump(out, encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"00000000  schema map[string]any",
+		"00000005  format compact v1",
+		"00000008    type string",
+		"00000009    type any",
+		"0000000a    key packed string \"a\"",
+		"0000000c    val embedded value (21 bytes)",
+		"0000000e      slice (19 bytes)",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Fatalf("expected dump to contain %q, got\n%s", line, out)
+		}
+	}
+	if strings.Count(out.String(), "schema") != 2 {
+		t.Fatalf("expected both values to be dumped, got\n%s", out)
+	}
+	if err := gbin.Dump(out, encoded[:len(encoded)-1]); err == nil {
+		t.Fatal("expected an error dumping truncated data")
+	}
+}
+
+func TestDumpEnvelope(t *testing.T) {
+	data := []int8{1}
+	for _, c := range []struct {
+		opt      gbin.Option
+		envelope string
+		first    string
+	}{
+		{gbin.WithEnvelope(), "00000000  envelope v1 (8 bytes, uncompressed)", "0000000e    slice (6 bytes)"},
+		{gbin.WithCompression(gbin.Gzip), "00000000  envelope v1 (", "00000000    slice (6 bytes)"},
+	} {
+		encoded, err := gbin.NewEncoder[[]int8](c.opt).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := bytes.NewBuffer([]byte{})
+		err = gbin.Dump(out, encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(out.String(), c.envelope) || !strings.Contains(out.String(), "\n"+c.first+"\n") {
+			t.Fatalf("unexpected dump\n%s", out)
+		}
+	}
+}
@@ -0,0 +1,816 @@
+package gbin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// DecodeDynamic decodes data without knowing the type it was encoded from,
+// returning a generic tree of values:
+//
+//   - structs become map[string]any, keyed by encoded field name
+//   - maps become map[any]any, with keys which would become maps or
+//     slices held as a CompositeKey
+//   - slices and arrays become []any
+//   - pointers become the value they point to, or nil
+//   - nil slices and maps become nil
+//   - scalars keep their Go types, and byte payloads of custom encodings
+//     become []byte
+//
+// Shared and cyclic pointers encoded WithReferences are shared in the tree
+// where they point to structs or maps. Options limiting decoding apply.
+func DecodeDynamic(data []byte, opts ...Option) (tree any, err error) {
+	tf := newDecodeTransformer(bufio.NewReader(bytes.NewReader(data)), newTrace(), newOptions(opts))
+	tf.sized, tf.size = true, uint64(len(data))
+	defer recoverError(&err, func(err error) error {
+		return decodeError(tf, err)
+	})
+	env, err := tf.open_envelope()
+	if err != nil {
+		return nil, decodeError(tf, err)
+	} else if env != nil {
+		tf = env.payload
+	}
+	d := newDynamic(tf)
+	err = tf.begin()
+	if err != nil {
+		return nil, decodeError(tf, err)
+	}
+	tree, err = d.visit()
+	if err == nil && env != nil {
+		err = env.end()
+	}
+	if err != nil {
+		return nil, decodeError(tf, err)
+	}
+	return tree, nil
+}
+
+// dynamic decodes values into generic trees, reading them from a
+// decodeTransformer
+type dynamic struct {
+	tf   *decodeTransformer
+	refs []any
+	// define is the reference which the next value defines, or -1
+	define int
+}
+
+func newDynamic(tf *decodeTransformer) *dynamic {
+	return &dynamic{
+		tf:     tf,
+		define: -1,
+	}
+}
+
+// pendingRef marks a reference whose value is still being decoded
+type pendingRef struct{}
+
+func (d *dynamic) visit() (any, error) {
+	err := d.tf.descend()
+	if err != nil {
+		return nil, err
+	}
+	defer d.tf.ascend()
+	objectType, payloadLen, err := d.tf.header()
+	if err != nil {
+		return nil, err
+	}
+	return d.visit_payload(objectType, payloadLen)
+}
+
+func (d *dynamic) visit_payload(objectType EncodedType, payloadLen uint64) (any, error) {
+	// structs and maps being pointed to are defined as soon as they exist, so
+	// that references to them from within themselves resolve
+	define := d.define
+	d.define = -1
+	switch objectType {
+	case INTERFACE:
+		d.tf.stack.Push("interface")
+		end := d.tf.offset + payloadLen
+		inner, err := d.visit()
+		if err != nil {
+			return nil, err
+		}
+		err = d.tf.expectEnd(end)
+		if err != nil {
+			return nil, err
+		}
+		d.tf.stack.Pop()
+		return inner, nil
+	case TYPED:
+		d.tf.stack.Push("typed")
+		end := d.tf.offset + payloadLen
+		_, err := d.tf.read_name("type name")
+		if err != nil {
+			return nil, err
+		}
+		inner, err := d.visit()
+		if err != nil {
+			return nil, err
+		}
+		err = d.tf.expectEnd(end)
+		if err != nil {
+			return nil, err
+		}
+		d.tf.stack.Pop()
+		return inner, nil
+	case STRUCT:
+		node := map[string]any{}
+		if define >= 0 {
+			d.refs[define] = node
+		}
+		return node, d.visit_struct(node, payloadLen)
+	case MAP:
+		node := map[any]any{}
+		if define >= 0 {
+			d.refs[define] = node
+		}
+		return node, d.visit_map(node, payloadLen)
+	case SLICE, ARRAY:
+		return d.visit_list(payloadLen)
+	case PTR:
+		return d.visit_ptr(payloadLen)
+	case REFPTR:
+		return d.visit_ref_ptr(payloadLen)
+	case REF:
+		return d.visit_ref(payloadLen)
+	case EMBEDDED:
+		return d.visit_embedded(payloadLen)
+	case INVALID:
+		return nil, d.tf.skip(payloadLen)
+	}
+	if _, ok := controlKind[objectType]; !ok && objectType != BYTES {
+		return nil, fmt.Errorf("encoding error: unknown type code 0x%x", byte(objectType))
+	}
+	scalar, err := d.tf.decode_payload(objectType, payloadLen)
+	if err != nil {
+		return nil, err
+	}
+	return scalar.Interface(), nil
+}
+
+// element decodes an element of a container
+func (d *dynamic) element(desc *typeDesc) (any, error) {
+	if code, ok := packed(desc); ok {
+		scalar, err := d.tf.decode_packed(code)
+		if err != nil {
+			return nil, err
+		}
+		return scalar.Interface(), nil
+	}
+	return d.visit()
+}
+
+func (d *dynamic) visit_struct(node map[string]any, payloadLen uint64) error {
+	d.tf.stack.Push("struct")
+	end := d.tf.offset + payloadLen
+	for count := 1; d.tf.offset < end; count++ {
+		err := d.tf.check_count(count)
+		if err != nil {
+			return err
+		}
+		name, err := d.tf.read_name("struct key")
+		if err != nil {
+			return err
+		}
+		d.tf.stack.Field(name)
+		val, err := d.visit()
+		if err != nil {
+			return err
+		}
+		node[name] = val
+		d.tf.stack.Pop()
+	}
+	err := d.tf.expectEnd(end)
+	if err != nil {
+		return err
+	}
+	d.tf.stack.Pop()
+	return nil
+}
+
+func (d *dynamic) visit_map(node map[any]any, payloadLen uint64) error {
+	d.tf.stack.Push("map")
+	end := d.tf.offset + payloadLen
+	descs, err := d.tf.element_types(2)
+	if err != nil {
+		return err
+	}
+	for count := 1; d.tf.offset < end; count++ {
+		err = d.tf.check_count(count)
+		if err != nil {
+			return err
+		}
+		d.tf.stack.Push("key")
+		k, err := d.element(descs[0])
+		if err != nil {
+			return err
+		}
+		if k != nil && !reflect.TypeOf(k).Comparable() {
+			k, err = compositeKey(k)
+			if err != nil {
+				return err
+			}
+		}
+		d.tf.stack.Pop()
+		d.tf.stack.Key(reflect.ValueOf(k))
+		v, err := d.element(descs[1])
+		if err != nil {
+			return err
+		}
+		node[k] = v
+		d.tf.stack.Pop()
+	}
+	err = d.tf.expectEnd(end)
+	if err != nil {
+		return err
+	}
+	d.tf.stack.Pop()
+	return nil
+}
+
+func (d *dynamic) visit_list(payloadLen uint64) (any, error) {
+	d.tf.stack.Push("list")
+	end := d.tf.offset + payloadLen
+	descs, err := d.tf.element_types(1)
+	if err != nil {
+		return nil, err
+	}
+	list := []any{}
+	for i := 0; d.tf.offset < end; i++ {
+		err = d.tf.check_count(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		d.tf.stack.Index(i)
+		el, err := d.element(descs[0])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, el)
+		d.tf.stack.Pop()
+	}
+	err = d.tf.expectEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	d.tf.stack.Pop()
+	return list, nil
+}
+
+func (d *dynamic) visit_ptr(payloadLen uint64) (any, error) {
+	d.tf.stack.Push("ptr")
+	end := d.tf.offset + payloadLen
+	_, err := d.tf.element_types(1)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := d.visit()
+	if err != nil {
+		return nil, err
+	}
+	err = d.tf.expectEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	d.tf.stack.Pop()
+	return inner, nil
+}
+
+// visit_ref_ptr decodes a REFPTR, numbering it in the order reached as the
+// encoder does
+func (d *dynamic) visit_ref_ptr(payloadLen uint64) (any, error) {
+	d.tf.stack.Push("refptr")
+	end := d.tf.offset + payloadLen
+	_, err := d.tf.element_types(1)
+	if err != nil {
+		return nil, err
+	}
+	id := len(d.refs)
+	d.refs = append(d.refs, pendingRef{})
+	d.define = id
+	inner, err := d.visit()
+	if err != nil {
+		return nil, err
+	}
+	d.refs[id] = inner
+	err = d.tf.expectEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	d.tf.stack.Pop()
+	return inner, nil
+}
+
+func (d *dynamic) visit_ref(payloadLen uint64) (any, error) {
+	d.tf.stack.Push("ref")
+	var id uint64
+	err := d.tf.readFixed(payloadLen, &id)
+	if err != nil {
+		return nil, err
+	}
+	if id >= uint64(len(d.refs)) {
+		return nil, fmt.Errorf("reference %d has not been defined", id)
+	}
+	if _, pending := d.refs[id].(pendingRef); pending {
+		return nil, fmt.Errorf("reference %d is to a value containing itself, which only structs and maps can be in a generic tree", id)
+	}
+	d.tf.stack.Pop()
+	return d.refs[id], nil
+}
+
+// visit_embedded decodes an EMBEDDED value, which has its own references
+func (d *dynamic) visit_embedded(payloadLen uint64) (any, error) {
+	d.tf.stack.Push("embedded")
+	inner, err := d.tf.embedded(payloadLen)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := newDynamic(inner).visit()
+	if err != nil {
+		return nil, err
+	}
+	if inner.offset != payloadLen {
+		return nil, fmt.Errorf("embedded value is followed by %d bytes", payloadLen-inner.offset)
+	}
+	d.tf.stack.Pop()
+	return tree, nil
+}
+
+// CompositeKey holds a map key of a generic tree which is a struct, array or
+// other value that cannot itself be used as a key, written as JSON. Equal keys
+// have equal JSON, and a CompositeKey can be used as the key of a JSON
+// object, so maps with struct and array keys can be converted to JSON and
+// back. EncodeDynamic accepts a CompositeKey, or a string holding the same
+// JSON, as the key of a map whose keys are structs or arrays.
+type CompositeKey string
+
+// compositeKey writes the key tree k as a CompositeKey
+func compositeKey(k any) (CompositeKey, error) {
+	text, err := json.Marshal(k)
+	if err != nil {
+		return "", fmt.Errorf("map key of type %T cannot be held in a generic tree: %w", k, err)
+	}
+	return CompositeKey(text), nil
+}
+
+// keyTree reads the tree of a composite key written as JSON, with numbers
+// kept as json.Number
+func keyTree(text string, path string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var tree any
+	err := dec.Decode(&tree)
+	if err != nil {
+		return nil, fmt.Errorf("%s: cannot read map key %q: %s", path, text, err.Error())
+	}
+	return tree, nil
+}
+
+// EncodeDynamic encodes a generic tree as a value of the type described by
+// schema, reversing DecodeDynamic. The tree may also hold values decoded from
+// JSON: numbers may be float64 or json.Number, struct and map keys strings,
+// any scalar may be given as a string, and structs and arrays as a string
+// of their JSON, as composite map keys are. Values described as any are
+// written as they are held in the tree, in the way custom encodings are, and
+// recursive types cannot be encoded from their schema.
+func EncodeDynamic(tree any, schema *Schema, opts ...Option) ([]byte, error) {
+	typ, err := schema.goType()
+	if err != nil {
+		return nil, wrapEncode(err)
+	}
+	return encodeTree(reflect.New(typ).Elem(), tree, newOptions(opts))
+}
+
+// encodeTree assigns tree to value, which holds its zero value, and encodes it
+func encodeTree(value reflect.Value, tree any, opts *options) ([]byte, error) {
+	err := newTreeAssigner(opts).assign(value, tree, "value")
+	if err != nil {
+		return nil, wrapEncode(err)
+	}
+	buf := bytes.NewBuffer([]byte{})
+	w := bufio.NewWriter(buf)
+	err = encodeValue(w, value, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return nil, writeError(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// dynamicValue holds a value of a generic tree in a position described as
+// any, which is encoded raw like the output of a Marshaler, so that it decodes
+// into both interfaces and types with custom encodings
+type dynamicValue struct {
+	tree any
+}
+
+func (v dynamicValue) MarshalGbin() ([]byte, error) {
+	return Marshal(v.tree)
+}
+
+// treeAssigner assigns generic trees to values of types created from schemas,
+// and trees decoded from JSON to values of any type
+type treeAssigner struct {
+	// active holds the containers of the tree being assigned, to reject
+	// cycles
+	active map[uintptr]bool
+	opts   *options
+}
+
+func newTreeAssigner(opts *options) *treeAssigner {
+	return &treeAssigner{active: map[uintptr]bool{}, opts: opts}
+}
+
+// enter marks the container node as being assigned
+func (a *treeAssigner) enter(node any, path string) error {
+	ptr := reflect.ValueOf(node).Pointer()
+	if a.active[ptr] {
+		return fmt.Errorf("%s: tree contains a cycle", path)
+	}
+	a.active[ptr] = true
+	return nil
+}
+
+func (a *treeAssigner) leave(node any) {
+	delete(a.active, reflect.ValueOf(node).Pointer())
+}
+
+// assign sets target, which holds its zero value, to tree
+func (a *treeAssigner) assign(target reflect.Value, tree any, path string) error {
+	if tree == nil {
+		return nil
+	}
+	tt := target.Type()
+	if key, ok := tree.(CompositeKey); ok {
+		tree = string(key)
+	}
+	if custom, err := a.assign_custom(target, tree, path); custom || err != nil {
+		return err
+	}
+	if text, ok := tree.(string); ok && (target.Kind() == reflect.Array || target.Kind() == reflect.Struct && tt != reflect.TypeOf(dynamicValue{})) {
+		var err error
+		tree, err = keyTree(text, path)
+		if err != nil {
+			return err
+		}
+	}
+	switch target.Kind() {
+	case reflect.Interface:
+		plain, err := a.plain(tree, path)
+		if err != nil {
+			return err
+		}
+		pv := reflect.ValueOf(plain)
+		if !pv.Type().AssignableTo(tt) {
+			return fmt.Errorf("%s: cannot encode %T as %s", path, plain, tt)
+		}
+		target.Set(pv)
+		return nil
+	case reflect.Pointer:
+		ptr := reflect.New(tt.Elem())
+		err := a.assign(ptr.Elem(), tree, path)
+		if err != nil {
+			return err
+		}
+		target.Set(ptr)
+		return nil
+	case reflect.Slice, reflect.Array:
+		if text, ok := tree.(string); ok && tt.Kind() == reflect.Slice && tt.Elem().Kind() == reflect.Uint8 {
+			// JSON holds byte slices as base64
+			data, err := base64.StdEncoding.DecodeString(text)
+			if err != nil {
+				return fmt.Errorf("%s: cannot read bytes: %s", path, err.Error())
+			}
+			target.SetBytes(data)
+			return nil
+		}
+		list, ok := tree.([]any)
+		if !ok {
+			break
+		}
+		if target.Kind() == reflect.Slice {
+			target.Set(reflect.MakeSlice(tt, len(list), len(list)))
+		} else if len(list) != target.Len() {
+			return fmt.Errorf("%s: cannot encode %d elements as an array of %d", path, len(list), target.Len())
+		}
+		err := a.enter(list, path)
+		if err != nil {
+			return err
+		}
+		for i, el := range list {
+			err = a.assign(target.Index(i), el, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+		a.leave(list)
+		return nil
+	case reflect.Map:
+		entries, ok := treeEntries(tree)
+		if !ok {
+			break
+		}
+		err := a.enter(tree, path)
+		if err != nil {
+			return err
+		}
+		target.Set(reflect.MakeMapWithSize(tt, len(entries)))
+		for k, v := range entries {
+			key := reflect.New(tt.Key()).Elem()
+			err = a.assign(key, k, fmt.Sprintf("%s[%v]", path, k))
+			if err != nil {
+				return err
+			}
+			val := reflect.New(tt.Elem()).Elem()
+			err = a.assign(val, v, fmt.Sprintf("%s[%v]", path, k))
+			if err != nil {
+				return err
+			}
+			target.SetMapIndex(key, val)
+		}
+		a.leave(tree)
+		return nil
+	case reflect.Struct:
+		if tt == reflect.TypeOf(dynamicValue{}) {
+			plain, err := a.plain(tree, path)
+			if err != nil {
+				return err
+			}
+			target.Set(reflect.ValueOf(dynamicValue{plain}))
+			return nil
+		}
+		return a.assign_struct(target, tree, path)
+	default:
+		return assignScalar(target, tree, path)
+	}
+	return fmt.Errorf("%s: cannot encode %T as %s", path, tree, target.Kind())
+}
+
+func (a *treeAssigner) assign_struct(target reflect.Value, tree any, path string) error {
+	entries, ok := treeEntries(tree)
+	if !ok {
+		return fmt.Errorf("%s: cannot encode %T as struct", path, tree)
+	}
+	fields := planOf(target.Type()).fieldsFor(a.opts)
+	if fields.err != nil {
+		return fields.err
+	}
+	err := a.enter(tree, path)
+	if err != nil {
+		return err
+	}
+	for k, v := range entries {
+		name, _ := k.(string)
+		field, ok := fields.byName[name]
+		if !ok {
+			return fmt.Errorf("%s: struct has no field %v", path, k)
+		}
+		fieldVal, err := settableField(target, field.index)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path+"."+name, err.Error())
+		}
+		err = a.assign(fieldVal, v, path+"."+name)
+		if err != nil {
+			return err
+		}
+	}
+	a.leave(tree)
+	return nil
+}
+
+// assign_custom sets target from tree with a registered codec or the target's
+// own unmarshaling methods, reporting whether one was used. The tree holds
+// what DecodeDynamic decodes the custom encoding to, or its JSON. A struct
+// which cannot be unmarshaled from its tree is left to be assigned field by
+// field, as the methods generated by gbingen encode structs as their fields.
+func (a *treeAssigner) assign_custom(target reflect.Value, tree any, path string) (bool, error) {
+	if target.Kind() == reflect.Pointer || target.Kind() == reflect.Interface {
+		return false, nil
+	}
+	tt := target.Type()
+	if codec, ok := a.opts.codecs[tt]; ok {
+		data, err := treeBytes(tree, path)
+		if err != nil {
+			return true, err
+		}
+		return true, methodError(path, codec.unmarshal(data, target))
+	}
+	plan := planOf(tt)
+	if plan.unmarshaler == nil {
+		return false, nil
+	}
+	switch u := receiver(target, plan.unmarshalerPtr).(type) {
+	case Unmarshaler:
+		plain, err := a.plain(tree, path)
+		if err != nil {
+			return true, err
+		}
+		data, err := Marshal(plain)
+		if err == nil {
+			err = u.UnmarshalGbin(data)
+		}
+		if err != nil && tt.Kind() == reflect.Struct {
+			target.SetZero()
+			return false, nil
+		}
+		return true, methodError(path, err)
+	case encoding.BinaryUnmarshaler:
+		data, err := treeBytes(tree, path)
+		if err != nil {
+			return true, err
+		}
+		return true, methodError(path, u.UnmarshalBinary(data))
+	case encoding.TextUnmarshaler:
+		text, ok := tree.(string)
+		if !ok {
+			return true, fmt.Errorf("%s: cannot encode %T as text", path, tree)
+		}
+		return true, methodError(path, u.UnmarshalText([]byte(text)))
+	}
+	return false, nil
+}
+
+// methodError adds path to an error returned by an unmarshaling method
+func methodError(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+// treeBytes returns the bytes held in tree, as []byte or a base64 string
+func treeBytes(tree any, path string) ([]byte, error) {
+	switch node := tree.(type) {
+	case []byte:
+		return node, nil
+	case string:
+		data, err := base64.StdEncoding.DecodeString(node)
+		if err != nil {
+			return nil, fmt.Errorf("%s: cannot read bytes: %s", path, err.Error())
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s: cannot encode %T as bytes", path, tree)
+}
+
+// plain converts the JSON numbers within tree, which is held in a position
+// described as any, to int64 where they are integers and float64 otherwise
+func (a *treeAssigner) plain(tree any, path string) (any, error) {
+	switch node := tree.(type) {
+	case json.Number:
+		if i, err := node.Int64(); err == nil {
+			return i, nil
+		}
+		return node.Float64()
+	case []any:
+		err := a.enter(node, path)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]any, len(node))
+		for i, el := range node {
+			list[i], err = a.plain(el, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		a.leave(node)
+		return list, nil
+	case map[string]any:
+		err := a.enter(node, path)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]any, len(node))
+		for k, v := range node {
+			m[k], err = a.plain(v, path+"."+k)
+			if err != nil {
+				return nil, err
+			}
+		}
+		a.leave(node)
+		return m, nil
+	case map[any]any:
+		err := a.enter(node, path)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[any]any, len(node))
+		for k, v := range node {
+			m[k], err = a.plain(v, fmt.Sprintf("%s[%v]", path, k))
+			if err != nil {
+				return nil, err
+			}
+		}
+		a.leave(node)
+		return m, nil
+	default:
+		return tree, nil
+	}
+}
+
+// treeEntries returns the entries of a struct or map in a generic tree
+func treeEntries(tree any) (map[any]any, bool) {
+	switch node := tree.(type) {
+	case map[any]any:
+		return node, true
+	case map[string]any:
+		entries := make(map[any]any, len(node))
+		for k, v := range node {
+			entries[k] = v
+		}
+		return entries, true
+	default:
+		return nil, false
+	}
+}
+
+// assignScalar sets target, which is of a scalar kind, to tree, converting
+// between numeric types where it can be done exactly and parsing strings
+func assignScalar(target reflect.Value, tree any, path string) error {
+	if n, ok := tree.(json.Number); ok {
+		tree = n.String()
+	}
+	if s, ok := tree.(string); ok {
+		parsed, err := parseDefault(target.Type(), s)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+		target.Set(*parsed)
+		return nil
+	}
+	v := reflect.ValueOf(tree)
+	switch target.Kind() {
+	case reflect.Bool:
+		if v.Kind() == reflect.Bool {
+			target.SetBool(v.Bool())
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := exactInt(v); ok && !target.OverflowInt(i) {
+			target.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u, ok := exactUint(v); ok && !target.OverflowUint(u) {
+			target.SetUint(u)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if v.CanFloat() && (v.Kind() == target.Kind() || !target.OverflowFloat(v.Float())) {
+			target.SetFloat(v.Float())
+			return nil
+		} else if i, ok := exactInt(v); ok {
+			target.SetFloat(float64(i))
+			return nil
+		} else if u, ok := exactUint(v); ok {
+			target.SetFloat(float64(u))
+			return nil
+		}
+	case reflect.Complex64, reflect.Complex128:
+		if v.CanComplex() {
+			target.SetComplex(v.Complex())
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: cannot encode %T %v as %s", path, tree, tree, target.Kind())
+}
+
+// exactInt returns the numeric value v as an int64 if it is one exactly
+func exactInt(v reflect.Value) (int64, bool) {
+	switch {
+	case v.CanInt():
+		return v.Int(), true
+	case v.CanUint():
+		return int64(v.Uint()), v.Uint() <= math.MaxInt64
+	case v.CanFloat():
+		f := v.Float()
+		return int64(f), f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64
+	}
+	return 0, false
+}
+
+// exactUint returns the numeric value v as a uint64 if it is one exactly
+func exactUint(v reflect.Value) (uint64, bool) {
+	switch {
+	case v.CanInt():
+		return uint64(v.Int()), v.Int() >= 0
+	case v.CanUint():
+		return v.Uint(), true
+	case v.CanFloat():
+		f := v.Float()
+		return uint64(f), f == math.Trunc(f) && f >= 0 && f < math.MaxUint64
+	}
+	return 0, false
+}
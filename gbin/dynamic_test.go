@@ -0,0 +1,227 @@
+package gbin_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+type schemaRecord struct {
+	Name   string `gbin:"name"`
+	Scores []float64
+	Grid   [2]int8
+	Attrs  map[string]*int
+	ID     userID
+	Node   *refNode
+	Skip   int `gbin:"-"`
+}
+
+func TestSchema(t *testing.T) {
+	expected := "struct { name string; Scores []float64; Grid [2]int8; Attrs map[string]*int; ID any; " +
+		"Node *struct { Value int; Parent <recursive>; Children []<recursive>; Lookup map[string]<recursive> } }"
+	for _, opts := range [][]gbin.Option{{gbin.WithSchema()}, {gbin.WithSchema(), gbin.WithCompact()}} {
+		data := schemaRecord{Name: "a", Scores: []float64{}, Attrs: map[string]*int{}, ID: userID{"usr", 1}}
+		if pass := runTest(data, opts...); !pass {
+			t.Fatal("failed to round trip data with a schema")
+		}
+		encoded, err := gbin.NewEncoder[schemaRecord](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema, err := gbin.ReadSchema(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if schema.String() != expected {
+			t.Fatalf("expected schema %s, got %s", expected, schema)
+		}
+		if schema.Fields[2].Type.Kind != reflect.Array || schema.Fields[2].Type.Len != 2 {
+			t.Fatalf("expected an array of length 2, got %s", schema.Fields[2].Type)
+		}
+	}
+	encoded, err := gbin.Marshal(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gbin.ReadSchema(encoded); !errors.Is(err, gbin.ErrNoSchema) {
+		t.Fatalf("expected ErrNoSchema, got %v", err)
+	}
+}
+
+func TestDecodeDynamic(t *testing.T) {
+	n := 5
+	data := schemaRecord{
+		Name:   "a",
+		Scores: []float64{1.5},
+		Grid:   [2]int8{1, -1},
+		Attrs:  map[string]*int{"n": &n, "nil": nil},
+		ID:     userID{"usr", 1},
+	}
+	expected := map[string]any{
+		"name":   "a",
+		"Scores": []any{1.5},
+		"Grid":   []any{int8(1), int8(-1)},
+		"Attrs":  map[any]any{"n": 5, "nil": nil},
+		"ID":     []any{"usr", uint32(1)},
+		"Node":   nil,
+	}
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}, {gbin.WithSchema()}} {
+		encoded, err := gbin.NewEncoder[schemaRecord](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := gbin.DecodeDynamic(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, tree) {
+			t.Fatalf("expected %#v, got %#v", expected, tree)
+		}
+	}
+}
+
+func TestDecodeDynamicReferences(t *testing.T) {
+	root := &refNode{Value: 1, Lookup: map[string]*refNode{}}
+	root.Children = []*refNode{{Value: 2, Parent: root}}
+	root.Lookup["self"] = root
+	for _, opts := range [][]gbin.Option{{gbin.WithReferences()}, {gbin.WithReferences(), gbin.WithCompact()}} {
+		encoded, err := gbin.NewEncoder[*refNode](opts...).Encode(&root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := gbin.DecodeDynamic(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node := tree.(map[string]any)
+		self := node["Lookup"].(map[any]any)["self"].(map[string]any)
+		child := node["Children"].([]any)[0].(map[string]any)
+		parent := child["Parent"].(map[string]any)
+		if reflect.ValueOf(self).Pointer() != reflect.ValueOf(node).Pointer() || reflect.ValueOf(parent).Pointer() != reflect.ValueOf(node).Pointer() {
+			t.Fatal("expected references to the root to share its node")
+		}
+		if child["Value"] != 2 {
+			t.Fatalf("expected child value 2, got %v", child["Value"])
+		}
+	}
+}
+
+type dynamicRecord struct {
+	Name   string `gbin:"name"`
+	Scores []float64
+	Grid   [2]int8
+	Attrs  map[string]*int
+	Labels map[int16]string
+	Wave   complex128
+	ID     userID
+}
+
+func TestEncodeDynamic(t *testing.T) {
+	n := 5
+	data := dynamicRecord{
+		Name:   "a",
+		Scores: []float64{1.5, math.Inf(-1)},
+		Grid:   [2]int8{1, -1},
+		Attrs:  map[string]*int{"n": &n},
+		Labels: map[int16]string{-3: "x"},
+		Wave:   complex(1, -2),
+		ID:     userID{"usr", 1},
+	}
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}, {gbin.WithSchema()}, {gbin.WithSchema(), gbin.WithCompact()}} {
+		encoded, err := gbin.NewEncoder[dynamicRecord](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		withSchema, err := gbin.NewEncoder[dynamicRecord](gbin.WithSchema()).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema, err := gbin.ReadSchema(withSchema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := gbin.DecodeDynamic(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reencoded, err := gbin.EncodeDynamic(tree, schema, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, reencoded) {
+			t.Fatalf("expected % x, got % x", encoded, reencoded)
+		}
+	}
+}
+
+func TestEncodeDynamicJSON(t *testing.T) {
+	type jsonRecord struct {
+		Count  int64
+		Ratio  float32
+		Wave   complex64
+		Labels map[uint16]string
+		Extra  any
+		Ptr    *[]bool
+	}
+	input := `{"Count": 9007199254740993, "Ratio": "NaN", "Wave": "(1-2i)", "Labels": {"7": "x"}, "Extra": [1, 2.5, {"a": "b"}], "Ptr": [true]}`
+	dec := json.NewDecoder(bytes.NewReader([]byte(input)))
+	dec.UseNumber()
+	var tree any
+	err := dec.Decode(&tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSchema, err := gbin.NewEncoder[jsonRecord](gbin.WithSchema()).Encode(&jsonRecord{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := gbin.ReadSchema(withSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}} {
+		encoded, err := gbin.EncodeDynamic(tree, schema, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := gbin.NewDecoder[jsonRecord]().Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Count != 9007199254740993 || !math.IsNaN(float64(decoded.Ratio)) || decoded.Wave != complex(1, -2) || decoded.Labels[7] != "x" || len(*decoded.Ptr) != 1 {
+			t.Fatalf("unexpected decoded value %#v", decoded)
+		}
+		extra := []any{int64(1), 2.5, map[string]any{"a": "b"}}
+		if !reflect.DeepEqual(extra, decoded.Extra) {
+			t.Fatalf("expected %#v, got %#v", extra, decoded.Extra)
+		}
+	}
+	for _, bad := range []string{`{"Count": 1.5}`, `{"Count": "x"}`, `{"Missing": 1}`, `{"Labels": {"-1": "x"}}`, `{"Ptr": true}`} {
+		dec := json.NewDecoder(bytes.NewReader([]byte(bad)))
+		dec.UseNumber()
+		var tree any
+		err := dec.Decode(&tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gbin.EncodeDynamic(tree, schema); err == nil {
+			t.Fatalf("expected an error encoding %s", bad)
+		}
+	}
+	recursive, err := gbin.NewEncoder[*refNode](gbin.WithSchema()).Encode(new(*refNode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err = gbin.ReadSchema(recursive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gbin.EncodeDynamic(nil, schema); err == nil {
+		t.Fatal("expected an error encoding a recursive type")
+	}
+}
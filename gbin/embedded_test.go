@@ -0,0 +1,223 @@
+package gbin_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+type Base struct {
+	ID   int
+	Name string
+}
+
+type Audit struct {
+	Name    string
+	Version int `gbin:"Version" json:"Version"`
+}
+
+type Tags []string
+
+type Payload interface{}
+
+type base struct {
+	Hidden int
+}
+
+type promoted struct {
+	Base
+	Extra int
+}
+
+type embeddedPtr struct {
+	*Base
+	Extra int
+}
+
+type shadowed struct {
+	Base
+	*Audit
+	Name string
+}
+
+type ambiguous struct {
+	Base
+	Audit
+}
+
+type tagWins struct {
+	Audit
+	Other struct{ Version int }
+	Versioned
+}
+
+type Versioned struct {
+	Version int
+}
+
+type deep struct {
+	promoted
+	Base
+}
+
+type namedEmbedded struct {
+	Base `gbin:"base" json:"base"`
+	Tags
+	Payload
+}
+
+type unexportedEmbedded struct {
+	base
+	Extra int
+}
+
+type unexportedPtr struct {
+	*base
+}
+
+// encodedNames returns the names of the fields of v as it is encoded
+func encodedNames(t *testing.T, v any) []string {
+	encoded, err := gbin.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := gbin.DecodeDynamic(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for name := range tree.(map[string]any) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jsonNames returns the names of the fields of v as encoding/json encodes it
+func jsonNames(t *testing.T, v any) []string {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]any{}
+	err = json.Unmarshal(encoded, &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestEmbeddedPromotion(t *testing.T) {
+	values := []any{
+		promoted{Base{1, "a"}, 2},
+		embeddedPtr{&Base{1, "a"}, 2},
+		embeddedPtr{nil, 2},
+		shadowed{Base{1, "a"}, &Audit{"b", 3}, "c"},
+		shadowed{Base{1, "a"}, nil, "c"},
+		ambiguous{Base{1, "a"}, Audit{"b", 3}},
+		tagWins{Audit{"a", 1}, struct{ Version int }{2}, Versioned{3}},
+		deep{promoted{Base{1, "a"}, 2}, Base{3, "b"}},
+		namedEmbedded{Base{1, "a"}, Tags{"x"}, 5},
+		unexportedEmbedded{base{1}, 2},
+		unexportedPtr{&base{1}},
+	}
+	for _, v := range values {
+		names, expected := encodedNames(t, v), jsonNames(t, v)
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("%T: expected fields %v as encoding/json has, got %v", v, expected, names)
+		}
+	}
+}
+
+func TestEmbeddedRoundTrip(t *testing.T) {
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}, {gbin.WithCompact(), gbin.WithSchema()}} {
+		ok := runTest(promoted{Base{1, "a"}, 2}, opts...) &&
+			runTest(embeddedPtr{&Base{1, "a"}, 2}, opts...) &&
+			runTest(embeddedPtr{nil, 2}, opts...) &&
+			runTest(shadowed{Base{ID: 1}, &Audit{Version: 3}, "c"}, opts...) &&
+			runTest(deep{promoted{Base{}, 2}, Base{3, "b"}}, opts...) &&
+			runTest(namedEmbedded{Base{1, "a"}, Tags{"x"}, 5}, opts...) &&
+			runTest(unexportedEmbedded{base{1}, 2}, opts...)
+		if !ok {
+			t.Fatalf("failed to round trip embedded structs with %d options", len(opts))
+		}
+	}
+
+	// promoted fields are decoded into embedded pointers as they are reused
+	decoder := gbin.NewDecoder[embeddedPtr]()
+	encoded, err := gbin.Marshal(embeddedPtr{&Base{1, "a"}, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := &Base{5, "b"}
+	reused := embeddedPtr{previous, 0}
+	err = decoder.DecodeInto(encoded, &reused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reused.Base == previous || *reused.Base != (Base{1, "a"}) || *previous != (Base{5, "b"}) {
+		t.Fatalf("expected a new embedded value, got %v", reused.Base)
+	}
+}
+
+func TestEmbeddedUnexportedPointer(t *testing.T) {
+	encoded, err := gbin.Marshal(struct{ Hidden int }{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded unexportedPtr
+	err = gbin.Unmarshal(encoded, &decoded)
+	if err == nil || !strings.Contains(err.Error(), "unexported struct") {
+		t.Fatalf("expected decoding into an embedded pointer to an unexported struct to fail, got %v", err)
+	}
+}
+
+// nestedEmbedding and nestedPtr are laid out as promoted and embeddedPtr
+// were encoded before the fields of embedded structs were promoted
+type nestedEmbedding struct {
+	Base  Base
+	Extra int
+}
+
+type nestedPtr struct {
+	Base  *Base
+	Extra int
+}
+
+func TestEmbeddedNestedData(t *testing.T) {
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}} {
+		old := nestedEmbedding{Base{1, "a"}, 2}
+		encoded, err := gbin.NewEncoder[nestedEmbedding](opts...).Encode(&old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := gbin.NewDecoder[promoted]().Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *decoded != (promoted{Base{1, "a"}, 2}) {
+			t.Fatalf("expected the nested struct to be decoded into the embedded one, got %v", decoded)
+		}
+		oldPtr := nestedPtr{&Base{1, "a"}, 2}
+		encoded, err = gbin.NewEncoder[nestedPtr](opts...).Encode(&oldPtr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ptr, err := gbin.NewDecoder[embeddedPtr]().Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ptr.Base == nil || *ptr.Base != (Base{1, "a"}) {
+			t.Fatalf("expected the nested struct to be decoded into the embedded pointer, got %v", ptr.Base)
+		}
+	}
+}
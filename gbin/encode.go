@@ -1,39 +1,67 @@
 package gbin
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"math/bits"
+	"math"
 	"reflect"
-
-	"github.com/lspaccatrosi16/go-libs/structures/stack"
 )
 
+// encodeTransformer walks a value and streams its encoding to w.
+//
+// Containers are length prefixed, so before a container is written its
+// payload is walked once in measuring mode, where nothing is written and only
+// the number of bytes that would have been produced is counted, so the
+// encoded payload is never held in memory. The lengths of the containers
+// nested within it, and the output of codecs and marshaling methods, are kept
+// from that pass, so that each is only measured once. They are released as
+// they are written, but until then grow with the number of containers and
+// custom encoded values.
 type encodeTransformer struct {
-	stack stack.Stack[string]
-}
-
-func newEncodeTransformer() *encodeTransformer {
-	return &encodeTransformer{}
+	stack     trace
+	w         *bufio.Writer
+	opts      *options
+	measuring bool
+	count     uint64
+	zeroing   map[reflect.Type]bool
+	// zeroValue is set while a zero value is written, in which nil slices and
+	// maps are written as empty containers so that they describe their types
+	zeroValue  bool
+	visiting   map[refKey]bool
+	refs       map[refKey]uint64
+	refOrder   []refKey
+	seq        uint64
+	sizes      map[uint64]uint64
+	marshaled  map[uint64]marshaled
+	mapEntries map[uintptr][]mapEntry
+	// scratch holds the scalar payload and header holds the header of the
+	// value being written, both reused to avoid allocating for every value
+	scratch []byte
+	header  []byte
 }
 
-func (t *encodeTransformer) trace() string {
-	buf := bytes.NewBufferString("/")
-	t.stack.Reverse()
-	for {
-		val, ok := t.stack.Pop()
-		if !ok {
-			break
-		}
-		formatted := fmt.Sprintf("%s/", val)
-		buf.WriteString(formatted)
+func newEncodeTransformer(w *bufio.Writer, opts *options) *encodeTransformer {
+	return &encodeTransformer{
+		w:          w,
+		opts:       opts,
+		zeroing:    map[reflect.Type]bool{},
+		visiting:   map[refKey]bool{},
+		refs:       map[refKey]uint64{},
+		sizes:      map[uint64]uint64{},
+		marshaled:  map[uint64]marshaled{},
+		mapEntries: map[uintptr][]mapEntry{},
 	}
-
-	return buf.String()
 }
 
-func (t *encodeTransformer) encode(v reflect.Value) ([]byte, error) {
+func (t *encodeTransformer) encode(v reflect.Value) error {
+	if custom, err := t.encode_custom(v); custom || err != nil {
+		return err
+	}
+	if t.opts.canonical {
+		v = canonicalScalar(v)
+	}
 	switch v.Kind() {
 	case reflect.Interface:
 		return t.encode_interface(v)
@@ -61,281 +89,476 @@ func (t *encodeTransformer) encode(v reflect.Value) ([]byte, error) {
 		return t.encode_uint8(uint8(v.Uint()))
 	case reflect.Float64:
 		return t.encode_float64(v.Float())
+	case reflect.Int8:
+		return t.encode_fixed("int8", INT8, int8(v.Int()))
+	case reflect.Int16:
+		return t.encode_fixed("int16", INT16, int16(v.Int()))
+	case reflect.Int32:
+		return t.encode_fixed("int32", INT32, int32(v.Int()))
+	case reflect.Uint16:
+		return t.encode_fixed("uint16", UINT16, uint16(v.Uint()))
+	case reflect.Uint32:
+		return t.encode_fixed("uint32", UINT32, uint32(v.Uint()))
+	case reflect.Uintptr:
+		return t.encode_fixed("uintptr", UINTPTR, v.Uint())
+	case reflect.Float32:
+		return t.encode_fixed("float32", FLOAT32, float32(v.Float()))
+	case reflect.Complex64:
+		return t.encode_fixed("complex64", COMPLEX64, complex64(v.Complex()))
+	case reflect.Complex128:
+		return t.encode_fixed("complex128", COMPLEX128, v.Complex())
+	case reflect.Array:
+		return t.encode_array(v)
 	case reflect.Invalid:
 		return t.encode_nil(v)
 	default:
-		return []byte{}, fmt.Errorf("type %s is not currently supported for serialization", v.Kind())
+		return unsupportedError("type %s is not currently supported for serialization", v.Kind())
 	}
 }
 
-func (t *encodeTransformer) encode_nil(i reflect.Value) ([]byte, error) {
+func (t *encodeTransformer) encode_nil(i reflect.Value) error {
 	return t.format_encode(INVALID, []byte{})
 }
 
-func (t *encodeTransformer) encode_interface(i reflect.Value) ([]byte, error) {
+func (t *encodeTransformer) encode_interface(i reflect.Value) error {
 	it := i.Type()
-	stackEntry := fmt.Sprintf("interface(%s)", it.Name())
-	buf := bytes.NewBuffer([]byte{})
-	t.stack.Push(stackEntry)
-	innerVal := i.Elem()
-	encoded, err := t.encode(innerVal)
+	t.stack.PushType("interface", it)
+	var err error
+	if i.IsNil() {
+		err = t.format_container(INTERFACE, func() error {
+			return t.encode(i.Elem())
+		})
+	} else {
+		err = t.encode_typed(i.Elem())
+	}
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
 	t.stack.Pop()
-	buf.Write(encoded)
-	return t.format_encode(INTERFACE, buf.Bytes())
+	return nil
 }
 
 // PAYLOAD: KTYPE,VTYPE ENCODED, ENCODED
-func (t *encodeTransformer) encode_map(m reflect.Value) ([]byte, error) {
-	mt := m.Type()
-	stackEntry := fmt.Sprintf("map[%s]%s(%s)", mt.Key().Kind(), mt.Elem().Kind(), mt.Name())
-	t.stack.Push(stackEntry)
-	mi := m.MapRange()
-	buf := bytes.NewBuffer([]byte{})
-	t.stack.Push("zero_key")
-	zeroK, err := t.encode_zero(mt.Key())
-	if err != nil {
-		return []byte{}, err
-	}
-	buf.Write(zeroK)
-	t.stack.Pop()
-	t.stack.Push("zero_val")
-	zeroV, err := t.encode_zero(mt.Elem())
-	if err != nil {
-		return []byte{}, err
+// A nil map is encoded as nil, so that it decodes as nil rather than empty.
+func (t *encodeTransformer) encode_map(m reflect.Value) error {
+	if m.IsNil() && !t.zeroValue {
+		return t.encode_nil(m)
 	}
-	buf.Write(zeroV)
-	t.stack.Pop()
-	for {
-		if !mi.Next() {
-			break
-		}
-		k := mi.Key()
-		v := mi.Value()
-		kEntry := fmt.Sprintf("key[%v]", k.Interface())
-		t.stack.Push(kEntry)
-		kEnc, err := t.encode(k)
+	mt := m.Type()
+	t.stack.PushType("map", mt)
+	err := t.format_container(MAP, func() error {
+		t.stack.Push("zero_key")
+		err := t.encode_zero(mt.Key())
 		if err != nil {
-			return []byte{}, err
+			return err
 		}
 		t.stack.Pop()
-		vEntry := fmt.Sprintf("val[%v]", k.Interface())
-		t.stack.Push(vEntry)
-		vEnc, err := t.encode(v)
+		t.stack.Push("zero_val")
+		err = t.encode_zero(mt.Elem())
 		if err != nil {
-			return []byte{}, err
+			return err
 		}
 		t.stack.Pop()
-		buf.Write(kEnc)
-		buf.Write(vEnc)
+		keyPacked, valPacked := t.element_packed(mt.Key()), t.element_packed(mt.Elem())
+		entries, err := t.map_entries(m)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			t.stack.Push("key")
+			err := t.encode_element(entry.key, keyPacked)
+			if err != nil {
+				return err
+			}
+			t.stack.Pop()
+			t.stack.Key(entry.key)
+			err = t.encode_element(entry.value, valPacked)
+			if err != nil {
+				return err
+			}
+			t.stack.Pop()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	t.stack.Pop()
-	return t.format_encode(MAP, buf.Bytes())
+	return nil
 }
 
 // PAYLOAD: STRING FIELD NAME, ENCODED VALUE
-func (t *encodeTransformer) encode_struct(value reflect.Value) ([]byte, error) {
+func (t *encodeTransformer) encode_struct(value reflect.Value) error {
 	st := value.Type()
-	stackEntry := fmt.Sprintf("struct(%s)", st.Name())
-	t.stack.Push(stackEntry)
-	buf := bytes.NewBuffer([]byte{})
-	n := value.NumField()
-	ty := value.Type()
-	for i := 0; i < n; i++ {
-		val := value.Field(i)
-		field := ty.Field(i)
-		if !field.IsExported() {
-			continue
+	t.stack.PushType("struct", st)
+	fields := planOf(st).fieldsFor(t.opts)
+	if fields.err != nil {
+		return fields.err
+	}
+	err := t.format_container(STRUCT, func() error {
+		for _, field := range fields.fields {
+			fieldVal, ok := fieldOf(value, field.index)
+			if !ok || field.omitEmpty && isEmptyValue(fieldVal) {
+				continue
+			}
+			t.stack.Field(field.name)
+			t.stack.Push("key")
+			err := t.encode_string(field.name)
+			if err != nil {
+				return err
+			}
+			t.stack.Pop()
+			t.stack.Push("value")
+			err = t.encode(fieldVal)
+			if err != nil {
+				return err
+			}
+			t.stack.Pop()
+			t.stack.Pop()
 		}
-		fEntry := fmt.Sprintf("field[%s]", field.Name)
-		t.stack.Push(fEntry)
-		t.stack.Push("key")
-		fName := field.Name
-		encodedName, err := t.encode(reflect.ValueOf(fName))
-		if err != nil {
-			return []byte{}, err
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	t.stack.Pop()
+	return nil
+}
+
+// PAYLOAD: ZERO VALUE, ENCODED VALUE POINTED AT
+func (t *encodeTransformer) encode_ptr(value reflect.Value) error {
+	if !value.IsNil() {
+		if t.opts.references {
+			return t.encode_ref_ptr(value)
 		}
-		t.stack.Pop()
-		t.stack.Push("value")
-		fieldVal, err := t.encode(val)
+		key := refKey{value.Pointer(), value.Type()}
+		if t.visiting[key] {
+			return fmt.Errorf("encountered a cycle through %s, which can only be encoded WithReferences", value.Type())
+		}
+		t.visiting[key] = true
+		defer delete(t.visiting, key)
+	}
+	t.stack.Push("ptr")
+	err := t.format_container(PTR, func() error {
+		err := t.encode_zero(value.Type().Elem())
 		if err != nil {
-			return []byte{}, err
+			return err
 		}
-		t.stack.Pop()
-		t.stack.Pop()
-		buf.Write(encodedName)
-		buf.Write(fieldVal)
+		// nil within a pointer is read as a nil pointer
+		return t.encode(t.emptyIfNil(value.Elem()))
+	})
+	if err != nil {
+		return err
 	}
 	t.stack.Pop()
-	return t.format_encode(STRUCT, buf.Bytes())
+	return nil
 }
 
-// PAYLOAD: ENCODED VALUE POINTED AT
-func (t *encodeTransformer) encode_ptr(value reflect.Value) ([]byte, error) {
-	t.stack.Push("ptr")
-	buf := bytes.NewBuffer([]byte{})
-	zero, err := t.encode_zero(value.Type().Elem())
+// emptyIfNil returns an empty slice or map in place of v if it is a nil one
+// without a custom encoding, for positions where nil would be read as
+// something else
+func (t *encodeTransformer) emptyIfNil(v reflect.Value) reflect.Value {
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Map || !v.IsNil() {
+		return v
+	} else if _, ok := t.opts.codecs[v.Type()]; ok || planOf(v.Type()).marshaler != nil {
+		return v
+	} else if v.Kind() == reflect.Slice {
+		return reflect.MakeSlice(v.Type(), 0, 0)
+	}
+	return reflect.MakeMap(v.Type())
+}
+
+// PAYLOAD: ZERO ELEMENT, SERIES OF ENCODED ELEMENTS
+// A nil slice is encoded as nil, so that it decodes as nil rather than empty.
+func (t *encodeTransformer) encode_slice(value reflect.Value) error {
+	if value.IsNil() && !t.zeroValue {
+		return t.encode_nil(value)
+	}
+	st := value.Type()
+	t.stack.PushType("slice", st)
+	err := t.format_container(SLICE, func() error {
+		return t.encode_elements(value)
+	})
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
-	buf.Write(zero)
-	pointedAt := value.Elem()
-	encoded, err := t.encode(pointedAt)
+	t.stack.Pop()
+	return nil
+}
+
+// PAYLOAD: ZERO ELEMENT, SERIES OF ENCODED ELEMENTS
+func (t *encodeTransformer) encode_array(value reflect.Value) error {
+	at := value.Type()
+	t.stack.PushType("array", at)
+	err := t.format_container(ARRAY, func() error {
+		return t.encode_elements(value)
+	})
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
 	t.stack.Pop()
-	buf.Write(encoded)
-	return t.format_encode(PTR, buf.Bytes())
+	return nil
 }
 
-// PAYLOAD: SERIES OF BYTES
-func (t *encodeTransformer) encode_slice(value reflect.Value) ([]byte, error) {
-	st := value.Type()
-	stackEntry := fmt.Sprintf("slice[%s]", st.Elem().Kind())
-	t.stack.Push(stackEntry)
-	buf := bytes.NewBuffer([]byte{})
-	n := value.Len()
-	zero, err := t.encode_zero(st.Elem())
+func (t *encodeTransformer) encode_elements(value reflect.Value) error {
+	err := t.encode_zero(value.Type().Elem())
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
-	buf.Write(zero)
+	packed := t.element_packed(value.Type().Elem())
+	n := value.Len()
 	for i := 0; i < n; i++ {
-		elEntry := fmt.Sprintf("el%d", i)
-		t.stack.Push(elEntry)
-		el := value.Index(i)
-		encoded, err := t.encode(el)
+		t.stack.Index(i)
+		err := t.encode_element(value.Index(i), packed)
 		if err != nil {
-			return []byte{}, err
+			return err
 		}
 		t.stack.Pop()
-		buf.Write(encoded)
 	}
-	t.stack.Pop()
-	return t.format_encode(SLICE, buf.Bytes())
+	return nil
 }
 
 // PAYLOAD: BINARY ENCODED STRING AS BYTE ARRAY
-func (t *encodeTransformer) encode_string(s string) ([]byte, error) {
+func (t *encodeTransformer) encode_string(s string) error {
 	t.stack.Push("string")
-	buf := bytes.NewBuffer([]byte{})
-	for _, c := range s {
-		buf.WriteRune(c)
+	err := t.format_header(STRING, uint64(len(s)))
+	if err != nil {
+		return err
+	}
+	if t.measuring {
+		t.count += uint64(len(s))
+	} else if _, err := t.w.WriteString(s); err != nil {
+		return err
 	}
 	t.stack.Pop()
-	return t.format_encode(STRING, buf.Bytes())
+	return nil
 }
 
 // PAYLOAD: BINARY ENCODED BOOL
-func (t *encodeTransformer) encode_bool(b bool) ([]byte, error) {
-	t.stack.Push("bool")
-	buf := bytes.NewBuffer([]byte{})
-	err := binary.Write(buf, BYTE_ORDER, b)
-	if err != nil {
-		return []byte{}, err
-	}
-	t.stack.Pop()
-	return t.format_encode(BOOL, buf.Bytes())
+func (t *encodeTransformer) encode_bool(b bool) error {
+	return t.encode_fixed("bool", BOOL, b)
 }
 
 // PAYLOAD: BINARY ENCODED INT64
-func (t *encodeTransformer) encode_int(i int) ([]byte, error) {
-	t.stack.Push("int")
-	buf := bytes.NewBuffer([]byte{})
-	err := binary.Write(buf, BYTE_ORDER, int64(i))
-	if err != nil {
-		return []byte{}, err
-	}
-	t.stack.Pop()
-	return t.format_encode(INT, buf.Bytes())
+func (t *encodeTransformer) encode_int(i int) error {
+	return t.encode_fixed("int", INT, int64(i))
+}
+
+func (t *encodeTransformer) encode_int64(i int64) error {
+	return t.encode_fixed("int64", INT64, i)
+}
+
+func (t *encodeTransformer) encode_uint(i uint) error {
+	return t.encode_fixed("uint", UINT, uint64(i))
+}
+
+func (t *encodeTransformer) encode_uint64(i uint64) error {
+	return t.encode_fixed("uint64", UINT64, i)
+}
+
+func (t *encodeTransformer) encode_uint8(i uint8) error {
+	return t.encode_fixed("uint8", UINT8, i)
 }
 
-func (t *encodeTransformer) encode_int64(i int64) ([]byte, error) {
-	t.stack.Push("int64")
-	buf := bytes.NewBuffer([]byte{})
-	err := binary.Write(buf, BYTE_ORDER, i)
+// PAYLOAD: BINARY ENCODED FLOAT64
+func (t *encodeTransformer) encode_float64(f float64) error {
+	return t.encode_fixed("float64", FLOAT64, f)
+}
+
+// PAYLOAD: BINARY ENCODED FIXED WIDTH VALUE (complex numbers as real, imag)
+func (t *encodeTransformer) encode_fixed(name string, objectType EncodedType, data any) error {
+	t.stack.Push(name)
+	if t.opts.compact {
+		var err error
+		t.scratch, err = appendCompact(t.scratch[:0], data)
+		if err != nil {
+			return err
+		}
+		err = t.format_encode(objectType, t.scratch)
+		if err != nil {
+			return err
+		}
+		t.stack.Pop()
+		return nil
+	}
+	size := binary.Size(data)
+	if size < 0 {
+		return fmt.Errorf("%s is not a fixed width value", name)
+	}
+	if t.measuring {
+		err := t.format_header(objectType, uint64(size))
+		if err != nil {
+			return err
+		}
+		t.count += uint64(size)
+		t.stack.Pop()
+		return nil
+	}
+	var err error
+	t.scratch, err = appendFixed(t.scratch[:0], data)
+	if err != nil {
+		return err
+	}
+	err = t.format_encode(objectType, t.scratch)
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
 	t.stack.Pop()
-	return t.format_encode(INT64, buf.Bytes())
+	return nil
 }
 
-func (t *encodeTransformer) encode_uint(i uint) ([]byte, error) {
-	t.stack.Push("uint")
-	buf := bytes.NewBuffer([]byte{})
-	err := binary.Write(buf, BYTE_ORDER, uint64(i))
-	if err != nil {
-		return []byte{}, err
+// appendFixed appends the big endian encoding of a fixed width value to buf
+func appendFixed(buf []byte, data any) ([]byte, error) {
+	switch d := data.(type) {
+	case bool:
+		if d {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case int8:
+		return append(buf, byte(d)), nil
+	case uint8:
+		return append(buf, d), nil
+	case int16:
+		return BYTE_ORDER.AppendUint16(buf, uint16(d)), nil
+	case uint16:
+		return BYTE_ORDER.AppendUint16(buf, d), nil
+	case int32:
+		return BYTE_ORDER.AppendUint32(buf, uint32(d)), nil
+	case uint32:
+		return BYTE_ORDER.AppendUint32(buf, d), nil
+	case int64:
+		return BYTE_ORDER.AppendUint64(buf, uint64(d)), nil
+	case uint64:
+		return BYTE_ORDER.AppendUint64(buf, d), nil
+	case float32:
+		return BYTE_ORDER.AppendUint32(buf, math.Float32bits(d)), nil
+	case float64:
+		return BYTE_ORDER.AppendUint64(buf, math.Float64bits(d)), nil
+	case complex64:
+		buf = BYTE_ORDER.AppendUint32(buf, math.Float32bits(real(d)))
+		return BYTE_ORDER.AppendUint32(buf, math.Float32bits(imag(d))), nil
+	case complex128:
+		buf = BYTE_ORDER.AppendUint64(buf, math.Float64bits(real(d)))
+		return BYTE_ORDER.AppendUint64(buf, math.Float64bits(imag(d))), nil
 	}
-	t.stack.Pop()
-	return t.format_encode(UINT, buf.Bytes())
+	out := bytes.NewBuffer(buf)
+	err := binary.Write(out, BYTE_ORDER, data)
+	return out.Bytes(), err
 }
 
-func (t *encodeTransformer) encode_uint64(i uint64) ([]byte, error) {
-	t.stack.Push("uint64")
-	buf := bytes.NewBuffer([]byte{})
-	err := binary.Write(buf, BYTE_ORDER, i)
+// format_encode writes a complete value whose payload is already known
+func (t *encodeTransformer) format_encode(objectType EncodedType, payload []byte) error {
+	err := t.format_header(objectType, uint64(len(payload)))
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
-	t.stack.Pop()
-	return t.format_encode(UINT64, buf.Bytes())
+	return t.write(payload)
 }
 
-func (t *encodeTransformer) encode_uint8(i uint8) ([]byte, error) {
-	t.stack.Push("uint8")
-	buf := bytes.NewBuffer([]byte{})
-	err := binary.Write(buf, BYTE_ORDER, i)
+// format_container writes a value whose payload is produced by body. The body
+// is first run in measuring mode to find the payload length for the header,
+// and then run again to stream the payload itself. In measuring mode the body
+// is only run once, with the payload length taken from what it counted.
+//
+// Containers can nest as deeply as the value is large, so the payload lengths
+// found while measuring are kept, keyed by the order in which containers are
+// reached, and are used instead of measuring each nested container again.
+func (t *encodeTransformer) format_container(objectType EncodedType, body func() error) error {
+	seq := t.seq
+	t.seq++
+	if t.measuring {
+		start := t.count
+		err := body()
+		if err != nil {
+			return err
+		}
+		payloadLen := t.count - start
+		t.sizes[seq] = payloadLen
+		t.count = start
+		err = t.format_header(objectType, payloadLen)
+		if err != nil {
+			return err
+		}
+		t.count += payloadLen
+		return nil
+	}
+	payloadLen, known := t.sizes[seq]
+	if known {
+		delete(t.sizes, seq)
+	} else {
+		var err error
+		payloadLen, err = t.measure(body)
+		if err != nil {
+			return err
+		}
+	}
+	err := t.format_header(objectType, payloadLen)
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
-	t.stack.Pop()
-	return t.format_encode(UINT8, buf.Bytes())
+	return body()
 }
 
-// PAYLOAD: BINARY ENCODED FLOAT64
-func (t *encodeTransformer) encode_float64(f float64) ([]byte, error) {
-	t.stack.Push("float64")
-	buf := bytes.NewBuffer([]byte{})
-	err := binary.Write(buf, BYTE_ORDER, f)
-	if err != nil {
-		return []byte{}, err
+// measure runs body in measuring mode, returning the length of its payload.
+// The references and container numbers allocated by body are released, as
+// they are allocated again when body is run to write the payload.
+func (t *encodeTransformer) measure(body func() error) (uint64, error) {
+	measuring, count, seq, refCount := t.measuring, t.count, t.seq, len(t.refOrder)
+	t.measuring, t.count = true, 0
+	err := body()
+	payloadLen := t.count
+	for _, key := range t.refOrder[refCount:] {
+		delete(t.refs, key)
 	}
-	t.stack.Pop()
-	return t.format_encode(FLOAT64, buf.Bytes())
+	t.refOrder = t.refOrder[:refCount]
+	t.measuring, t.count, t.seq = measuring, count, seq
+	return payloadLen, err
 }
 
-func (t *encodeTransformer) format_encode(objectType EncodedType, payload []byte) ([]byte, error) {
-	buf := bytes.NewBuffer([]byte{})
-	payloadLen := uint64(len(payload))
-	if payloadLen+1 > MAX_PAYLOAD_LEN {
-		return []byte{}, fmt.Errorf("payload too big")
-	}
-	lenLen := (bits.Len64(payloadLen) / 8) + 1
-	if lenLen > 3 {
-		return []byte{}, fmt.Errorf("payload len does not fit in control byte")
-	}
-	controlByte := (byte(objectType) << 3) | byte(lenLen)
-	buf.WriteByte(controlByte)
-	tmpBuf := bytes.NewBuffer([]byte{})
-	payloadLenShifted := uint64(payloadLen << (64 - (lenLen * 8)))
-	binary.Write(tmpBuf, BYTE_ORDER, payloadLenShifted)
-	for i := 0; i < lenLen; i++ {
-		buf.WriteByte(tmpBuf.Bytes()[i])
-	}
-	buf.Write(payload)
-	return buf.Bytes(), nil
+func (t *encodeTransformer) format_header(objectType EncodedType, payloadLen uint64) error {
+	if t.opts.compact {
+		if payloadLen < 7 {
+			t.header = append(t.header[:0], byte(objectType)<<3|byte(payloadLen))
+		} else {
+			t.header = binary.AppendUvarint(append(t.header[:0], byte(objectType)<<3|7), payloadLen)
+		}
+		return t.write(t.header)
+	}
+	if payloadLen > MAX_PAYLOAD_LEN {
+		return fmt.Errorf("payload too big")
+	}
+	t.header = appendHeader(t.header[:0], objectType, payloadLen)
+	return t.write(t.header)
 }
 
-func (t *encodeTransformer) encode_zero(zt reflect.Type) ([]byte, error) {
-	zero := reflect.Zero(zt)
-	return t.encode(zero)
+func (t *encodeTransformer) write(p []byte) error {
+	if t.measuring {
+		t.count += uint64(len(p))
+		return nil
+	}
+	_, err := t.w.Write(p)
+	return err
 }
 
-func illegal_type(t reflect.Type) ([]byte, error) {
-	return []byte{}, fmt.Errorf("illegal type %s", t.Kind())
+// encode_zero writes the zero value of zt, or its descriptor in the compact
+// format, which describes the type of a container's contents to decoders
+// without a target type. Zero values of
+// recursive types would never terminate, so within its own zero value a type
+// is written as nil.
+func (t *encodeTransformer) encode_zero(zt reflect.Type) error {
+	if t.zeroing[zt] && t.opts.compact {
+		return t.write([]byte{byte(INVALID)})
+	} else if t.zeroing[zt] {
+		return t.encode_nil(reflect.Value{})
+	}
+	t.zeroing[zt] = true
+	defer delete(t.zeroing, zt)
+	if t.opts.compact {
+		return t.encode_descriptor(zt)
+	}
+	zeroValue := t.zeroValue
+	t.zeroValue = true
+	defer func() { t.zeroValue = zeroValue }()
+	return t.encode(reflect.Zero(zt))
 }
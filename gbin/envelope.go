@@ -0,0 +1,258 @@
+package gbin
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"reflect"
+)
+
+// Values encoded WithEnvelope are wrapped in an envelope, which records the
+// length and checksum of their encoding so that truncated and corrupt input
+// is rejected before it is decoded, and which may compress the encoding.
+//
+// ENVELOPE: MAGIC, VERSION, COMPRESSION ID, UINT64 PAYLOAD LENGTH, PAYLOAD, UINT32 CRC
+//
+// The magic begins with the control byte of an ENVELOPE value, so decoders
+// tell envelopes from bare values by their first byte. The checksum is the
+// CRC-32 (Castagnoli) of everything between the magic and itself, and the
+// payload is the encoding of the value, compressed unless the compression ID
+// is 0.
+
+const ENVELOPE_VERSION = 1
+
+var envelopeMagic = [4]byte{byte(ENVELOPE) << 3, 'g', 'b', 'n'}
+
+// envelopeHeaderLen is the length of an envelope up to its payload
+const envelopeHeaderLen = len(envelopeMagic) + 10
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt is returned, wrapped in an *Error, when an envelope is truncated
+// or the checksum of an envelope or Log record does not match its contents
+var ErrCorrupt = errors.New("corrupt data")
+
+// Compression compresses the payloads of envelopes. Decoders read payloads
+// compressed by Gzip and Flate without options; other compressions must be
+// passed to decoders WithCompression.
+type Compression struct {
+	// ID identifies the compression in envelopes. IDs up to 15 are reserved
+	// for compressions provided by gbin.
+	ID byte
+	// Name describes the compression in dumps and errors
+	Name      string
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// Gzip compresses payloads with compress/gzip
+var Gzip = Compression{
+	ID:   1,
+	Name: "gzip",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr, nil
+	},
+}
+
+// Flate compresses payloads with compress/flate, which is smaller than Gzip
+// by the gzip header and trailer
+var Flate = Compression{
+	ID:   2,
+	Name: "flate",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.DefaultCompression)
+	},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return flate.NewReader(r), nil
+	},
+}
+
+var builtinCompressions = map[byte]*Compression{
+	Gzip.ID:  &Gzip,
+	Flate.ID: &Flate,
+}
+
+// compression returns the compression with the given ID
+func (t *decodeTransformer) compression(id byte) (*Compression, error) {
+	if c, ok := t.opts.compressions[id]; ok {
+		return c, nil
+	} else if c, ok := builtinCompressions[id]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown compression %d, which must be passed to the decoder WithCompression", id)
+}
+
+// encodeEnvelope writes value to w in an envelope. The encoding of the value
+// is held in memory to be measured and checksummed.
+func encodeEnvelope(w *bufio.Writer, value reflect.Value, opts *options) error {
+	bare := *opts
+	bare.envelope = false
+	payload := bytes.NewBuffer([]byte{})
+	var dst io.WriteCloser = nopCloser{payload}
+	id := byte(0)
+	if opts.compression != nil {
+		var err error
+		dst, err = opts.compression.NewWriter(payload)
+		if err != nil {
+			return writeError(err)
+		}
+		id = opts.compression.ID
+	}
+	bw := bufio.NewWriter(dst)
+	err := encodeValue(bw, value, &bare)
+	if err != nil {
+		return err
+	}
+	err = bw.Flush()
+	if err == nil {
+		err = dst.Close()
+	}
+	if err != nil {
+		return writeError(err)
+	}
+	header := make([]byte, 0, envelopeHeaderLen)
+	header = append(header, envelopeMagic[:]...)
+	header = append(header, ENVELOPE_VERSION, id)
+	header = BYTE_ORDER.AppendUint64(header, uint64(payload.Len()))
+	sum := crc32.Checksum(header[len(envelopeMagic):], castagnoli)
+	sum = crc32.Update(sum, castagnoli, payload.Bytes())
+	for _, part := range [][]byte{header, payload.Bytes(), BYTE_ORDER.AppendUint32(nil, sum)} {
+		_, err = w.Write(part)
+		if err != nil {
+			return writeError(err)
+		}
+	}
+	return nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// envelope is an envelope read from input
+type envelope struct {
+	// offset is the offset of the payload in the input
+	offset uint64
+	// stored is the length of the payload in the input
+	stored      uint64
+	compression *Compression
+	// data is the decompressed payload, which payload reads
+	data    []byte
+	payload *decodeTransformer
+}
+
+// open_envelope reads the envelope which begins the input, if there is one,
+// checking its checksum and decompressing its payload. It returns nil if the
+// input is not in an envelope.
+func (t *decodeTransformer) open_envelope() (*envelope, error) {
+	objectType, err := t.peek_type()
+	if err != nil || objectType != ENVELOPE {
+		// a missing value is reported by whatever reads it
+		return nil, nil
+	}
+	t.stack.Push("envelope")
+	header, err := t.read_enveloped(uint64(envelopeHeaderLen))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(envelopeMagic)], envelopeMagic[:]) {
+		return nil, fmt.Errorf("malformed envelope magic % x", header[:len(envelopeMagic)])
+	}
+	fields := header[len(envelopeMagic):]
+	if fields[0] != ENVELOPE_VERSION {
+		return nil, fmt.Errorf("unsupported envelope version %d", fields[0])
+	}
+	env := &envelope{offset: t.offset, stored: BYTE_ORDER.Uint64(fields[2:])}
+	if fields[1] != 0 {
+		env.compression, err = t.compression(fields[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	payload, err := t.read_enveloped(env.stored)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := t.read_enveloped(4)
+	if err != nil {
+		return nil, err
+	}
+	sum := crc32.Update(crc32.Checksum(fields, castagnoli), castagnoli, payload)
+	if expected := BYTE_ORDER.Uint32(stored); sum != expected {
+		return nil, fmt.Errorf("%w: checksum 0x%08x does not match the contents, which sum to 0x%08x", ErrCorrupt, expected, sum)
+	}
+	if env.compression != nil {
+		payload, err = t.decompress(env.compression, payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	env.data = payload
+	env.payload = newDecodeTransformer(bufio.NewReader(bytes.NewReader(payload)), t.stack, t.opts)
+	env.payload.sized, env.payload.size = true, uint64(len(payload))
+	env.payload.depth = t.depth
+	t.stack.Pop()
+	return env, nil
+}
+
+// read_enveloped reads the next n bytes of an envelope, reporting input which
+// ends before them as truncated
+func (t *decodeTransformer) read_enveloped(n uint64) ([]byte, error) {
+	if t.sized && n > t.size-t.offset {
+		return nil, fmt.Errorf("%w: %w, needing %d bytes where %d remain", ErrCorrupt, ErrTruncated, n, t.size-t.offset)
+	}
+	buf, err := t.readN(n)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: %w, ending within %d bytes", ErrCorrupt, ErrTruncated, n)
+	}
+	return buf, err
+}
+
+// decompress decompresses the payload of an envelope, which may not exceed
+// the maximum size of input set WithMaxSize once decompressed, or
+// DEFAULT_MAX_DECOMPRESSED_SIZE if none is set, as a small payload can
+// decompress to an arbitrarily large one
+func (t *decodeTransformer) decompress(c *Compression, payload []byte) ([]byte, error) {
+	r, err := c.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s payload: %w", c.Name, err)
+	}
+	defer r.Close()
+	maxSize := t.opts.maxSize
+	if maxSize == 0 {
+		maxSize = DEFAULT_MAX_DECOMPRESSED_SIZE
+	}
+	buf := bytes.NewBuffer([]byte{})
+	_, err = io.Copy(buf, io.LimitReader(r, int64(min(maxSize, 1<<62))+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s payload: %w", c.Name, err)
+	}
+	if uint64(buf.Len()) > maxSize {
+		return nil, limitError("decompressed payload is longer than %d bytes", maxSize)
+	}
+	return buf.Bytes(), nil
+}
+
+// end checks that the value in the envelope filled its payload
+func (e *envelope) end() error {
+	if e.payload.offset != e.payload.size {
+		return fmt.Errorf("enveloped value is followed by %d bytes", e.payload.size-e.payload.offset)
+	}
+	return nil
+}
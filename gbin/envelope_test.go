@@ -0,0 +1,197 @@
+package gbin_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	data := benchRecords()
+	plain, err := gbin.NewEncoder[[]benchRecord]().Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := [][]gbin.Option{
+		{gbin.WithEnvelope()},
+		{gbin.WithEnvelope(), gbin.WithCompact(), gbin.WithSchema()},
+		{gbin.WithCompression(gbin.Gzip)},
+		{gbin.WithCompression(gbin.Flate), gbin.WithCompact(), gbin.WithSchema()},
+	}
+	for i, opts := range cases {
+		if !runTest(data, opts...) {
+			t.Fatalf("case %d failed", i)
+		}
+		encoded, err := gbin.NewEncoder[[]benchRecord](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gbin.DecodeDynamic(encoded); err != nil {
+			t.Fatal(err)
+		}
+		if err := gbin.Dump(io.Discard, encoded); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 1 {
+			if _, err := gbin.ReadSchema(encoded); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i >= 2 && len(encoded) >= len(plain) {
+			t.Fatalf("case %d: expected compression to shrink %d bytes, got %d", i, len(plain), len(encoded))
+		}
+	}
+}
+
+func TestEnvelopeStream(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	sw := gbin.NewEncoder[benchRecord](gbin.WithCompression(gbin.Gzip)).NewStreamWriter(buf)
+	data := benchRecords()[:3]
+	for i := range data {
+		if err := sw.Write(&data[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sr := gbin.NewDecoder[benchRecord]().NewStreamReader(buf)
+	for i := range data {
+		decoded, err := sr.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Name != data[i].Name {
+			t.Fatalf("expected %q, got %q", data[i].Name, decoded.Name)
+		}
+	}
+	if _, err := sr.Read(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestEnvelopeCorrupt(t *testing.T) {
+	data := benchRecords()[:2]
+	for _, opts := range [][]gbin.Option{{gbin.WithEnvelope()}, {gbin.WithCompression(gbin.Flate)}} {
+		encoded, err := gbin.NewEncoder[[]benchRecord](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := gbin.NewDecoder[[]benchRecord]()
+		for n := 1; n < len(encoded); n++ {
+			_, err = decoder.Decode(encoded[:n])
+			if !errors.Is(err, gbin.ErrCorrupt) {
+				t.Fatalf("expected truncation to %d bytes to be detected, got %v", n, err)
+			}
+			_, err = decoder.NewStreamReader(bytes.NewReader(encoded[:n])).Read()
+			if !errors.Is(err, gbin.ErrCorrupt) {
+				t.Fatalf("expected truncation of a stream to %d bytes to be detected, got %v", n, err)
+			}
+		}
+		// everything after the version and compression ID is checksummed
+		for i := 6; i < len(encoded); i++ {
+			corrupt := bytes.Clone(encoded)
+			corrupt[i] ^= 0x10
+			_, err = decoder.Decode(corrupt)
+			if !errors.Is(err, gbin.ErrCorrupt) {
+				t.Fatalf("expected corruption of byte %d to be detected, got %v", i, err)
+			}
+		}
+	}
+}
+
+// xorCompression stands in for a third party compression
+var xorCompression = gbin.Compression{
+	ID:   16,
+	Name: "xor",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return &xorWriter{w}, nil
+	},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(&xorReader{r}), nil
+	},
+}
+
+type xorWriter struct {
+	w io.Writer
+}
+
+func (x *xorWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	for i := range p {
+		buf[i] = p[i] ^ 0xAA
+	}
+	return x.w.Write(buf)
+}
+
+func (x *xorWriter) Close() error {
+	return nil
+}
+
+type xorReader struct {
+	r io.Reader
+}
+
+func (x *xorReader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0xAA
+	}
+	return n, err
+}
+
+func TestCustomCompression(t *testing.T) {
+	data := benchRecords()[:2]
+	encoded, err := gbin.NewEncoder[[]benchRecord](gbin.WithCompression(xorCompression)).Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gbin.NewDecoder[[]benchRecord]().Decode(encoded)
+	if err == nil || !strings.Contains(err.Error(), "unknown compression 16") {
+		t.Fatalf("expected an unknown compression error, got %v", err)
+	}
+	decoded, err := gbin.NewDecoder[[]benchRecord](gbin.WithCompression(xorCompression)).Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*decoded)[1].Name != data[1].Name {
+		t.Fatalf("expected %q, got %q", data[1].Name, (*decoded)[1].Name)
+	}
+}
+
+func TestEnvelopeMaxSize(t *testing.T) {
+	data := make([]byte, 1<<16)
+	encoded, err := gbin.NewEncoder[[]byte](gbin.WithCompression(gbin.Flate)).Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded) > 1<<10 {
+		t.Fatalf("expected zeros to compress, got %d bytes", len(encoded))
+	}
+	_, err = gbin.NewDecoder[[]byte](gbin.WithMaxSize(1 << 12)).Decode(encoded)
+	if !errors.Is(err, gbin.ErrLimitExceeded) {
+		t.Fatalf("expected the size limit to be exceeded, got %v", err)
+	}
+	// decompression is limited even without the option
+	zeros := strings.Repeat("\x00", gbin.DEFAULT_MAX_DECOMPRESSED_SIZE)
+	bomb, err := gbin.NewEncoder[string](gbin.WithCompression(gbin.Flate)).Encode(&zeros)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gbin.NewDecoder[string]().Decode(bomb)
+	if !errors.Is(err, gbin.ErrLimitExceeded) {
+		t.Fatalf("expected the default decompression limit to be exceeded, got %v", err)
+	}
+	decoded, err := gbin.NewDecoder[string](gbin.WithMaxSize(2 * gbin.DEFAULT_MAX_DECOMPRESSED_SIZE)).Decode(bomb)
+	if err != nil || len(*decoded) != len(zeros) {
+		t.Fatalf("expected WithMaxSize to raise the limit, got %v", err)
+	}
+
+	// an envelope of a different version is rejected rather than guessed at
+	encoded[4] = 2
+	_, err = gbin.NewDecoder[[]byte]().Decode(encoded)
+	if err == nil || !strings.Contains(err.Error(), "unsupported envelope version 2") {
+		t.Fatalf("expected an unsupported version error, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package gbin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrLimitExceeded is returned, wrapped in an *Error, when decoding input
+// would exceed one of the limits set by WithMaxDepth, WithMaxSize or
+// WithMaxLength
+var ErrLimitExceeded = errors.New("decoding limit exceeded")
+
+// ErrTruncated is returned, wrapped in an *Error, when input ends part way
+// through a value
+var ErrTruncated = errors.New("input is truncated")
+
+// ErrTypeMismatch is returned, wrapped in an *Error, when an encoded value
+// cannot be decoded into the type of its target
+var ErrTypeMismatch = errors.New("type mismatch")
+
+// ErrUnsupportedType is returned, wrapped in an *Error, when a value of a
+// type gbin cannot represent, such as a channel or function, is encoded or
+// decoded into
+var ErrUnsupportedType = errors.New("unsupported type")
+
+// Error is the error returned when encoding or decoding fails. It records
+// where the failure occurred, and wraps the cause so that errors.Is and
+// errors.As can be used on it.
+type Error struct {
+	// Offset is the number of bytes of input consumed when decoding failed,
+	// counted from the start of the payload of an envelope, or from the first
+	// record of a Log. It is 0 for failures to encode.
+	Offset uint64
+	// Path leads from the value encoded or decoded to the value which failed
+	Path []PathElem
+	// Expected and Actual are set when the cause is ErrTypeMismatch, to the
+	// type or kind of the target and the kind of the encoded value
+	Expected string
+	Actual   string
+	// Err is the cause of the failure
+	Err      error
+	encoding bool
+	trace    string
+}
+
+func (e *Error) Error() string {
+	if e.encoding {
+		return fmt.Sprintf("go-libs/gbin/encode: %s at %s", e.Err.Error(), e.trace)
+	}
+	return fmt.Sprintf("go-libs/gbin/decode: %s at %s (offset %d)", e.Err.Error(), e.trace, e.Offset)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// decodeError wraps an error from decoding with the position of tf
+func decodeError(tf *decodeTransformer, err error) error {
+	if err == nil {
+		return nil
+	}
+	return newError(tf.stack, err, tf.offset, false)
+}
+
+// encodeError wraps an error from encoding with the position of tf
+func encodeError(tf *encodeTransformer, err error) error {
+	if err == nil {
+		return nil
+	}
+	return newError(&tf.stack, err, 0, true)
+}
+
+// readError wraps an error from the underlying reader, after offset bytes of
+// input, keeping it as the cause
+func readError(err error, offset uint64) error {
+	if err == nil {
+		return nil
+	}
+	return newError(newTrace(), err, offset, false)
+}
+
+// writeError wraps an error from the underlying writer, keeping it as the
+// cause
+func writeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return newError(newTrace(), err, 0, true)
+}
+
+// ioError wraps an error from an operation on an underlying stream or file
+// which is neither encoding nor decoding, keeping it as the cause
+func ioError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("go-libs/gbin: %s: %w", op, err)
+}
+
+// newError wraps err with the position given by tr. An *Error returned by
+// encoding or decoding nested in the value, as custom encodings may do, has
+// its position joined to tr.
+func newError(tr *trace, err error, offset uint64, encoding bool) *Error {
+	e := &Error{Offset: offset, Path: tr.Path(), Err: err, encoding: encoding, trace: tr.String()}
+	if inner, ok := err.(*Error); ok {
+		e.Path = append(e.Path, inner.Path...)
+		e.Expected, e.Actual = inner.Expected, inner.Actual
+		e.Err = inner.Err
+		e.trace += inner.trace[1:]
+	}
+	var mismatch *mismatchError
+	if errors.As(e.Err, &mismatch) {
+		e.Expected, e.Actual = mismatch.expected, mismatch.actual
+	}
+	return e
+}
+
+// limitError reports that a decoding limit has been exceeded
+func limitError(format string, a ...any) error {
+	return fmt.Errorf("%w: %s", ErrLimitExceeded, fmt.Sprintf(format, a...))
+}
+
+// truncatedError reports that input ended part way through a value
+func truncatedError(format string, a ...any) error {
+	return fmt.Errorf("%w: %s", ErrTruncated, fmt.Sprintf(format, a...))
+}
+
+// errUnexpectedEOF reports that input ended while a value was being read
+var errUnexpectedEOF = fmt.Errorf("%w: %w", ErrTruncated, io.ErrUnexpectedEOF)
+
+// unsupportedError reports that a type cannot be encoded or decoded into
+func unsupportedError(format string, a ...any) error {
+	return fmt.Errorf("%w: %s", ErrUnsupportedType, fmt.Sprintf(format, a...))
+}
+
+// mismatchError reports that an encoded value of kind actual cannot be
+// decoded into a target of type or kind expected
+type mismatchError struct {
+	expected string
+	actual   string
+	msg      string
+}
+
+func mismatch(expected, actual any, format string, a ...any) error {
+	return &mismatchError{expected: fmt.Sprint(expected), actual: fmt.Sprint(actual), msg: fmt.Sprintf(format, a...)}
+}
+
+func (e *mismatchError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrTypeMismatch, e.msg)
+}
+
+func (e *mismatchError) Unwrap() error {
+	return ErrTypeMismatch
+}
+
+// recoverError returns a panic through err as an error, wrapped by wrap, so
+// that a failure in encoding or decoding, or in custom encodings, cannot
+// crash the caller. It must be deferred directly.
+func recoverError(err *error, wrap func(error) error) {
+	if r := recover(); r != nil {
+		*err = wrap(fmt.Errorf("panic: %v", r))
+	}
+}
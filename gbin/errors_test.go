@@ -0,0 +1,158 @@
+package gbin_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+type errorItem struct {
+	Name any
+}
+
+type errorRecord struct {
+	Items  []errorItem
+	Lookup map[string]errorItem
+}
+
+// asError returns err as a *gbin.Error, failing if it is not one
+func asError(t *testing.T, err error) *gbin.Error {
+	t.Helper()
+	var gerr *gbin.Error
+	if !errors.As(err, &gerr) {
+		t.Fatalf("expected a *gbin.Error, got %T: %v", err, err)
+	}
+	return gerr
+}
+
+func TestErrorPath(t *testing.T) {
+	type target struct {
+		Items  []struct{ Name string }
+		Lookup map[string]struct{ Name string }
+	}
+	cases := []struct {
+		data errorRecord
+		path []gbin.PathElem
+	}{
+		{
+			errorRecord{Items: []errorItem{{"a"}, {1}}},
+			[]gbin.PathElem{{Kind: gbin.PathField, Field: "Items"}, {Kind: gbin.PathIndex, Index: 1}, {Kind: gbin.PathField, Field: "Name"}},
+		},
+		{
+			errorRecord{Lookup: map[string]errorItem{"k": {true}}},
+			[]gbin.PathElem{{Kind: gbin.PathField, Field: "Lookup"}, {Kind: gbin.PathKey, Key: "k"}, {Kind: gbin.PathField, Field: "Name"}},
+		},
+	}
+	for _, c := range cases {
+		for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}} {
+			encoded, err := gbin.NewEncoder[errorRecord](opts...).Encode(&c.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = gbin.NewDecoder[target]().Decode(encoded)
+			if !errors.Is(err, gbin.ErrTypeMismatch) {
+				t.Fatalf("expected a type mismatch, got %v", err)
+			}
+			gerr := asError(t, err)
+			if !reflect.DeepEqual(gerr.Path, c.path) {
+				t.Fatalf("expected path %s, got %s", gbin.FormatPath(c.path), gbin.FormatPath(gerr.Path))
+			}
+			if gerr.Expected != "string" || gerr.Actual == "" {
+				t.Fatalf("expected a string to be expected, got %q and %q", gerr.Expected, gerr.Actual)
+			}
+		}
+	}
+	if s := gbin.FormatPath(cases[1].path); s != `.Lookup["k"].Name` {
+		t.Fatalf("unexpected formatting %s", s)
+	}
+}
+
+func TestErrorSentinels(t *testing.T) {
+	data := errorRecord{Lookup: map[string]errorItem{"k": {make(chan int)}}}
+	_, err := gbin.NewEncoder[errorRecord]().Encode(&data)
+	if !errors.Is(err, gbin.ErrUnsupportedType) {
+		t.Fatalf("expected an unsupported type, got %v", err)
+	}
+	if path := gbin.FormatPath(asError(t, err).Path); path != `.Lookup["k"].Name` {
+		t.Fatalf("expected the path of the channel, got %s", path)
+	}
+
+	encoded, err := gbin.Marshal([]string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(encoded); n++ {
+		_, err = gbin.NewDecoder[[]string]().Decode(encoded[:n])
+		if !errors.Is(err, gbin.ErrTruncated) {
+			t.Fatalf("expected truncation to %d bytes to be detected, got %v", n, err)
+		}
+	}
+}
+
+type panicker struct{}
+
+func (panicker) MarshalGbin() ([]byte, error) {
+	panic("marshaler failed")
+}
+
+func (*panicker) UnmarshalGbin(data []byte) error {
+	panic("unmarshaler failed")
+}
+
+func TestErrorPanics(t *testing.T) {
+	data := struct{ Value panicker }{}
+	_, err := gbin.Marshal(data)
+	if gerr := asError(t, err); gbin.FormatPath(gerr.Path) != ".Value" {
+		t.Fatalf("expected the path of the panic, got %v", err)
+	}
+	encoded, err := gbin.Marshal([]int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gbin.NewDecoder[[]panicker]().Decode(encoded)
+	asError(t, err)
+}
+
+var errStream = errors.New("stream failed")
+
+// failingStream fails every read and write with errStream
+type failingStream struct{}
+
+func (failingStream) Read([]byte) (int, error)  { return 0, errStream }
+func (failingStream) Write([]byte) (int, error) { return 0, errStream }
+func (failingStream) Close() error              { return errStream }
+
+func TestErrorStreams(t *testing.T) {
+	data := []string{"a", "b"}
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := gbin.NewConn(failingStream{})
+	for name, err := range map[string]error{
+		"EncodeTo":     gbin.NewEncoder[[]string]().EncodeTo(failingStream{}, &data),
+		"StreamWriter": gbin.NewEncoder[[]string]().NewStreamWriter(failingStream{}).Write(&data),
+		"DecodeStream": func() error {
+			_, err := gbin.NewDecoder[[]string]().DecodeStream(io.MultiReader(bytes.NewReader(encoded[:3]), failingStream{}))
+			return err
+		}(),
+		"StreamReader": func() error {
+			_, err := gbin.NewDecoder[[]string]().NewStreamReader(failingStream{}).Read()
+			return err
+		}(),
+		"Send":    conn.Send(data),
+		"Receive": conn.Receive(nil),
+	} {
+		if !errors.Is(err, errStream) {
+			t.Fatalf("expected %s to fail with the error of the stream, got %v", name, err)
+		}
+		asError(t, err)
+	}
+	if err := conn.Close(); !errors.Is(err, errStream) {
+		t.Fatalf("expected Close to fail with the error of the stream, got %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+package gbin
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fieldInfo describes how a single struct field is encoded, as configured by
+// its gbin struct tag:
+//
+//	Field int `gbin:"-"`                        // never encoded
+//	Field int `gbin:"name"`                     // encoded under "name"
+//	Field int `gbin:",omitempty"`               // skipped when empty
+//	Field int `gbin:"name,default=5"`           // 5 when absent from the data
+//	Field int `gbin:"name,alias=Old,alias=Old2"` // also decoded from "Old" and "Old2"
+//
+// Defaults are only supported for fields of a scalar kind or a pointer to one,
+// and cannot be combined with omitempty, since an omitted zero value would be
+// decoded as the default. WithJSONTags makes the name, omitempty option and
+// "-" of a json tag apply to fields without a gbin tag.
+type fieldInfo struct {
+	// index leads to the field through the structs embedded in the one it is
+	// encoded in, as reflect.Value.FieldByIndex takes it, and indirect is set
+	// if one of them is embedded by pointer
+	index      []int
+	indirect   bool
+	typ        reflect.Type
+	name       string
+	named      bool
+	aliases    []string
+	omitEmpty  bool
+	defaultVal *reflect.Value
+}
+
+// embedding is a struct whose fields are promoted into the struct it is
+// embedded in
+type embedding struct {
+	typ      reflect.Type
+	index    []int
+	indirect bool
+}
+
+// structFields returns the encodable fields of struct type st in declaration
+// order. The exported fields of embedded structs, and of structs embedded by
+// pointer, are promoted into st unless the embedded field is named by a tag,
+// following the rules of encoding/json: of the fields encoded under the same
+// name, the least deeply embedded is used, then one named by a tag, and if
+// that leaves more than one, none are. Other embedded fields are encoded
+// under the names of their types, and unexported ones are ignored. If
+// jsonTags is set, json tags are read for fields without a gbin tag.
+func structFields(st reflect.Type, jsonTags bool) ([]fieldInfo, error) {
+	candidates := []fieldInfo{}
+	next := []embedding{{typ: st}}
+	count, nextCount := map[reflect.Type]int{}, map[reflect.Type]int{st: 1}
+	visited := map[reflect.Type]bool{}
+	for len(next) > 0 {
+		current := next
+		next = nil
+		count, nextCount = nextCount, map[reflect.Type]int{}
+		for _, outer := range current {
+			if visited[outer.typ] {
+				continue
+			}
+			visited[outer.typ] = true
+			for i := 0; i < outer.typ.NumField(); i++ {
+				field := outer.typ.Field(i)
+				ft := field.Type
+				if field.Anonymous && ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				promoted := field.Anonymous && ft.Kind() == reflect.Struct
+				if !field.IsExported() && !promoted {
+					continue
+				}
+				tag, tagged := field.Tag.Lookup("gbin")
+				jsonTag, jsonTagged := field.Tag.Lookup("json")
+				jsonTagged = jsonTags && jsonTagged && !tagged
+				if tagged && tag == "-" || jsonTagged && jsonTag == "-" {
+					continue
+				}
+				info := fieldInfo{
+					index:    append(append([]int{}, outer.index...), i),
+					indirect: outer.indirect,
+					typ:      field.Type,
+					name:     field.Name,
+				}
+				if tagged {
+					err := info.parseTag(field, tag)
+					if err != nil {
+						return nil, err
+					}
+				} else if jsonTagged {
+					info.parseJSONTag(jsonTag)
+				}
+				if promoted && !info.named {
+					nextCount[ft]++
+					if nextCount[ft] == 1 {
+						next = append(next, embedding{ft, info.index, outer.indirect || field.Type.Kind() == reflect.Pointer})
+					}
+					continue
+				} else if !field.IsExported() {
+					continue
+				}
+				candidates = append(candidates, info)
+				if count[outer.typ] > 1 {
+					// the fields of a struct embedded twice at the same depth
+					// conflict with each other
+					candidates = append(candidates, info)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.name != b.name {
+			return a.name < b.name
+		} else if len(a.index) != len(b.index) {
+			return len(a.index) < len(b.index)
+		}
+		return a.named && !b.named
+	})
+	fields := []fieldInfo{}
+	for i := 0; i < len(candidates); {
+		j := i + 1
+		for j < len(candidates) && candidates[j].name == candidates[i].name {
+			j++
+		}
+		if j-i > 1 && len(candidates[i+1].index) == 1 {
+			return nil, fmt.Errorf("struct %s has fields %s and %s both encoded as %s", st, st.Field(candidates[i].index[0]).Name, st.Field(candidates[i+1].index[0]).Name, candidates[i].name)
+		}
+		if j-i == 1 || len(candidates[i].index) < len(candidates[i+1].index) || candidates[i].named != candidates[i+1].named {
+			fields = append(fields, candidates[i])
+		}
+		i = j
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return slices.Compare(fields[i].index, fields[j].index) < 0
+	})
+
+	seen := map[string]string{}
+	for _, field := range fields {
+		goName := st.FieldByIndex(field.index).Name
+		for _, name := range append([]string{field.name}, field.aliases...) {
+			if other, found := seen[name]; found {
+				return nil, fmt.Errorf("struct %s has fields %s and %s both encoded as %s", st, other, goName, name)
+			}
+			seen[name] = goName
+		}
+	}
+	return fields, nil
+}
+
+// embeddedStructs returns the index of each struct embedded directly in st
+// whose fields are promoted, by the name which earlier versions of gbin
+// encoded it under
+func embeddedStructs(st reflect.Type) map[string]int {
+	embedded := map[string]int{}
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		ft := field.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if !field.Anonymous || !field.IsExported() || ft.Kind() != reflect.Struct {
+			continue
+		}
+		tag := field.Tag.Get("gbin")
+		if name, _, _ := strings.Cut(tag, ","); tag == "-" || name != "" {
+			continue
+		}
+		embedded[field.Name] = i
+	}
+	return embedded
+}
+
+// fieldOf returns the field at index within struct v, and false if it is
+// reached through an embedded pointer which is nil
+func fieldOf(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// settableField returns the field at index within struct v, allocating the
+// embedded pointers it is reached through
+func settableField(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+func (f *fieldInfo) parseTag(field reflect.StructField, tag string) error {
+	opts := strings.Split(tag, ",")
+	if opts[0] != "" {
+		f.name, f.named = opts[0], true
+	}
+	for _, opt := range opts[1:] {
+		key, val, _ := strings.Cut(opt, "=")
+		switch key {
+		case "omitempty":
+			f.omitEmpty = true
+		case "alias":
+			if val == "" {
+				return fmt.Errorf("field %s has an empty alias", field.Name)
+			}
+			f.aliases = append(f.aliases, val)
+		case "default":
+			def, err := parseDefault(field.Type, val)
+			if err != nil {
+				return fmt.Errorf("field %s has invalid default: %s", field.Name, err.Error())
+			}
+			f.defaultVal = def
+		default:
+			return fmt.Errorf("field %s has unknown gbin tag option %s", field.Name, key)
+		}
+	}
+	if f.omitEmpty && f.defaultVal != nil {
+		return fmt.Errorf("field %s cannot be both omitempty and have a default, as its zero value would be decoded as the default", field.Name)
+	}
+	return nil
+}
+
+// parseJSONTag configures f from a json tag. Options of encoding/json which
+// gbin has no use for, such as string, are ignored.
+func (f *fieldInfo) parseJSONTag(tag string) {
+	opts := strings.Split(tag, ",")
+	if opts[0] != "" {
+		f.name, f.named = opts[0], true
+	}
+	f.omitEmpty = slices.Contains(opts[1:], "omitempty")
+}
+
+// parseDefault parses a default given in a struct tag as a value of type ft,
+// or of the type it points to if ft is a pointer
+func parseDefault(ft reflect.Type, s string) (*reflect.Value, error) {
+	vt := ft
+	if vt.Kind() == reflect.Pointer {
+		vt = vt.Elem()
+	}
+	target := reflect.New(vt).Elem()
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, err
+		}
+		target.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 0, target.Type().Bits())
+		if err != nil {
+			return nil, err
+		}
+		target.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(s, 0, target.Type().Bits())
+		if err != nil {
+			return nil, err
+		}
+		target.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, target.Type().Bits())
+		if err != nil {
+			return nil, err
+		}
+		target.SetFloat(f)
+	case reflect.Complex64, reflect.Complex128:
+		c, err := strconv.ParseComplex(s, target.Type().Bits())
+		if err != nil {
+			return nil, err
+		}
+		target.SetComplex(c)
+	default:
+		return nil, fmt.Errorf("defaults are not supported for type %s", ft)
+	}
+	return &target, nil
+}
+
+// defaultValue returns the default of the field, which must have one. Fields
+// which are pointers get a new pointer each time, so that decoded values do
+// not share it.
+func (f *fieldInfo) defaultValue() reflect.Value {
+	if f.typ.Kind() != reflect.Pointer {
+		return *f.defaultVal
+	}
+	ptr := reflect.New(f.typ.Elem())
+	ptr.Elem().Set(*f.defaultVal)
+	return ptr
+}
+
+// isEmptyValue reports whether v is empty for the purposes of omitempty, using
+// the same definition as encoding/json
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return v.IsZero()
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
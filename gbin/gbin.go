@@ -1,78 +1,170 @@
 package gbin
 
 import (
+	"bufio"
 	"bytes"
-	"fmt"
 	"io"
 	"reflect"
-	"runtime"
+	"sync"
 
 	"github.com/lspaccatrosi16/go-libs/internal/pkgError"
-	"github.com/lspaccatrosi16/go-libs/structures/stack"
 )
 
 var wrapEncode = pkgError.WrapErrorFactory("gbin/encode")
 var wrapDecode = pkgError.WrapErrorFactory("gbin/decode")
 
-func addStack(err error, trace string) error {
-	if err == nil {
-		return nil
-	}
-	return fmt.Errorf("%s at %s", err.Error(), trace)
+// writers, readers and buffers pool the memory which values are encoded and
+// decoded through, so that it is shared by every Encoder and Decoder
+var (
+	writers = sync.Pool{New: func() any { return bufio.NewWriter(nil) }}
+	readers = sync.Pool{New: func() any { return bufio.NewReader(nil) }}
+	buffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+)
+
+// maxPooledBuffer is the capacity above which buffers are not returned to the
+// pool, so that one large value does not pin its memory for good
+const maxPooledBuffer = 1 << 16
+
+func getWriter(w io.Writer) *bufio.Writer {
+	bw := writers.Get().(*bufio.Writer)
+	bw.Reset(w)
+	return bw
 }
 
+func putWriter(bw *bufio.Writer) {
+	bw.Reset(nil)
+	writers.Put(bw)
+}
+
+func getReader(r io.Reader) *bufio.Reader {
+	br := readers.Get().(*bufio.Reader)
+	br.Reset(r)
+	return br
+}
+
+func putReader(br *bufio.Reader) {
+	br.Reset(nil)
+	readers.Put(br)
+}
+
+// Encoder encodes values of type T. The work of inspecting T is done once and
+// shared, so an Encoder should be reused, and is safe for concurrent use by
+// multiple goroutines.
 type Encoder[T any] struct {
+	opts *options
 }
 
-func NewEncoder[T any]() *Encoder[T] {
-	if runtime.GOARCH != "amd64" {
-		panic("only supports 64-bit architectures currently")
-	}
+func NewEncoder[T any](opts ...Option) *Encoder[T] {
 	if !reflect.ValueOf(*new(T)).IsValid() {
 		panic("type parameter must not be an interface{}")
 	}
 
-	return &Encoder[T]{}
+	return &Encoder[T]{opts: newOptions(opts)}
 }
 
 func (e *Encoder[T]) Encode(data *T) ([]byte, error) {
-	buf := bytes.NewBuffer([]byte{})
-	st, err := e.EncodeStream(data)
+	buf := buffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			buffers.Put(buf)
+		}
+	}()
+	err := e.EncodeTo(buf, data)
 	if err != nil {
 		return []byte{}, err
 	}
-	io.Copy(buf, st)
-	return buf.Bytes(), nil
+	return bytes.Clone(buf.Bytes()), nil
 }
 
+// EncodeStream encodes data into an in memory buffer which is returned as a
+// reader. Use EncodeTo to stream the encoding to a writer instead.
 func (e *Encoder[T]) EncodeStream(data *T) (io.Reader, error) {
-	panicked := true
-	tf := newEncodeTransformer()
-	defer func() {
-		if panicked {
-			fmt.Println("ENCODE TRACE:")
-			fmt.Println(tf.trace())
-		}
-	}()
-	value := reflect.ValueOf(*data)
-	encoded, err := tf.encode(value)
-	buf := bytes.NewBuffer(encoded)
-	panicked = false
-	return buf, wrapEncode(addStack(err, tf.trace()))
+	buf := bytes.NewBuffer([]byte{})
+	err := e.EncodeTo(buf, data)
+	return buf, err
 }
 
-type Decoder[T any] struct {
+// EncodeTo writes the encoding of data to w as the value is walked, without
+// holding the encoded payload in memory. The length of each container and the
+// output of codecs and marshaling methods are kept from measuring until they
+// are written, so memory still grows with the number of containers and the
+// size of custom encoded values in data.
+func (e *Encoder[T]) EncodeTo(w io.Writer, data *T) error {
+	bw := getWriter(w)
+	defer putWriter(bw)
+	err := e.encode(bw, data)
+	if err != nil {
+		return err
+	}
+	return writeError(bw.Flush())
+}
+
+func (e *Encoder[T]) encode(w *bufio.Writer, data *T) error {
+	return encodeValue(w, reflect.ValueOf(data).Elem(), e.opts)
+}
+
+// encodeValue writes value to w, preceded by its schema and wrapped in an
+// envelope if opts ask for them
+func encodeValue(w *bufio.Writer, value reflect.Value, opts *options) (err error) {
+	if opts.envelope {
+		return encodeEnvelope(w, value, opts)
+	}
+	tf := newEncodeTransformer(w, opts)
+	defer recoverError(&err, func(err error) error {
+		return encodeError(tf, err)
+	})
+	if opts.schema && value.IsValid() {
+		err = tf.encode_schema(value.Type())
+	}
+	if err == nil {
+		err = tf.begin()
+	}
+	if err == nil {
+		err = tf.encode(value)
+	}
+	return encodeError(tf, err)
 }
 
-func NewDecoder[T any]() *Decoder[T] {
-	if runtime.GOARCH != "amd64" {
-		panic("only supports 64-bit architectures currently")
+// StreamWriter encodes a sequence of values to an underlying writer. Every
+// encoded value is self delimiting, so the sequence can be read back one
+// value at a time with a StreamReader.
+type StreamWriter[T any] struct {
+	e *Encoder[T]
+	w *bufio.Writer
+}
+
+// NewStreamWriter creates a StreamWriter which writes values to w
+func (e *Encoder[T]) NewStreamWriter(w io.Writer) *StreamWriter[T] {
+	return &StreamWriter[T]{
+		e: e,
+		w: bufio.NewWriter(w),
 	}
+}
+
+// Write encodes data and writes it to the underlying writer before returning.
+// As with EncodeTo, memory grows with the number of containers in data, but
+// nothing is kept between values.
+func (s *StreamWriter[T]) Write(data *T) error {
+	err := s.e.encode(s.w, data)
+	if err != nil {
+		return err
+	}
+	return writeError(s.w.Flush())
+}
+
+// Decoder decodes values of type T. Like an Encoder, it should be reused and
+// is safe for concurrent use by multiple goroutines.
+type Decoder[T any] struct {
+	opts *options
+}
+
+func NewDecoder[T any](opts ...Option) *Decoder[T] {
 	if !reflect.ValueOf(*new(T)).IsValid() {
 		panic("type parameter must not be an interface{}")
 	}
 
-	return &Decoder[T]{}
+	return &Decoder[T]{opts: newOptions(opts)}
 }
 
 func (d *Decoder[T]) Decode(data []byte) (*T, error) {
@@ -80,27 +172,111 @@ func (d *Decoder[T]) Decode(data []byte) (*T, error) {
 	return d.DecodeStream(buf)
 }
 
+// DecodeStream decodes a single value, reading it incrementally from data. As
+// data is read through a buffer, bytes following the value may be consumed;
+// use a StreamReader to decode a sequence of values from one reader.
 func (d *Decoder[T]) DecodeStream(data io.Reader) (*T, error) {
-	panicked := true
-	buf := bytes.NewBuffer([]byte{})
-	io.Copy(buf, data)
-	emptyStack := stack.NewStack[string]()
-	tf := newDecodeTransformer(*buf, emptyStack)
-	defer func() {
-		if panicked {
-			fmt.Println("DECODE TRACE:")
-			fmt.Println(tf.trace())
-		}
-	}()
-	val, err := tf.decode()
+	size := -1
+	if sized, ok := data.(interface{ Len() int }); ok {
+		size = sized.Len()
+	}
+	br := getReader(data)
+	defer putReader(br)
+	decoded := new(T)
+	err := d.decode(br, size, decoded, false)
+	if err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// DecodeInto decodes a single value into dst, reusing the memory already held
+// by it. The backing arrays of slices are decoded into when they have enough
+// capacity and maps are cleared and refilled, so repeatedly decoding into the
+// same value allocates far less than Decode. Values pointed to by dst are not
+// reused, as they may be shared.
+//
+// Anything sharing memory with the slices and maps in dst sees it overwritten,
+// and dst is left partially decoded if an error is returned.
+func (d *Decoder[T]) DecodeInto(data []byte, dst *T) error {
+	br := getReader(bytes.NewReader(data))
+	defer putReader(br)
+	return d.decode(br, len(data), dst, true)
+}
+
+// decode decodes a value from data into dst, which must hold the zero value
+// of T unless reuse is set. The length of the input is used to reject corrupt
+// payload lengths if it is known, and is otherwise given as -1.
+func (d *Decoder[T]) decode(data *bufio.Reader, size int, dst *T, reuse bool) error {
+	return decodeValue(data, size, reflect.ValueOf(dst).Elem(), d.opts, reuse)
+}
+
+// decodeValue decodes a value from data into target, opening the envelope it
+// may be in, as Decoder.decode does
+func decodeValue(data *bufio.Reader, size int, target reflect.Value, opts *options, reuse bool) (err error) {
+	tf := newDecodeTransformer(data, newTrace(), opts)
+	defer recoverError(&err, func(err error) error {
+		return decodeError(tf, err)
+	})
+	if size >= 0 {
+		tf.sized, tf.size = true, uint64(size)
+	}
+	env, err := tf.open_envelope()
 	if err != nil {
-		return nil, wrapDecode(addStack(err, tf.trace()))
+		return decodeError(tf, err)
+	} else if env != nil {
+		tf = env.payload
+	}
+	as := newAssigner(tf)
+	as.reuse = reuse
+	err = tf.begin()
+	if err == nil {
+		err = as.visit(target)
+	}
+	if err == nil && env != nil {
+		err = env.end()
+	}
+	return decodeError(tf, err)
+}
+
+// StreamReader decodes a sequence of values written by a StreamWriter
+type StreamReader[T any] struct {
+	d *Decoder[T]
+	r *bufio.Reader
+}
+
+// NewStreamReader creates a StreamReader which reads values from r
+func (d *Decoder[T]) NewStreamReader(r io.Reader) *StreamReader[T] {
+	return &StreamReader[T]{
+		d: d,
+		r: bufio.NewReader(r),
 	}
-	as := newAssigner[T]()
-	checked, err := as.assign(val)
+}
+
+// Read decodes the next value. It returns io.EOF once the underlying reader
+// is exhausted at a value boundary.
+func (s *StreamReader[T]) Read() (*T, error) {
+	decoded := new(T)
+	err := s.read(decoded, false)
 	if err != nil {
-		return nil, wrapDecode(addStack(err, tf.trace()))
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// ReadInto decodes the next value into dst, reusing its memory as DecodeInto
+// does. It returns io.EOF once the underlying reader is exhausted at a value
+// boundary.
+func (s *StreamReader[T]) ReadInto(dst *T) error {
+	return s.read(dst, true)
+}
+
+func (s *StreamReader[T]) read(dst *T, reuse bool) error {
+	_, err := s.r.Peek(1)
+	if err == io.EOF {
+		return io.EOF
+	} else if err != nil {
+		return readError(err, 0)
 	}
-	panicked = false
-	return checked, nil
+	return s.d.decode(s.r, -1, dst, reuse)
 }
@@ -1,8 +1,12 @@
 package gbin_test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/lspaccatrosi16/go-libs/gbin"
@@ -50,6 +54,112 @@ func TestUint8(t *testing.T) {
 	}
 }
 
+func TestInt8(t *testing.T) {
+	data := int8(-122)
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestInt16(t *testing.T) {
+	data := int16(-31000)
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestInt32(t *testing.T) {
+	data := int32(-2000000000)
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestUint16(t *testing.T) {
+	data := uint16(65000)
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestUint32(t *testing.T) {
+	data := uint32(4000000000)
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestUintptr(t *testing.T) {
+	data := uintptr(0xdeadbeef)
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestFloat32(t *testing.T) {
+	data := float32(3.14159)
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestComplex64(t *testing.T) {
+	data := complex64(complex(1.5, -2.25))
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestComplex128(t *testing.T) {
+	data := complex(1541523.21231, -0.000125)
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestArray(t *testing.T) {
+	data := [4]int32{1, -2, 3, -4}
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestNestedArray(t *testing.T) {
+	data := [2][3]string{{"a", "b", "c"}, {"d", "e", "f"}}
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestFixedWidthStruct(t *testing.T) {
+	type point struct {
+		X, Y float32
+	}
+	data := struct {
+		A int8
+		B int16
+		C int32
+		D uint16
+		E uint32
+		F uintptr
+		G float32
+		H complex64
+		I complex128
+		J [2]point
+		K []uint16
+		L map[int32][3]int16
+	}{
+		A: -1, B: -2, C: -3, D: 4, E: 5, F: 6, G: 7.5,
+		H: complex(8, 9), I: complex(10, 11),
+		J: [2]point{{1, 2}, {3, 4}},
+		K: []uint16{1, 2, 3},
+		L: map[int32][3]int16{1: {1, 2, 3}, -1: {-1, -2, -3}},
+	}
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
 func TestFloat(t *testing.T) {
 	data := 1541523.21231
 	if pass := runTest(data); !pass {
@@ -174,8 +284,314 @@ func TestNil(t *testing.T) {
 	}
 }
 
-func runTest[T any](data T) bool {
-	encoder := gbin.NewEncoder[T]()
+func TestEncodeTo(t *testing.T) {
+	data := map[string][]int{"a": {1, 2, 3}, "b": {}}
+	encoder := gbin.NewEncoder[map[string][]int]()
+	decoder := gbin.NewDecoder[map[string][]int]()
+	buf := bytes.NewBuffer([]byte{})
+	err := encoder.EncodeTo(buf, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := encoder.Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != len(encoded) {
+		t.Fatalf("EncodeTo wrote %d bytes but Encode produced %d", buf.Len(), len(encoded))
+	}
+	decoded, err := decoder.DecodeStream(io.MultiReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, *decoded) {
+		t.Fatalf("expected %v but got %v", data, *decoded)
+	}
+}
+
+func TestStreamSequence(t *testing.T) {
+	type record struct {
+		ID   int
+		Name string
+		Tags []string
+	}
+	records := []record{
+		{1, "first", []string{"a"}},
+		{2, "second", []string{}},
+		{3, "third", []string{"b", "c"}},
+	}
+	r, w := io.Pipe()
+	go func() {
+		sw := gbin.NewEncoder[record]().NewStreamWriter(w)
+		for i := range records {
+			if err := sw.Write(&records[i]); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+		w.Close()
+	}()
+	sr := gbin.NewDecoder[record]().NewStreamReader(r)
+	for i := 0; ; i++ {
+		decoded, err := sr.Read()
+		if err == io.EOF {
+			if i != len(records) {
+				t.Fatalf("expected %d records but read %d", len(records), i)
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(records[i], *decoded) {
+			t.Fatalf("expected %v but got %v", records[i], *decoded)
+		}
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	data := []string{"abc", "def", "ghi"}
+	encoded, err := gbin.NewEncoder[[]string]().Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := gbin.NewDecoder[[]string]().NewStreamReader(bytes.NewReader(encoded[:len(encoded)-2]))
+	_, err = sr.Read()
+	if err == nil || errors.Is(err, io.EOF) {
+		t.Fatalf("expected truncation error but got %v", err)
+	}
+}
+
+func TestTagRename(t *testing.T) {
+	data := struct {
+		A string `gbin:"a"`
+		B int    `gbin:"-"`
+		C []int  `gbin:",omitempty"`
+		D *int   `gbin:"d,omitempty"`
+	}{A: "renamed"}
+	encoded, err := gbin.NewEncoder[struct {
+		A string `gbin:"a"`
+		B int    `gbin:"-"`
+		C []int  `gbin:",omitempty"`
+		D *int   `gbin:"d,omitempty"`
+	}]().Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := gbin.NewEncoder[struct {
+		A string `gbin:"a"`
+	}]().Encode(&struct {
+		A string `gbin:"a"`
+	}{"renamed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, expected) {
+		t.Fatalf("expected only field a to be encoded:\n% x\n% x", encoded, expected)
+	}
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestSchemaEvolution(t *testing.T) {
+	type v1 struct {
+		Name    string
+		Count   int
+		Removed map[string]int
+		Nested  struct{ X, Y int }
+	}
+	type v2 struct {
+		Title   string `gbin:"title,alias=Name"`
+		Count   int
+		Added   int     `gbin:",default=42"`
+		Ratio   float64 `gbin:",default=0.5"`
+		Enabled *bool   `gbin:",default=true"`
+		Nested  struct {
+			X int
+			Z string `gbin:",default=z"`
+		}
+	}
+	old := v1{Name: "cache", Count: 7, Removed: map[string]int{"a": 1}}
+	old.Nested.X = 3
+	old.Nested.Y = 4
+	encoded, err := gbin.NewEncoder[v1]().Encode(&old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[v2]().Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Title != "cache" || decoded.Count != 7 || decoded.Added != 42 || decoded.Ratio != 0.5 {
+		t.Fatalf("unexpected decoded value %#v", *decoded)
+	}
+	if decoded.Enabled == nil || !*decoded.Enabled {
+		t.Fatalf("expected default pointer value to be set")
+	}
+	if decoded.Nested.X != 3 || decoded.Nested.Z != "z" {
+		t.Fatalf("unexpected nested value %#v", decoded.Nested)
+	}
+
+	updated := v2{Title: "new", Added: 0, Enabled: new(bool)}
+	encoded, err = gbin.NewEncoder[v2]().Encode(&updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip, err := gbin.NewDecoder[v2]().Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roundTrip.Added != 0 || *roundTrip.Enabled {
+		t.Fatalf("encoded values should take precedence over defaults, got %#v", *roundTrip)
+	}
+}
+
+func TestDefaultPointers(t *testing.T) {
+	type withDefault struct {
+		Name  string
+		Limit *int `gbin:",default=5"`
+	}
+	decoder := gbin.NewDecoder[withDefault]()
+	var decoded []*withDefault
+	for _, name := range []string{"first", "second"} {
+		encoded, err := gbin.Marshal(struct{ Name string }{name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := decoder.Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value.Limit == nil || *value.Limit != 5 {
+			t.Fatalf("expected the default of 5, got %v", value.Limit)
+		}
+		decoded = append(decoded, value)
+		// changing a decoded default must not change the next
+		*value.Limit = 99
+	}
+	if decoded[0].Limit == decoded[1].Limit {
+		t.Fatal("expected each decoded value to have its own default pointer")
+	}
+}
+
+func TestInvalidTags(t *testing.T) {
+	duplicate := struct {
+		A int `gbin:"x"`
+		B int `gbin:"x"`
+	}{}
+	if _, err := gbin.NewEncoder[struct {
+		A int `gbin:"x"`
+		B int `gbin:"x"`
+	}]().Encode(&duplicate); err == nil {
+		t.Fatal("expected duplicate field names to be rejected")
+	}
+	badDefault := struct {
+		A int `gbin:",default=abc"`
+	}{}
+	if _, err := gbin.NewEncoder[struct {
+		A int `gbin:",default=abc"`
+	}]().Encode(&badDefault); err == nil {
+		t.Fatal("expected invalid default to be rejected")
+	}
+	// an omitted zero value would be decoded as the default
+	omitted := struct {
+		A int `gbin:",omitempty,default=5"`
+	}{}
+	if _, err := gbin.NewEncoder[struct {
+		A int `gbin:",omitempty,default=5"`
+	}]().Encode(&omitted); err == nil {
+		t.Fatal("expected omitempty with a default to be rejected")
+	}
+}
+
+func TestLargePayload(t *testing.T) {
+	data := strings.Repeat("large", 4<<20)
+	encoded, err := gbin.NewEncoder[string]().Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lenLen := encoded[0] & 0b111; lenLen != 4 {
+		t.Fatalf("expected a 4 byte length but found %d", lenLen)
+	}
+	decoded, err := gbin.NewDecoder[string]().DecodeStream(io.MultiReader(bytes.NewReader(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *decoded != data {
+		t.Fatal("decoded string does not match")
+	}
+}
+
+func TestExtendedLength(t *testing.T) {
+	encoded := []byte{5 << 3, 0, 0, 0, 0, 0, 0, 0, 3, 'a', 'b', 'c'}
+	decoded, err := gbin.NewDecoder[string]().Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *decoded != "abc" {
+		t.Fatalf("expected abc but got %q", *decoded)
+	}
+}
+
+func TestCorruptLength(t *testing.T) {
+	encoded := []byte{5<<3 | 4, 0x40, 0, 0, 0, 'a'}
+	if _, err := gbin.NewDecoder[string]().Decode(encoded); err == nil || !strings.Contains(err.Error(), "remaining input") {
+		t.Fatalf("expected length to be rejected against the input, got %v", err)
+	}
+	_, err := gbin.NewDecoder[string]().DecodeStream(io.MultiReader(bytes.NewReader(encoded)))
+	if err == nil || !strings.Contains(err.Error(), io.ErrUnexpectedEOF.Error()) {
+		t.Fatalf("expected truncated stream to fail without allocating its length, got %v", err)
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	encoder := gbin.NewEncoder[benchRecord](gbin.WithCompact())
+	decoder := gbin.NewDecoder[benchRecord]()
+	records := benchRecords()
+	errs := make(chan error, len(records))
+	for _, record := range records {
+		go func(record benchRecord) {
+			for i := 0; i < 10; i++ {
+				encoded, err := encoder.Encode(&record)
+				if err != nil {
+					errs <- err
+					return
+				}
+				decoded, err := decoder.Decode(encoded)
+				if err != nil {
+					errs <- err
+					return
+				} else if !reflect.DeepEqual(*decoded, record) {
+					errs <- fmt.Errorf("decoded %v as %v", record, *decoded)
+					return
+				}
+			}
+			errs <- nil
+		}(record)
+	}
+	for range records {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeTo(b *testing.B) {
+	data := make([]int, 100000)
+	for i := range data {
+		data[i] = i
+	}
+	encoder := gbin.NewEncoder[[]int]()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := encoder.EncodeTo(io.Discard, &data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func runTest[T any](data T, opts ...gbin.Option) bool {
+	encoder := gbin.NewEncoder[T](opts...)
 	decoder := gbin.NewDecoder[T]()
 	encoded, err := encoder.Encode(&data)
 	if err != nil {
@@ -201,3 +617,149 @@ func runTest[T any](data T) bool {
 		return false
 	}
 }
+
+type benchRecord struct {
+	ID     int64
+	Name   string
+	Scores []float64
+	Attrs  map[string]int32
+	Pos    [3]int16
+}
+
+func benchRecords() []benchRecord {
+	data := make([]benchRecord, 100)
+	for i := range data {
+		data[i] = benchRecord{
+			ID:     int64(i),
+			Name:   fmt.Sprintf("record%d", i),
+			Scores: []float64{1, 2, 3, 4},
+			Attrs:  map[string]int32{"a": 1, "b": int32(i)},
+			Pos:    [3]int16{1, 2, 3},
+		}
+	}
+	return data
+}
+
+func BenchmarkDecode(b *testing.B) {
+	data := benchRecords()
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	decoder := gbin.NewDecoder[[]benchRecord]()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := decoder.Decode(encoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	data := benchRecords()
+	encoder := gbin.NewEncoder[[]benchRecord]()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := encoder.Encode(&data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEncodeParallel encodes small values from many goroutines with one
+// encoder, where the cost of each call outweighs the cost of the value
+func BenchmarkEncodeParallel(b *testing.B) {
+	encoder := gbin.NewEncoder[benchRecord]()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		data := benchRecords()[1]
+		for pb.Next() {
+			if _, err := encoder.Encode(&data); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkDecodeParallel(b *testing.B) {
+	data := benchRecords()[1]
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	decoder := gbin.NewDecoder[benchRecord]()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := decoder.Decode(encoded); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+type deepLabel string
+
+// deepValue returns a value nested n slices deep, with a label at each level
+func deepValue(n int) []any {
+	v := []any{deepLabel("end")}
+	for i := n - 1; i >= 0; i-- {
+		v = []any{deepLabel(fmt.Sprint(i)), v}
+	}
+	return v
+}
+
+func TestDeepNesting(t *testing.T) {
+	const n = 2000
+	data := deepValue(n)
+	calls := 0
+	codec := gbin.NewCodec(
+		func(l deepLabel) ([]byte, error) {
+			calls++
+			return []byte(l), nil
+		},
+		func(data []byte) (deepLabel, error) {
+			return deepLabel(data), nil
+		},
+	)
+	for _, opts := range [][]gbin.Option{{gbin.WithCodecs(codec)}, {gbin.WithCodecs(codec), gbin.WithCompact()}} {
+		calls = 0
+		encoded, err := gbin.NewEncoder[[]any](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// each label is measured once rather than again for every container
+		// enclosing it, so the calls grow with the depth of the value and not
+		// its square
+		if calls != n+1 {
+			t.Fatalf("expected %d calls to the codec, got %d", n+1, calls)
+		}
+		decoded, err := gbin.NewDecoder[[]any](opts...).Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		level := *decoded
+		for depth := 0; depth < n; depth++ {
+			if len(level) != 2 {
+				t.Fatalf("expected a label and the next level at depth %d, got %v", depth, level)
+			}
+			level = level[1].([]any)
+		}
+		if len(level) != 1 {
+			t.Fatalf("expected the last level to hold only its label, got %v", level)
+		}
+	}
+}
+
+func BenchmarkEncodeDeep(b *testing.B) {
+	data := deepValue(4000)
+	encoder := gbin.NewEncoder[[]any]()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := encoder.Encode(&data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
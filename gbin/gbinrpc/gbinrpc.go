@@ -0,0 +1,138 @@
+// Package gbinrpc implements a gbin codec for net/rpc, so that the arguments
+// and replies of remote procedure calls are encoded with gbin.
+//
+// Each request and response is sent as two messages of a gbin.Conn: a header
+// naming the method and sequence number of the call, then its arguments or
+// reply.
+package gbinrpc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+// header precedes the body of every request and response
+type header struct {
+	ServiceMethod string
+	Seq           uint64
+	Error         string `gbin:",omitempty"`
+}
+
+type clientCodec struct {
+	conn *gbin.Conn
+}
+
+// NewClientCodec returns a rpc.ClientCodec making calls over conn. Options
+// configure the encoding of arguments and the decoding of replies.
+func NewClientCodec(conn io.ReadWriteCloser, opts ...gbin.Option) rpc.ClientCodec {
+	return &clientCodec{gbin.NewConn(conn, opts...)}
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, body any) error {
+	return c.conn.Send(&header{ServiceMethod: r.ServiceMethod, Seq: r.Seq}, body)
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	var h header
+	err := c.conn.Receive(&h)
+	if err != nil {
+		return err
+	}
+	r.ServiceMethod, r.Seq, r.Error = h.ServiceMethod, h.Seq, h.Error
+	return nil
+}
+
+func (c *clientCodec) ReadResponseBody(body any) error {
+	return c.conn.Receive(body)
+}
+
+func (c *clientCodec) Close() error {
+	return c.conn.Close()
+}
+
+type serverCodec struct {
+	conn      *gbin.Conn
+	transport *transport
+}
+
+// NewServerCodec returns a rpc.ServerCodec serving calls made over conn.
+// Options configure the decoding of arguments and the encoding of replies.
+func NewServerCodec(conn io.ReadWriteCloser, opts ...gbin.Option) rpc.ServerCodec {
+	t := &transport{ReadWriteCloser: conn}
+	return &serverCodec{gbin.NewConn(t, opts...), t}
+}
+
+// transport records the last error from writing to a connection, so that a
+// response which could not be sent is told apart from one which could not be
+// encoded
+type transport struct {
+	io.ReadWriteCloser
+	err error
+}
+
+func (t *transport) Write(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Write(p)
+	if err != nil {
+		t.err = err
+	}
+	return n, err
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	var h header
+	err := c.conn.Receive(&h)
+	if err != nil {
+		return err
+	}
+	r.ServiceMethod, r.Seq = h.ServiceMethod, h.Seq
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body any) error {
+	return c.conn.Receive(body)
+}
+
+// WriteResponse sends a response. A reply which cannot be encoded is replaced
+// by the error encoding it, so that the caller is not left waiting. Nothing is
+// written until the whole response is encoded, so it can be sent again, but a
+// failure to write to the connection is returned as it is. net/rpc does not
+// write responses concurrently.
+func (c *serverCodec) WriteResponse(r *rpc.Response, body any) error {
+	h := header{ServiceMethod: r.ServiceMethod, Seq: r.Seq, Error: r.Error}
+	c.transport.err = nil
+	err := c.conn.Send(&h, body)
+	var encodeErr *gbin.Error
+	if c.transport.err == nil && errors.As(err, &encodeErr) {
+		h.Error = "gbinrpc: encoding reply: " + err.Error()
+		return c.conn.Send(&h, nil)
+	}
+	return err
+}
+
+func (c *serverCodec) Close() error {
+	return c.conn.Close()
+}
+
+// NewClient returns a rpc.Client making calls over conn
+func NewClient(conn io.ReadWriteCloser, opts ...gbin.Option) *rpc.Client {
+	return rpc.NewClientWithCodec(NewClientCodec(conn, opts...))
+}
+
+// Dial connects to a RPC server at the given network address
+func Dial(network, address string, opts ...gbin.Option) (*rpc.Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, opts...), nil
+}
+
+// ServeConn serves calls made over conn with rpc.DefaultServer, blocking
+// until the client hangs up
+func ServeConn(conn io.ReadWriteCloser, opts ...gbin.Option) {
+	rpc.ServeCodec(NewServerCodec(conn, opts...))
+}
@@ -0,0 +1,185 @@
+package gbinrpc_test
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+	"github.com/lspaccatrosi16/go-libs/gbin/gbinrpc"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Quotient struct {
+	Quo, Rem int
+}
+
+type Report struct {
+	Totals map[string]float64
+	Notify any
+}
+
+type Arith struct{}
+
+func (Arith) Multiply(args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func (Arith) Divide(args Args, quo *Quotient) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*quo = Quotient{args.A / args.B, args.A % args.B}
+	return nil
+}
+
+func (Arith) Report(args *Args, report *Report) error {
+	report.Totals = map[string]float64{"sum": float64(args.A + args.B)}
+	if args.A < 0 {
+		// channels cannot be encoded
+		report.Notify = make(chan int)
+	}
+	return nil
+}
+
+// pipe returns a client making calls to a server serving Arith over net.Pipe
+func pipe(t *testing.T, opts ...gbin.Option) *rpc.Client {
+	server := rpc.NewServer()
+	if err := server.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	go server.ServeCodec(gbinrpc.NewServerCodec(serverConn, opts...))
+	client := gbinrpc.NewClient(clientConn, opts...)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestCalls(t *testing.T) {
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}, {gbin.WithCompression(gbin.Flate)}} {
+		client := pipe(t, opts...)
+		var product int
+		if err := client.Call("Arith.Multiply", Args{7, 8}, &product); err != nil || product != 56 {
+			t.Fatalf("expected 56, got %d (%v)", product, err)
+		}
+		var quo Quotient
+		if err := client.Call("Arith.Divide", &Args{17, 5}, &quo); err != nil || quo != (Quotient{3, 2}) {
+			t.Fatalf("expected {3 2}, got %v (%v)", quo, err)
+		}
+		var report Report
+		if err := client.Call("Arith.Report", Args{1, 2}, &report); err != nil || report.Totals["sum"] != 3 {
+			t.Fatalf("expected a sum of 3, got %v (%v)", report, err)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	client := pipe(t)
+	var quo Quotient
+	err := client.Call("Arith.Divide", Args{1, 0}, &quo)
+	if err == nil || err.Error() != "divide by zero" {
+		t.Fatalf("expected the error returned by the method, got %v", err)
+	}
+	err = client.Call("Arith.Missing", Args{}, &quo)
+	if err == nil || !strings.Contains(err.Error(), "can't find method") {
+		t.Fatalf("expected an unknown method to fail, got %v", err)
+	}
+	var report Report
+	err = client.Call("Arith.Report", Args{-1, 2}, &report)
+	if err == nil || !strings.Contains(err.Error(), "encoding reply") {
+		t.Fatalf("expected a reply which cannot be encoded to fail, got %v", err)
+	}
+	// the connection is still usable
+	var product int
+	if err := client.Call("Arith.Multiply", Args{3, 4}, &product); err != nil || product != 12 {
+		t.Fatalf("expected 12, got %d (%v)", product, err)
+	}
+	// until a reply cannot be decoded, which net/rpc treats as fatal
+	var text string
+	err = client.Call("Arith.Multiply", Args{1, 2}, &text)
+	if err == nil || !strings.Contains(err.Error(), "reading body") {
+		t.Fatalf("expected a reply of the wrong type to fail, got %v", err)
+	}
+}
+
+// failingConn is a connection which cannot be written to
+type failingConn struct {
+	net.Conn
+	writes int
+}
+
+var errWrite = errors.New("write failed")
+
+func (c *failingConn) Write(p []byte) (int, error) {
+	c.writes++
+	return 0, errWrite
+}
+
+func TestWriteFailure(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	conn := &failingConn{Conn: serverConn}
+	codec := gbinrpc.NewServerCodec(conn)
+	defer codec.Close()
+	product := 12
+	err := codec.WriteResponse(&rpc.Response{ServiceMethod: "Arith.Multiply", Seq: 1}, &product)
+	if !errors.Is(err, errWrite) {
+		t.Fatalf("expected the write error, got %v", err)
+	}
+	// a response which was encoded but not sent is not replaced by an error
+	if conn.writes != 1 {
+		t.Fatalf("expected 1 write, got %d", conn.writes)
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	client := pipe(t, gbin.WithCompact())
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var product int
+			if err := client.Call("Arith.Multiply", Args{i, i}, &product); err != nil || product != i*i {
+				t.Errorf("expected %d, got %d (%v)", i*i, product, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestServeConn(t *testing.T) {
+	if err := rpc.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go gbinrpc.ServeConn(conn)
+		}
+	}()
+	client, err := gbinrpc.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var product int
+	if err := client.Call("Arith.Multiply", Args{6, 7}, &product); err != nil || product != 42 {
+		t.Fatalf("expected 42, got %d (%v)", product, err)
+	}
+}
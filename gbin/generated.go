@@ -0,0 +1,465 @@
+package gbin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"reflect"
+	"sync"
+)
+
+// Writer builds the standard encoding of a value without reflection. It is
+// used by the MarshalGbin methods generated by gbingen, and is not needed to
+// encode values otherwise.
+type Writer struct {
+	buf []byte
+	err error
+}
+
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+// Bytes returns the encoded data, or the first error that occurred
+func (w *Writer) Bytes() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf, nil
+}
+
+// Write appends p to the encoded data
+func (w *Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+// Begin starts a container of the given type, returning its position to be
+// passed to End once its payload has been written
+func (w *Writer) Begin(objectType EncodedType) int {
+	start := len(w.buf)
+	w.buf = append(w.buf, byte(objectType), 0, 0, 0, 0, 0, 0, 0, 0)
+	return start
+}
+
+// End writes the header of the container started at start
+func (w *Writer) End(start int) {
+	payloadLen := uint64(len(w.buf) - start - 9)
+	var scratch [9]byte
+	header := appendHeader(scratch[:0], EncodedType(w.buf[start]), payloadLen)
+	n := copy(w.buf[start:], header)
+	copy(w.buf[start+n:], w.buf[start+9:])
+	w.buf = w.buf[:len(w.buf)-(9-n)]
+}
+
+// appendHeader appends the standard header of a value to buf
+func appendHeader(buf []byte, objectType EncodedType, payloadLen uint64) []byte {
+	lenLen := (bits.Len64(payloadLen) / 8) + 1
+	if lenLen > 7 {
+		buf = append(buf, byte(objectType)<<3|EXTENDED_LEN)
+		return binary.BigEndian.AppendUint64(buf, payloadLen)
+	}
+	buf = append(buf, byte(objectType)<<3|byte(lenLen))
+	for i := lenLen - 1; i >= 0; i-- {
+		buf = append(buf, byte(payloadLen>>(8*i)))
+	}
+	return buf
+}
+
+// Zero writes the zero value which prefixes the contents of a container, as
+// returned by a function from ZeroOf
+func (w *Writer) Zero(zero func() ([]byte, error)) {
+	data, err := zero()
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	w.buf = append(w.buf, data...)
+}
+
+// Nil writes a nil value
+func (w *Writer) Nil() {
+	w.buf = appendHeader(w.buf, INVALID, 0)
+}
+
+// Value encodes the value ptr points to with reflection, for types that
+// generated code does not handle itself
+func (w *Writer) Value(ptr any) {
+	bw := bufio.NewWriter(w)
+	tf := newEncodeTransformer(bw, newOptions(nil))
+	err := tf.encode(reflect.ValueOf(ptr).Elem())
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil && w.err == nil {
+		w.err = encodeError(tf, err)
+	}
+}
+
+func (w *Writer) fixed(objectType EncodedType, size int) {
+	w.buf = appendHeader(w.buf, objectType, uint64(size))
+}
+
+func (w *Writer) String(v string) {
+	w.buf = appendHeader(w.buf, STRING, uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+func (w *Writer) Bool(v bool) {
+	w.fixed(BOOL, 1)
+	if v {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *Writer) Int(v int) {
+	w.fixed(INT, 8)
+	w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(v))
+}
+
+func (w *Writer) Int8(v int8) {
+	w.fixed(INT8, 1)
+	w.buf = append(w.buf, byte(v))
+}
+
+func (w *Writer) Int16(v int16) {
+	w.fixed(INT16, 2)
+	w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(v))
+}
+
+func (w *Writer) Int32(v int32) {
+	w.fixed(INT32, 4)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(v))
+}
+
+func (w *Writer) Int64(v int64) {
+	w.fixed(INT64, 8)
+	w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(v))
+}
+
+func (w *Writer) Uint(v uint) {
+	w.fixed(UINT, 8)
+	w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(v))
+}
+
+func (w *Writer) Uint8(v uint8) {
+	w.fixed(UINT8, 1)
+	w.buf = append(w.buf, v)
+}
+
+func (w *Writer) Uint16(v uint16) {
+	w.fixed(UINT16, 2)
+	w.buf = binary.BigEndian.AppendUint16(w.buf, v)
+}
+
+func (w *Writer) Uint32(v uint32) {
+	w.fixed(UINT32, 4)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, v)
+}
+
+func (w *Writer) Uint64(v uint64) {
+	w.fixed(UINT64, 8)
+	w.buf = binary.BigEndian.AppendUint64(w.buf, v)
+}
+
+func (w *Writer) Uintptr(v uintptr) {
+	w.fixed(UINTPTR, 8)
+	w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(v))
+}
+
+func (w *Writer) Float32(v float32) {
+	w.fixed(FLOAT32, 4)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, math.Float32bits(v))
+}
+
+func (w *Writer) Float64(v float64) {
+	w.fixed(FLOAT64, 8)
+	w.buf = binary.BigEndian.AppendUint64(w.buf, math.Float64bits(v))
+}
+
+func (w *Writer) Complex64(v complex64) {
+	w.fixed(COMPLEX64, 8)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, math.Float32bits(real(v)))
+	w.buf = binary.BigEndian.AppendUint32(w.buf, math.Float32bits(imag(v)))
+}
+
+func (w *Writer) Complex128(v complex128) {
+	w.fixed(COMPLEX128, 16)
+	w.buf = binary.BigEndian.AppendUint64(w.buf, math.Float64bits(real(v)))
+	w.buf = binary.BigEndian.AppendUint64(w.buf, math.Float64bits(imag(v)))
+}
+
+// ZeroOf returns a function giving the encoded zero value of T which prefixes
+// the contents of containers of T. It is computed with reflection the first
+// time the function is called.
+func ZeroOf[T any]() func() ([]byte, error) {
+	return sync.OnceValues(func() ([]byte, error) {
+		buf := bytes.NewBuffer([]byte{})
+		bw := bufio.NewWriter(buf)
+		tf := newEncodeTransformer(bw, newOptions(nil))
+		err := tf.encode_zero(reflect.TypeOf((*T)(nil)).Elem())
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err != nil {
+			return nil, encodeError(tf, err)
+		}
+		return buf.Bytes(), nil
+	})
+}
+
+// Reader reads the standard encoding of a value without reflection. It is
+// used by the UnmarshalGbin methods generated by gbingen, which fall back to
+// decoding with reflection whenever a Reader method reports that the data is
+// not laid out as expected.
+type Reader struct {
+	data   []byte
+	offset int
+}
+
+func NewReader(data []byte) *Reader {
+	return &Reader{data: data}
+}
+
+// header reads the header of the next value
+func (r *Reader) header() (EncodedType, int, bool) {
+	if r.offset >= len(r.data) {
+		return INVALID, 0, false
+	}
+	control := r.data[r.offset]
+	lenLen := int(lengthBytes(control))
+	if len(r.data)-r.offset-1 < lenLen {
+		return INVALID, 0, false
+	}
+	payloadLen := uint64(0)
+	for _, b := range r.data[r.offset+1 : r.offset+1+lenLen] {
+		payloadLen = payloadLen<<8 | uint64(b)
+	}
+	r.offset += 1 + lenLen
+	if payloadLen > uint64(len(r.data)-r.offset) {
+		return INVALID, 0, false
+	}
+	return EncodedType(control >> 3), int(payloadLen), true
+}
+
+// Begin reads the header of a container of the given type, returning the
+// offset at which its payload ends
+func (r *Reader) Begin(objectType EncodedType) (int, bool) {
+	found, payloadLen, ok := r.header()
+	if !ok || found != objectType {
+		return 0, false
+	}
+	return r.offset + payloadLen, true
+}
+
+// More reports whether the payload of a container ending at end has more
+// values
+func (r *Reader) More(end int) bool {
+	return r.offset < end
+}
+
+// End reports whether exactly the payload of a container ending at end was
+// read
+func (r *Reader) End(end int) bool {
+	return r.offset == end
+}
+
+// Done reports whether all of the data was read
+func (r *Reader) Done() bool {
+	return r.offset == len(r.data)
+}
+
+// Skip discards the next value
+func (r *Reader) Skip() bool {
+	_, payloadLen, ok := r.header()
+	if !ok {
+		return false
+	}
+	r.offset += payloadLen
+	return true
+}
+
+// Nil reports whether the next value is nil, discarding it if so
+func (r *Reader) Nil() bool {
+	if r.offset >= len(r.data) || EncodedType(r.data[r.offset]>>3) != INVALID {
+		return false
+	}
+	return r.Skip()
+}
+
+// Value decodes the next value into the value ptr points to with reflection,
+// for types that generated code does not handle itself
+func (r *Reader) Value(ptr any) bool {
+	start := r.offset
+	if !r.Skip() {
+		return false
+	}
+	return Unmarshal(r.data[start:r.offset], ptr) == nil
+}
+
+// fixed reads the payload of a fixed width value
+func (r *Reader) fixed(objectType EncodedType, size int) ([]byte, bool) {
+	found, payloadLen, ok := r.header()
+	if !ok || found != objectType || payloadLen != size {
+		return nil, false
+	}
+	payload := r.data[r.offset : r.offset+size]
+	r.offset += size
+	return payload, true
+}
+
+func (r *Reader) String(v *string) bool {
+	found, payloadLen, ok := r.header()
+	if !ok || found != STRING {
+		return false
+	}
+	*v = string(r.data[r.offset : r.offset+payloadLen])
+	r.offset += payloadLen
+	return true
+}
+
+func (r *Reader) Bool(v *bool) bool {
+	payload, ok := r.fixed(BOOL, 1)
+	if ok {
+		*v = payload[0] != 0
+	}
+	return ok
+}
+
+func (r *Reader) Int(v *int) bool {
+	var wide int64
+	if !r.int64(INT, &wide) || int64(int(wide)) != wide {
+		return false
+	}
+	*v = int(wide)
+	return true
+}
+
+func (r *Reader) Int8(v *int8) bool {
+	payload, ok := r.fixed(INT8, 1)
+	if ok {
+		*v = int8(payload[0])
+	}
+	return ok
+}
+
+func (r *Reader) Int16(v *int16) bool {
+	payload, ok := r.fixed(INT16, 2)
+	if ok {
+		*v = int16(binary.BigEndian.Uint16(payload))
+	}
+	return ok
+}
+
+func (r *Reader) Int32(v *int32) bool {
+	payload, ok := r.fixed(INT32, 4)
+	if ok {
+		*v = int32(binary.BigEndian.Uint32(payload))
+	}
+	return ok
+}
+
+func (r *Reader) Int64(v *int64) bool {
+	return r.int64(INT64, v)
+}
+
+func (r *Reader) int64(objectType EncodedType, v *int64) bool {
+	payload, ok := r.fixed(objectType, 8)
+	if ok {
+		*v = int64(binary.BigEndian.Uint64(payload))
+	}
+	return ok
+}
+
+func (r *Reader) Uint(v *uint) bool {
+	var wide uint64
+	if !r.uint64(UINT, &wide) || uint64(uint(wide)) != wide {
+		return false
+	}
+	*v = uint(wide)
+	return true
+}
+
+func (r *Reader) Uint8(v *uint8) bool {
+	payload, ok := r.fixed(UINT8, 1)
+	if ok {
+		*v = payload[0]
+	}
+	return ok
+}
+
+func (r *Reader) Uint16(v *uint16) bool {
+	payload, ok := r.fixed(UINT16, 2)
+	if ok {
+		*v = binary.BigEndian.Uint16(payload)
+	}
+	return ok
+}
+
+func (r *Reader) Uint32(v *uint32) bool {
+	payload, ok := r.fixed(UINT32, 4)
+	if ok {
+		*v = binary.BigEndian.Uint32(payload)
+	}
+	return ok
+}
+
+func (r *Reader) Uint64(v *uint64) bool {
+	return r.uint64(UINT64, v)
+}
+
+func (r *Reader) uint64(objectType EncodedType, v *uint64) bool {
+	payload, ok := r.fixed(objectType, 8)
+	if ok {
+		*v = binary.BigEndian.Uint64(payload)
+	}
+	return ok
+}
+
+func (r *Reader) Uintptr(v *uintptr) bool {
+	var wide uint64
+	if !r.uint64(UINTPTR, &wide) || uint64(uintptr(wide)) != wide {
+		return false
+	}
+	*v = uintptr(wide)
+	return true
+}
+
+func (r *Reader) Float32(v *float32) bool {
+	payload, ok := r.fixed(FLOAT32, 4)
+	if ok {
+		*v = math.Float32frombits(binary.BigEndian.Uint32(payload))
+	}
+	return ok
+}
+
+func (r *Reader) Float64(v *float64) bool {
+	payload, ok := r.fixed(FLOAT64, 8)
+	if ok {
+		*v = math.Float64frombits(binary.BigEndian.Uint64(payload))
+	}
+	return ok
+}
+
+func (r *Reader) Complex64(v *complex64) bool {
+	payload, ok := r.fixed(COMPLEX64, 8)
+	if ok {
+		re := math.Float32frombits(binary.BigEndian.Uint32(payload))
+		im := math.Float32frombits(binary.BigEndian.Uint32(payload[4:]))
+		*v = complex(re, im)
+	}
+	return ok
+}
+
+func (r *Reader) Complex128(v *complex128) bool {
+	payload, ok := r.fixed(COMPLEX128, 16)
+	if ok {
+		re := math.Float64frombits(binary.BigEndian.Uint64(payload))
+		im := math.Float64frombits(binary.BigEndian.Uint64(payload[8:]))
+		*v = complex(re, im)
+	}
+	return ok
+}
@@ -0,0 +1,909 @@
+// Code generated by gbingen. DO NOT EDIT.
+
+package gentest
+
+import (
+	"github.com/lspaccatrosi16/go-libs/gbin"
+	"math"
+)
+
+// zero values which prefix the contents of containers
+var (
+	gbinPointZero0  = gbin.ZeroOf[Point]()
+	gbinPointZero1  = gbin.ZeroOf[string]()
+	gbinPointZero2  = gbin.ZeroOf[Level]()
+	gbinPointZero3  = gbin.ZeroOf[byte]()
+	gbinPointZero4  = gbin.ZeroOf[Shape]()
+	gbinPointZero5  = gbin.ZeroOf[*Point]()
+	gbinPointZero6  = gbin.ZeroOf[[2]int16]()
+	gbinPointZero7  = gbin.ZeroOf[int16]()
+	gbinPointZero8  = gbin.ZeroOf[*int]()
+	gbinPointZero9  = gbin.ZeroOf[int]()
+	gbinPointZero10 = gbin.ZeroOf[float32]()
+	gbinPointZero11 = gbin.ZeroOf[[]Point]()
+)
+
+// MarshalGbin encodes x in the standard format without reflection
+func (x Point) MarshalGbin() ([]byte, error) {
+	w := gbin.NewWriter()
+	x.gbinEncode(w)
+	return w.Bytes()
+}
+
+// UnmarshalGbin decodes data into x without reflection, falling back to
+// reflection for data which is not laid out as Point is encoded
+func (x *Point) UnmarshalGbin(data []byte) error {
+	var v Point
+	r := gbin.NewReader(data)
+	if v.gbinDecode(r) && r.Done() {
+		*x = v
+		return nil
+	}
+	return gbin.Unmarshal(data, (*gbinPlainPoint)(x))
+}
+
+// gbinPlainPoint has the fields of Point without its methods, for decoding with reflection
+type gbinPlainPoint Point
+
+func (x *Point) gbinEncode(w *gbin.Writer) {
+	start := w.Begin(gbin.STRUCT)
+	w.String("X")
+	w.Int32(x.X)
+	w.String("Y")
+	w.Int32(x.Y)
+	w.End(start)
+}
+
+func (x *Point) gbinDecode(r *gbin.Reader) bool {
+	end, ok := r.Begin(gbin.STRUCT)
+	if !ok {
+		return false
+	}
+	*x = Point{}
+	var key string
+	for r.More(end) {
+		if !r.String(&key) {
+			return false
+		}
+		switch key {
+		case "X":
+			if !r.Int32(&x.X) {
+				return false
+			}
+		case "Y":
+			if !r.Int32(&x.Y) {
+				return false
+			}
+		default:
+			if !r.Skip() {
+				return false
+			}
+		}
+	}
+	return r.End(end)
+}
+
+// MarshalGbin encodes x in the standard format without reflection
+func (x Shape) MarshalGbin() ([]byte, error) {
+	w := gbin.NewWriter()
+	x.gbinEncode(w)
+	return w.Bytes()
+}
+
+// UnmarshalGbin decodes data into x without reflection, falling back to
+// reflection for data which is not laid out as Shape is encoded
+func (x *Shape) UnmarshalGbin(data []byte) error {
+	var v Shape
+	r := gbin.NewReader(data)
+	if v.gbinDecode(r) && r.Done() {
+		*x = v
+		return nil
+	}
+	return gbin.Unmarshal(data, (*gbinPlainShape)(x))
+}
+
+// gbinPlainShape has the fields of Shape without its methods, for decoding with reflection
+type gbinPlainShape Shape
+
+func (x *Shape) gbinEncode(w *gbin.Writer) {
+	start := w.Begin(gbin.STRUCT)
+	w.String("Name")
+	w.String(x.Name)
+	w.String("Vertices")
+	if x.Vertices == nil {
+		w.Nil()
+	} else {
+		start1 := w.Begin(gbin.SLICE)
+		w.Zero(gbinPointZero0)
+		for i1 := range x.Vertices {
+			x.Vertices[i1].gbinEncode(w)
+		}
+		w.End(start1)
+	}
+	w.String("Centre")
+	{
+		start1 := w.Begin(gbin.PTR)
+		w.Zero(gbinPointZero0)
+		if x.Centre == nil {
+			w.Nil()
+		} else {
+			p1 := x.Centre
+			p1.gbinEncode(w)
+		}
+		w.End(start1)
+	}
+	w.String("Bounds")
+	{
+		start1 := w.Begin(gbin.ARRAY)
+		w.Zero(gbinPointZero0)
+		for i1 := range x.Bounds {
+			x.Bounds[i1].gbinEncode(w)
+		}
+		w.End(start1)
+	}
+	w.String("Tags")
+	if x.Tags == nil {
+		w.Nil()
+	} else {
+		start1 := w.Begin(gbin.MAP)
+		w.Zero(gbinPointZero1)
+		w.Zero(gbinPointZero2)
+		for k1, v1 := range x.Tags {
+			w.String(k1)
+			w.Int8(int8(v1))
+		}
+		w.End(start1)
+	}
+	w.End(start)
+}
+
+func (x *Shape) gbinDecode(r *gbin.Reader) bool {
+	end, ok := r.Begin(gbin.STRUCT)
+	if !ok {
+		return false
+	}
+	*x = Shape{}
+	var key string
+	for r.More(end) {
+		if !r.String(&key) {
+			return false
+		}
+		switch key {
+		case "Name":
+			if !r.String(&x.Name) {
+				return false
+			}
+		case "Vertices":
+			if !r.Nil() {
+				end1, ok := r.Begin(gbin.SLICE)
+				if !ok || !r.Skip() {
+					return false
+				}
+				x.Vertices = make([]Point, 0)
+				for r.More(end1) {
+					var el1 Point
+					if !el1.gbinDecode(r) {
+						return false
+					}
+					x.Vertices = append(x.Vertices, el1)
+				}
+				if !r.End(end1) {
+					return false
+				}
+			}
+		case "Centre":
+			{
+				end1, ok := r.Begin(gbin.PTR)
+				if !ok || !r.Skip() {
+					return false
+				}
+				if !r.Nil() {
+					p1 := new(Point)
+					if !p1.gbinDecode(r) {
+						return false
+					}
+					x.Centre = p1
+				}
+				if !r.End(end1) {
+					return false
+				}
+			}
+		case "Bounds":
+			{
+				end1, ok := r.Begin(gbin.ARRAY)
+				if !ok || !r.Skip() {
+					return false
+				}
+				for i1 := range x.Bounds {
+					if !r.More(end1) {
+						return false
+					}
+					if !x.Bounds[i1].gbinDecode(r) {
+						return false
+					}
+				}
+				if !r.End(end1) {
+					return false
+				}
+			}
+		case "Tags":
+			if !r.Nil() {
+				end1, ok := r.Begin(gbin.MAP)
+				if !ok || !r.Skip() || !r.Skip() {
+					return false
+				}
+				x.Tags = make(map[string]Level)
+				for r.More(end1) {
+					var k1 string
+					if !r.String(&k1) {
+						return false
+					}
+					var v1 Level
+					if !r.Int8((*int8)(&v1)) {
+						return false
+					}
+					x.Tags[k1] = v1
+				}
+				if !r.End(end1) {
+					return false
+				}
+			}
+		default:
+			if !r.Skip() {
+				return false
+			}
+		}
+	}
+	return r.End(end)
+}
+
+// MarshalGbin encodes x in the standard format without reflection
+func (x Record) MarshalGbin() ([]byte, error) {
+	w := gbin.NewWriter()
+	x.gbinEncode(w)
+	return w.Bytes()
+}
+
+// UnmarshalGbin decodes data into x without reflection, falling back to
+// reflection for data which is not laid out as Record is encoded
+func (x *Record) UnmarshalGbin(data []byte) error {
+	var v Record
+	r := gbin.NewReader(data)
+	if v.gbinDecode(r) && r.Done() {
+		*x = v
+		return nil
+	}
+	return gbin.Unmarshal(data, (*gbinPlainRecord)(x))
+}
+
+// gbinPlainRecord has the fields of Record without its methods, for decoding with reflection
+type gbinPlainRecord Record
+
+func (x *Record) gbinEncode(w *gbin.Writer) {
+	start := w.Begin(gbin.STRUCT)
+	w.String("Int")
+	w.Int(x.Int)
+	w.String("Uint")
+	w.Uint(x.Uint)
+	w.String("Uintptr")
+	w.Uintptr(x.Uintptr)
+	w.String("Int8")
+	w.Int8(x.Int8)
+	w.String("Int16")
+	w.Int16(x.Int16)
+	w.String("Int32")
+	w.Int32(x.Int32)
+	w.String("Int64")
+	w.Int64(x.Int64)
+	w.String("Uint8")
+	w.Uint8(x.Uint8)
+	w.String("Uint16")
+	w.Uint16(x.Uint16)
+	w.String("Uint32")
+	w.Uint32(x.Uint32)
+	w.String("Uint64")
+	w.Uint64(x.Uint64)
+	w.String("Float32")
+	w.Float32(x.Float32)
+	w.String("Float64")
+	w.Float64(x.Float64)
+	w.String("Complex64")
+	w.Complex64(x.Complex64)
+	w.String("Complex")
+	w.Complex128(x.Complex)
+	w.String("Bool")
+	w.Bool(x.Bool)
+	w.String("String")
+	w.String(x.String)
+	w.String("Bytes")
+	if x.Bytes == nil {
+		w.Nil()
+	} else {
+		start1 := w.Begin(gbin.SLICE)
+		w.Zero(gbinPointZero3)
+		for i1 := range x.Bytes {
+			w.Uint8(x.Bytes[i1])
+		}
+		w.End(start1)
+	}
+	w.String("Temp")
+	w.Float64(float64(x.Temp))
+	w.String("Level")
+	w.Int8(int8(x.Level))
+	w.String("Shapes")
+	if x.Shapes == nil {
+		w.Nil()
+	} else {
+		start1 := w.Begin(gbin.SLICE)
+		w.Zero(gbinPointZero4)
+		for i1 := range x.Shapes {
+			x.Shapes[i1].gbinEncode(w)
+		}
+		w.End(start1)
+	}
+	w.String("Lookup")
+	if x.Lookup == nil {
+		w.Nil()
+	} else {
+		start1 := w.Begin(gbin.MAP)
+		w.Zero(gbinPointZero1)
+		w.Zero(gbinPointZero5)
+		for k1, v1 := range x.Lookup {
+			w.String(k1)
+			{
+				start2 := w.Begin(gbin.PTR)
+				w.Zero(gbinPointZero0)
+				if v1 == nil {
+					w.Nil()
+				} else {
+					p2 := v1
+					p2.gbinEncode(w)
+				}
+				w.End(start2)
+			}
+		}
+		w.End(start1)
+	}
+	w.String("Grid")
+	{
+		start1 := w.Begin(gbin.ARRAY)
+		w.Zero(gbinPointZero6)
+		for i1 := range x.Grid {
+			{
+				start2 := w.Begin(gbin.ARRAY)
+				w.Zero(gbinPointZero7)
+				for i2 := range x.Grid[i1] {
+					w.Int16(x.Grid[i1][i2])
+				}
+				w.End(start2)
+			}
+		}
+		w.End(start1)
+	}
+	w.String("PtrPtr")
+	{
+		start1 := w.Begin(gbin.PTR)
+		w.Zero(gbinPointZero8)
+		if x.PtrPtr == nil {
+			w.Nil()
+		} else {
+			p1 := x.PtrPtr
+			{
+				start2 := w.Begin(gbin.PTR)
+				w.Zero(gbinPointZero9)
+				if *p1 == nil {
+					w.Nil()
+				} else {
+					p2 := *p1
+					w.Int(*p2)
+				}
+				w.End(start2)
+			}
+		}
+		w.End(start1)
+	}
+	w.String("Any")
+	w.Value(&x.Any)
+	w.String("Meta")
+	w.Value(&x.Meta)
+	w.String("When")
+	w.Value(&x.When)
+	w.String("renamed")
+	w.String(x.Renamed)
+	if !(len(x.Optional) == 0) {
+		w.String("Optional")
+		if x.Optional == nil {
+			w.Nil()
+		} else {
+			start1 := w.Begin(gbin.SLICE)
+			w.Zero(gbinPointZero1)
+			for i1 := range x.Optional {
+				w.String(x.Optional[i1])
+			}
+			w.End(start1)
+		}
+	}
+	if !(math.Float64bits(float64(x.Weight)) == 0) {
+		w.String("Weight")
+		w.Float64(x.Weight)
+	}
+	w.String("Limit")
+	w.Int(x.Limit)
+	w.String("Ratio")
+	{
+		start1 := w.Begin(gbin.PTR)
+		w.Zero(gbinPointZero10)
+		if x.Ratio == nil {
+			w.Nil()
+		} else {
+			p1 := x.Ratio
+			w.Float32(*p1)
+		}
+		w.End(start1)
+	}
+	w.String("Label")
+	w.String(x.Label)
+	if !(len(x.Index) == 0) {
+		w.String("Index")
+		if x.Index == nil {
+			w.Nil()
+		} else {
+			start1 := w.Begin(gbin.MAP)
+			w.Zero(gbinPointZero2)
+			w.Zero(gbinPointZero11)
+			for k1, v1 := range x.Index {
+				w.Int8(int8(k1))
+				if v1 == nil {
+					w.Nil()
+				} else {
+					start2 := w.Begin(gbin.SLICE)
+					w.Zero(gbinPointZero0)
+					for i2 := range v1 {
+						v1[i2].gbinEncode(w)
+					}
+					w.End(start2)
+				}
+			}
+			w.End(start1)
+		}
+	}
+	w.End(start)
+}
+
+func (x *Record) gbinDecode(r *gbin.Reader) bool {
+	end, ok := r.Begin(gbin.STRUCT)
+	if !ok {
+		return false
+	}
+	*x = Record{}
+	x.Limit = int(-10)
+	x.Ratio = new(float32)
+	*x.Ratio = float32(math.Float32frombits(0x3dcccccd))
+	x.Label = string("none")
+	var key string
+	for r.More(end) {
+		if !r.String(&key) {
+			return false
+		}
+		switch key {
+		case "Int":
+			if !r.Int(&x.Int) {
+				return false
+			}
+		case "Uint":
+			if !r.Uint(&x.Uint) {
+				return false
+			}
+		case "Uintptr":
+			if !r.Uintptr(&x.Uintptr) {
+				return false
+			}
+		case "Int8":
+			if !r.Int8(&x.Int8) {
+				return false
+			}
+		case "Int16":
+			if !r.Int16(&x.Int16) {
+				return false
+			}
+		case "Int32":
+			if !r.Int32(&x.Int32) {
+				return false
+			}
+		case "Int64":
+			if !r.Int64(&x.Int64) {
+				return false
+			}
+		case "Uint8":
+			if !r.Uint8(&x.Uint8) {
+				return false
+			}
+		case "Uint16":
+			if !r.Uint16(&x.Uint16) {
+				return false
+			}
+		case "Uint32":
+			if !r.Uint32(&x.Uint32) {
+				return false
+			}
+		case "Uint64":
+			if !r.Uint64(&x.Uint64) {
+				return false
+			}
+		case "Float32":
+			if !r.Float32(&x.Float32) {
+				return false
+			}
+		case "Float64":
+			if !r.Float64(&x.Float64) {
+				return false
+			}
+		case "Complex64":
+			if !r.Complex64(&x.Complex64) {
+				return false
+			}
+		case "Complex":
+			if !r.Complex128(&x.Complex) {
+				return false
+			}
+		case "Bool":
+			if !r.Bool(&x.Bool) {
+				return false
+			}
+		case "String":
+			if !r.String(&x.String) {
+				return false
+			}
+		case "Bytes":
+			if !r.Nil() {
+				end1, ok := r.Begin(gbin.SLICE)
+				if !ok || !r.Skip() {
+					return false
+				}
+				x.Bytes = make([]byte, 0)
+				for r.More(end1) {
+					var el1 byte
+					if !r.Uint8(&el1) {
+						return false
+					}
+					x.Bytes = append(x.Bytes, el1)
+				}
+				if !r.End(end1) {
+					return false
+				}
+			}
+		case "Temp":
+			if !r.Float64((*float64)(&x.Temp)) {
+				return false
+			}
+		case "Level":
+			if !r.Int8((*int8)(&x.Level)) {
+				return false
+			}
+		case "Shapes":
+			if !r.Nil() {
+				end1, ok := r.Begin(gbin.SLICE)
+				if !ok || !r.Skip() {
+					return false
+				}
+				x.Shapes = make([]Shape, 0)
+				for r.More(end1) {
+					var el1 Shape
+					if !el1.gbinDecode(r) {
+						return false
+					}
+					x.Shapes = append(x.Shapes, el1)
+				}
+				if !r.End(end1) {
+					return false
+				}
+			}
+		case "Lookup":
+			if !r.Nil() {
+				end1, ok := r.Begin(gbin.MAP)
+				if !ok || !r.Skip() || !r.Skip() {
+					return false
+				}
+				x.Lookup = make(map[string]*Point)
+				for r.More(end1) {
+					var k1 string
+					if !r.String(&k1) {
+						return false
+					}
+					var v1 *Point
+					{
+						end2, ok := r.Begin(gbin.PTR)
+						if !ok || !r.Skip() {
+							return false
+						}
+						if !r.Nil() {
+							p2 := new(Point)
+							if !p2.gbinDecode(r) {
+								return false
+							}
+							v1 = p2
+						}
+						if !r.End(end2) {
+							return false
+						}
+					}
+					x.Lookup[k1] = v1
+				}
+				if !r.End(end1) {
+					return false
+				}
+			}
+		case "Grid":
+			{
+				end1, ok := r.Begin(gbin.ARRAY)
+				if !ok || !r.Skip() {
+					return false
+				}
+				for i1 := range x.Grid {
+					if !r.More(end1) {
+						return false
+					}
+					{
+						end2, ok := r.Begin(gbin.ARRAY)
+						if !ok || !r.Skip() {
+							return false
+						}
+						for i2 := range x.Grid[i1] {
+							if !r.More(end2) {
+								return false
+							}
+							if !r.Int16(&x.Grid[i1][i2]) {
+								return false
+							}
+						}
+						if !r.End(end2) {
+							return false
+						}
+					}
+				}
+				if !r.End(end1) {
+					return false
+				}
+			}
+		case "PtrPtr":
+			{
+				end1, ok := r.Begin(gbin.PTR)
+				if !ok || !r.Skip() {
+					return false
+				}
+				if !r.Nil() {
+					p1 := new(*int)
+					{
+						end2, ok := r.Begin(gbin.PTR)
+						if !ok || !r.Skip() {
+							return false
+						}
+						if !r.Nil() {
+							p2 := new(int)
+							if !r.Int(p2) {
+								return false
+							}
+							*p1 = p2
+						}
+						if !r.End(end2) {
+							return false
+						}
+					}
+					x.PtrPtr = p1
+				}
+				if !r.End(end1) {
+					return false
+				}
+			}
+		case "Any":
+			if !r.Value(&x.Any) {
+				return false
+			}
+		case "Meta":
+			if !r.Value(&x.Meta) {
+				return false
+			}
+		case "When":
+			if !r.Value(&x.When) {
+				return false
+			}
+		case "renamed", "OldName":
+			if !r.String(&x.Renamed) {
+				return false
+			}
+		case "Optional":
+			if !r.Nil() {
+				end1, ok := r.Begin(gbin.SLICE)
+				if !ok || !r.Skip() {
+					return false
+				}
+				x.Optional = make([]string, 0)
+				for r.More(end1) {
+					var el1 string
+					if !r.String(&el1) {
+						return false
+					}
+					x.Optional = append(x.Optional, el1)
+				}
+				if !r.End(end1) {
+					return false
+				}
+			}
+		case "Weight":
+			if !r.Float64(&x.Weight) {
+				return false
+			}
+		case "Limit":
+			if !r.Int(&x.Limit) {
+				return false
+			}
+		case "Ratio":
+			{
+				end1, ok := r.Begin(gbin.PTR)
+				if !ok || !r.Skip() {
+					return false
+				}
+				if !r.Nil() {
+					p1 := new(float32)
+					if !r.Float32(p1) {
+						return false
+					}
+					x.Ratio = p1
+				}
+				if !r.End(end1) {
+					return false
+				}
+			}
+		case "Label":
+			if !r.String(&x.Label) {
+				return false
+			}
+		case "Index":
+			if !r.Nil() {
+				end1, ok := r.Begin(gbin.MAP)
+				if !ok || !r.Skip() || !r.Skip() {
+					return false
+				}
+				x.Index = make(map[Level][]Point)
+				for r.More(end1) {
+					var k1 Level
+					if !r.Int8((*int8)(&k1)) {
+						return false
+					}
+					var v1 []Point
+					if !r.Nil() {
+						end2, ok := r.Begin(gbin.SLICE)
+						if !ok || !r.Skip() {
+							return false
+						}
+						v1 = make([]Point, 0)
+						for r.More(end2) {
+							var el2 Point
+							if !el2.gbinDecode(r) {
+								return false
+							}
+							v1 = append(v1, el2)
+						}
+						if !r.End(end2) {
+							return false
+						}
+					}
+					x.Index[k1] = v1
+				}
+				if !r.End(end1) {
+					return false
+				}
+			}
+		default:
+			if !r.Skip() {
+				return false
+			}
+		}
+	}
+	return r.End(end)
+}
+
+// MarshalGbin encodes x in the standard format without reflection
+func (x Entry) MarshalGbin() ([]byte, error) {
+	w := gbin.NewWriter()
+	x.gbinEncode(w)
+	return w.Bytes()
+}
+
+// UnmarshalGbin decodes data into x without reflection, falling back to
+// reflection for data which is not laid out as Entry is encoded
+func (x *Entry) UnmarshalGbin(data []byte) error {
+	var v Entry
+	r := gbin.NewReader(data)
+	if v.gbinDecode(r) && r.Done() {
+		*x = v
+		return nil
+	}
+	return gbin.Unmarshal(data, (*gbinPlainEntry)(x))
+}
+
+// gbinPlainEntry has the fields of Entry without its methods, for decoding with reflection
+type gbinPlainEntry Entry
+
+func (x *Entry) gbinEncode(w *gbin.Writer) {
+	start := w.Begin(gbin.STRUCT)
+	w.String("Version")
+	w.Int(x.Stamp.Version)
+	if x.Meta != nil {
+		w.String("Owner")
+		w.String(x.Meta.Owner)
+	}
+	if x.Meta != nil {
+		w.String("Created")
+		w.Value(&x.Meta.Created)
+	}
+	if x.Limits != nil {
+		w.String("Max")
+		w.Int(x.Limits.Max)
+	}
+	w.String("extra")
+	w.Value(&x.Extra)
+	w.String("Note")
+	w.String(x.Note)
+	w.End(start)
+}
+
+func (x *Entry) gbinDecode(r *gbin.Reader) bool {
+	end, ok := r.Begin(gbin.STRUCT)
+	if !ok {
+		return false
+	}
+	*x = Entry{}
+	if x.Limits == nil {
+		x.Limits = new(Limits)
+	}
+	x.Limits.Max = int(3)
+	var key string
+	for r.More(end) {
+		if !r.String(&key) {
+			return false
+		}
+		switch key {
+		case "Version":
+			if !r.Int(&x.Stamp.Version) {
+				return false
+			}
+		case "Owner":
+			if x.Meta == nil {
+				x.Meta = new(Meta)
+			}
+			if !r.String(&x.Meta.Owner) {
+				return false
+			}
+		case "Created":
+			if x.Meta == nil {
+				x.Meta = new(Meta)
+			}
+			if !r.Value(&x.Meta.Created) {
+				return false
+			}
+		case "Max":
+			if x.Limits == nil {
+				x.Limits = new(Limits)
+			}
+			if !r.Int(&x.Limits.Max) {
+				return false
+			}
+		case "extra":
+			if !r.Value(&x.Extra) {
+				return false
+			}
+		case "Note":
+			if !r.String(&x.Note) {
+				return false
+			}
+		case "Stamp", "Meta", "Limits":
+			return false
+		default:
+			if !r.Skip() {
+				return false
+			}
+		}
+	}
+	return r.End(end)
+}
@@ -0,0 +1,286 @@
+package gentest_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+	"github.com/lspaccatrosi16/go-libs/gbin/internal/gentest"
+)
+
+// the plain types have the fields of the generated types without their
+// methods, so are encoded by reflection
+type (
+	plainPoint  gentest.Point
+	plainShape  gentest.Shape
+	plainRecord gentest.Record
+	plainEntry  gentest.Entry
+)
+
+func samplePoint() gentest.Point {
+	return gentest.Point{X: -3, Y: 7}
+}
+
+func sampleShape() gentest.Shape {
+	return gentest.Shape{
+		Name:     "triangle",
+		Vertices: []gentest.Point{{0, 0}, {4, 0}, {0, 3}},
+		Centre:   &gentest.Point{X: 1, Y: 1},
+		Bounds:   [2]gentest.Point{{0, 0}, {4, 3}},
+		Tags:     map[string]gentest.Level{"colour": 2},
+	}
+}
+
+func sampleRecord() gentest.Record {
+	n := 12
+	ptr := &n
+	ratio := float32(0.5)
+	return gentest.Record{
+		Int:       -1 << 30,
+		Uint:      1 << 30,
+		Uintptr:   0xdead,
+		Int8:      -8,
+		Int16:     -16,
+		Int32:     -32,
+		Int64:     -64,
+		Uint8:     8,
+		Uint16:    16,
+		Uint32:    32,
+		Uint64:    64,
+		Float32:   1.5,
+		Float64:   -2.25,
+		Complex64: complex(1, -1),
+		Complex:   complex(-3, 4),
+		Bool:      true,
+		String:    "hello",
+		Bytes:     []byte{1, 2, 3},
+		Temp:      21.5,
+		Level:     3,
+		Shapes:    []gentest.Shape{sampleShape(), {}},
+		Lookup:    map[string]*gentest.Point{"origin": {}},
+		Grid:      [2][2]int16{{1, 2}, {3, 4}},
+		PtrPtr:    &ptr,
+		Any:       []any{"a", int8(1)},
+		Meta:      gentest.Meta{Owner: "me", Created: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)},
+		When:      time.Date(2023, 6, 7, 8, 9, 10, 0, time.UTC),
+		Renamed:   "renamed",
+		Weight:    80,
+		Limit:     5,
+		Ratio:     &ratio,
+		Label:     "label",
+		Index:     map[gentest.Level][]gentest.Point{1: {{1, 2}}},
+	}
+}
+
+// checkGenerated checks that the generated methods of v encode it exactly as
+// the reflective encoder encodes plain, and decode it exactly as the
+// reflective decoder does
+func checkGenerated[T any, P any](t *testing.T, v T, plain P) {
+	t.Helper()
+	generated, err := any(v).(gbin.Marshaler).MarshalGbin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reflective, err := gbin.Marshal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generated, reflective) {
+		t.Fatalf("generated encoding of %T differs from reflective encoding\n%v\n%v", v, generated, reflective)
+	}
+	var decoded T
+	err = any(&decoded).(gbin.Unmarshaler).UnmarshalGbin(generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decodedPlain P
+	err = gbin.Unmarshal(reflective, &decodedPlain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reflect.ValueOf(decodedPlain).Convert(reflect.TypeOf(decoded)).Interface(), decoded) {
+		t.Fatalf("expected %+v, got %+v", decodedPlain, decoded)
+	}
+}
+
+func TestEquivalence(t *testing.T) {
+	checkGenerated(t, samplePoint(), plainPoint(samplePoint()))
+	checkGenerated(t, gentest.Point{}, plainPoint{})
+	checkGenerated(t, sampleShape(), plainShape(sampleShape()))
+	checkGenerated(t, gentest.Shape{}, plainShape{})
+	emptyShape := gentest.Shape{Vertices: []gentest.Point{}, Tags: map[string]gentest.Level{}}
+	checkGenerated(t, emptyShape, plainShape(emptyShape))
+	checkGenerated(t, sampleRecord(), plainRecord(sampleRecord()))
+	empty := gentest.Record{Ratio: new(float32)}
+	checkGenerated(t, empty, plainRecord(empty))
+	checkGenerated(t, sampleEntry(), plainEntry(sampleEntry()))
+	checkGenerated(t, gentest.Entry{}, plainEntry{})
+}
+
+func sampleEntry() gentest.Entry {
+	return gentest.Entry{
+		Stamp:  gentest.Stamp{Version: 2, Note: "shadowed"},
+		Meta:   &gentest.Meta{Owner: "me"},
+		Limits: &gentest.Limits{Max: 10},
+		Extra:  gentest.Extra{N: 1},
+		Note:   "note",
+	}
+}
+
+func TestDecodeNestedEmbedding(t *testing.T) {
+	// embedded structs encoded as fields fall back to the reflective decoder
+	encoded, err := gbin.Marshal(struct {
+		Stamp gentest.Stamp
+		Meta  *gentest.Meta
+		Note  string
+	}{gentest.Stamp{Version: 3}, &gentest.Meta{Owner: "me"}, "note"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generated gentest.Entry
+	err = generated.UnmarshalGbin(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generated.Version != 3 || generated.Meta == nil || generated.Owner != "me" || generated.Max != 3 {
+		t.Fatalf("unexpected decoded value %+v", generated)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	record := sampleRecord()
+	encoded, err := gbin.NewEncoder[gentest.Record](gbin.WithCompact()).Encode(&record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[gentest.Record]().Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := record.MarshalGbin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := decoded.MarshalGbin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("expected %+v, got %+v", record, *decoded)
+	}
+}
+
+// checkDecode checks that the generated decoder decodes data exactly as the
+// reflective decoder does
+func checkDecode(t *testing.T, data any) {
+	t.Helper()
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generated gentest.Record
+	generatedErr := generated.UnmarshalGbin(encoded)
+	var reflective plainRecord
+	reflectiveErr := gbin.Unmarshal(encoded, &reflective)
+	if (generatedErr == nil) != (reflectiveErr == nil) {
+		t.Fatalf("generated decoder returned %v but reflective decoder returned %v", generatedErr, reflectiveErr)
+	}
+	if !reflect.DeepEqual(generated, gentest.Record(reflective)) {
+		t.Fatalf("expected %+v, got %+v", reflective, generated)
+	}
+}
+
+func TestDecodeOtherLayouts(t *testing.T) {
+	// missing fields take their defaults
+	checkDecode(t, struct{}{})
+	// fields are matched by name and alias in any order, skipping unknown ones
+	checkDecode(t, struct {
+		OldName string
+		Unknown []int
+		Int8    int8
+	}{"old", []int{1}, 4})
+	// fields of another type fall back to the reflective decoder
+	checkDecode(t, struct {
+		Int32 int64
+		Level int64
+	}{1, 2})
+	checkDecode(t, struct{ Shapes []plainShape }{[]plainShape{{Name: "a"}}})
+	// as do invalid values
+	checkDecode(t, struct{ Int8 string }{"a"})
+}
+
+func TestJSON(t *testing.T) {
+	// types with generated methods are converted from JSON field by field
+	record := sampleRecord()
+	record.Any = []any{"a", int64(1)}
+	encoded, err := record.MarshalGbin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := gbin.ToJSON(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reencoded, err := gbin.FromJSON[gentest.Record](converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := gbin.NewDecoder[gentest.Record]().Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[gentest.Record]().Decode(reencoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("expected %+v, got %+v", *expected, *decoded)
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	record := sampleRecord()
+	record.Any, record.When, record.Meta = nil, time.Time{}, gentest.Meta{}
+	b.Run("generated", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := record.MarshalGbin(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("reflective", func(b *testing.B) {
+		plain := plainRecord(record)
+		for i := 0; i < b.N; i++ {
+			if _, err := gbin.Marshal(plain); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	record := sampleRecord()
+	record.Any, record.When, record.Meta = nil, time.Time{}, gentest.Meta{}
+	encoded, err := record.MarshalGbin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("generated", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var decoded gentest.Record
+			if err := decoded.UnmarshalGbin(encoded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("reflective", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var decoded plainRecord
+			if err := gbin.Unmarshal(encoded, &decoded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
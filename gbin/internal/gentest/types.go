@@ -0,0 +1,88 @@
+// Package gentest holds types with methods generated by gbingen, for testing
+// that they encode identically to the reflective encoder.
+package gentest
+
+import "time"
+
+//go:generate go run ../../../cmd/gbingen -type Point,Shape,Record,Entry -output gen_gbin.go
+
+type Celsius float64
+
+type Level int8
+
+type Point struct {
+	X, Y int32
+}
+
+type Shape struct {
+	Name     string
+	Vertices []Point
+	Centre   *Point
+	Bounds   [2]Point
+	Tags     map[string]Level
+}
+
+type Meta struct {
+	Owner   string
+	Created time.Time
+}
+
+type Record struct {
+	Int        int
+	Uint       uint
+	Uintptr    uintptr
+	Int8       int8
+	Int16      int16
+	Int32      int32
+	Int64      int64
+	Uint8      uint8
+	Uint16     uint16
+	Uint32     uint32
+	Uint64     uint64
+	Float32    float32
+	Float64    float64
+	Complex64  complex64
+	Complex    complex128
+	Bool       bool
+	String     string
+	Bytes      []byte
+	Temp       Celsius
+	Level      Level
+	Shapes     []Shape
+	Lookup     map[string]*Point
+	Grid       [2][2]int16
+	PtrPtr     **int
+	Any        any
+	Meta       Meta
+	When       time.Time
+	Renamed    string            `gbin:"renamed,alias=OldName"`
+	Optional   []string          `gbin:",omitempty"`
+	Weight     float64           `gbin:",omitempty"`
+	Limit      int               `gbin:",default=-10"`
+	Ratio      *float32          `gbin:",default=0.1"`
+	Label      string            `gbin:",default=none"`
+	Skipped    int               `gbin:"-"`
+	Index      map[Level][]Point `gbin:",omitempty"`
+	unexported int
+}
+
+type Stamp struct {
+	Version int
+	Note    string `gbin:",omitempty"`
+}
+
+type Limits struct {
+	Max int `gbin:",default=3"`
+}
+
+type Extra struct {
+	N int
+}
+
+type Entry struct {
+	Stamp
+	*Meta
+	*Limits
+	Extra `gbin:"extra"`
+	Note  string
+}
@@ -0,0 +1,153 @@
+package gbin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// FromJSON converts JSON into the encoding of a value of type T, so that test
+// fixtures and other data can be written as JSON. Objects are read into structs
+// by encoded field name, or by json tag with WithJSONTags, and into maps with
+// keys written as ToJSON writes them. Numbers are read exactly, so integers
+// keep their full precision, and an integer which does not fit its field is an
+// error, as is a field T does not have. Types with their own encoding are read
+// from the JSON ToJSON writes for them: text marshalers from strings, binary
+// marshalers and codecs from base64 strings, and Marshalers from the value they
+// encode, or field by field for structs with methods generated by gbingen.
+// Values held in interfaces are read as int64, float64, string, bool, []any and
+// map[string]any.
+func FromJSON[T any](data []byte, opts ...Option) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var tree any
+	err := dec.Decode(&tree)
+	if err == nil && dec.Decode(new(any)) != io.EOF {
+		err = fmt.Errorf("JSON is followed by more data")
+	}
+	if err != nil {
+		return nil, wrapEncode(err)
+	}
+	var value T
+	return encodeTree(reflect.ValueOf(&value).Elem(), tree, newOptions(opts))
+}
+
+// ToJSON converts encoded data to JSON, reversing FromJSON. The data is
+// decoded as DecodeDynamic decodes it, so no type is needed. Map keys which
+// are not strings are written as strings, as are floats which are not finite
+// numbers and complex numbers; integers are written in full.
+func ToJSON(data []byte, opts ...Option) ([]byte, error) {
+	tree, err := DecodeDynamic(data, opts...)
+	if err != nil {
+		return nil, err
+	}
+	converted, err := newJSONConverter().convert(tree)
+	if err != nil {
+		return nil, wrapDecode(err)
+	}
+	out, err := json.Marshal(converted)
+	if err != nil {
+		return nil, wrapDecode(err)
+	}
+	return out, nil
+}
+
+// jsonConverter converts generic trees decoded from gbin to values which
+// encoding/json can represent and FromJSON and EncodeDynamic can read back
+type jsonConverter struct {
+	// active holds the containers being converted, to reject cycles
+	active map[uintptr]bool
+}
+
+func newJSONConverter() *jsonConverter {
+	return &jsonConverter{active: map[uintptr]bool{}}
+}
+
+func (c *jsonConverter) enter(node any) error {
+	ptr := reflect.ValueOf(node).Pointer()
+	if c.active[ptr] {
+		return fmt.Errorf("data contains a cycle, which cannot be converted to JSON")
+	}
+	c.active[ptr] = true
+	return nil
+}
+
+func (c *jsonConverter) leave(node any) {
+	delete(c.active, reflect.ValueOf(node).Pointer())
+}
+
+// convert converts tree, writing map keys which are not strings and numbers
+// which JSON cannot hold as strings
+func (c *jsonConverter) convert(tree any) (any, error) {
+	switch node := tree.(type) {
+	case map[string]any:
+		err := c.enter(node)
+		if err != nil {
+			return nil, err
+		}
+		out := make(map[string]any, len(node))
+		for k, v := range node {
+			out[k], err = c.convert(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		c.leave(node)
+		return out, nil
+	case map[any]any:
+		err := c.enter(node)
+		if err != nil {
+			return nil, err
+		}
+		out := make(map[string]any, len(node))
+		for k, v := range node {
+			key, err := c.convert(k)
+			if err != nil {
+				return nil, err
+			}
+			out[fmt.Sprint(key)], err = c.convert(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		c.leave(node)
+		return out, nil
+	case []any:
+		err := c.enter(node)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]any, len(node))
+		for i, el := range node {
+			out[i], err = c.convert(el)
+			if err != nil {
+				return nil, err
+			}
+		}
+		c.leave(node)
+		return out, nil
+	case float32:
+		return convertFloat(float64(node), node), nil
+	case float64:
+		return convertFloat(node, node), nil
+	case complex64:
+		return strconv.FormatComplex(complex128(node), 'g', -1, 64), nil
+	case complex128:
+		return strconv.FormatComplex(node, 'g', -1, 128), nil
+	default:
+		return tree, nil
+	}
+}
+
+// convertFloat returns v, which is f in its original type, or a string if it
+// is not a finite number
+func convertFloat(f float64, v any) any {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return v
+}
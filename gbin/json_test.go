@@ -0,0 +1,227 @@
+package gbin_test
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+type jsonBase struct {
+	Created string `json:"created"`
+}
+
+type jsonTagged struct {
+	jsonBase
+	ID      int64             `json:"id"`
+	Name    string            `json:"name,omitempty"`
+	Secret  string            `json:"-"`
+	Dash    int               `json:"-,"`
+	Both    int               `json:"json_both" gbin:"gbin_both"`
+	Plain   bool              `json:",string"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Nested  *jsonBase         `json:"nested"`
+	Skipped int               `gbin:"-"`
+}
+
+func TestJSONTags(t *testing.T) {
+	data := jsonTagged{
+		jsonBase: jsonBase{"today"},
+		ID:       1,
+		Secret:   "hidden",
+		Dash:     2,
+		Both:     3,
+		Plain:    true,
+		Nested:   &jsonBase{"yesterday"},
+	}
+	for _, opts := range [][]gbin.Option{{gbin.WithJSONTags()}, {gbin.WithJSONTags(), gbin.WithCompact()}} {
+		encoded, err := gbin.NewEncoder[jsonTagged](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := gbin.DecodeDynamic(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := []string{}
+		for k := range tree.(map[string]any) {
+			keys = append(keys, k)
+		}
+		expected := []string{"-", "Plain", "created", "gbin_both", "id", "nested"}
+		if !equalKeys(keys, expected) {
+			t.Fatalf("expected fields %v, got %v", expected, keys)
+		}
+		decoded, err := gbin.NewDecoder[jsonTagged](opts...).Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data.Secret = ""
+		if !reflect.DeepEqual(*decoded, data) {
+			t.Fatalf("expected %+v, got %+v", data, *decoded)
+		}
+		data.Secret = "hidden"
+	}
+
+	// without the option, json tags are ignored
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := gbin.DecodeDynamic(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tree.(map[string]any)["Secret"]; !ok {
+		t.Fatalf("expected json tags to be ignored, got %v", tree)
+	}
+}
+
+// equalKeys reports whether keys holds the same names as expected
+func equalKeys(keys, expected []string) bool {
+	if len(keys) != len(expected) {
+		return false
+	}
+	for _, e := range expected {
+		found := false
+		for _, k := range keys {
+			found = found || k == e
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type fixture struct {
+	Big     int64
+	Max     uint64
+	Small   int8
+	Ratio   float32
+	Wave    complex128
+	NaN     float64
+	Blob    []byte
+	When    time.Time
+	Counts  map[int16]string
+	Corners map[[2]int]bool
+	Any     any
+	Ptr     **int
+	Label   string `gbin:"label,alias=OldLabel"`
+}
+
+func TestFromJSON(t *testing.T) {
+	input := `{
+		"Big": 9007199254740993,
+		"Max": 18446744073709551615,
+		"Small": -128,
+		"Ratio": 0.1,
+		"Wave": "(1+2i)",
+		"NaN": "NaN",
+		"Blob": "AQID",
+		"When": "AQAAAA7dJXQlAAAAAP//",
+		"Counts": {"-3": "minus three"},
+		"Corners": {"[1,2]": true},
+		"Any": [9007199254740993, 1.5, {"a": null}],
+		"Ptr": 4,
+		"OldLabel": "old"
+	}`
+	encoded, err := gbin.FromJSON[fixture]([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[fixture]().Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	four := 4
+	ptr := &four
+	expected := fixture{
+		Big:     9007199254740993,
+		Max:     math.MaxUint64,
+		Small:   -128,
+		Ratio:   0.1,
+		Wave:    complex(1, 2),
+		Blob:    []byte{1, 2, 3},
+		When:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Counts:  map[int16]string{-3: "minus three"},
+		Corners: map[[2]int]bool{{1, 2}: true},
+		Any:     []any{int64(9007199254740993), 1.5, map[string]any{"a": nil}},
+		Ptr:     &ptr,
+		Label:   "old",
+	}
+	if !math.IsNaN(decoded.NaN) {
+		t.Fatalf("expected NaN, got %v", decoded.NaN)
+	}
+	decoded.NaN = 0
+	if !reflect.DeepEqual(*decoded, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, *decoded)
+	}
+
+	// converting back to JSON keeps integers exact
+	converted, err := gbin.ToJSON(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, number := range []string{`"Big":9007199254740993`, `"Max":18446744073709551615`, `"label":"old"`} {
+		if !strings.Contains(string(converted), number) {
+			t.Fatalf("expected %s in %s", number, converted)
+		}
+	}
+	again, err := gbin.FromJSON[fixture](converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redecoded, err := gbin.NewDecoder[fixture]().Decode(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redecoded.NaN = 0
+	if !reflect.DeepEqual(*redecoded, expected) {
+		t.Fatalf("expected %+v after converting to JSON and back, got %+v", expected, *redecoded)
+	}
+}
+
+func TestFromJSONTags(t *testing.T) {
+	input := `{"id": 9007199254740993, "gbin_both": 1, "-": 2, "nested": {"created": "now"}}`
+	encoded, err := gbin.FromJSON[jsonTagged]([]byte(input), gbin.WithJSONTags(), gbin.WithCompact())
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[jsonTagged](gbin.WithJSONTags()).Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := jsonTagged{ID: 9007199254740993, Both: 1, Dash: 2, Nested: &jsonBase{"now"}}
+	if !reflect.DeepEqual(*decoded, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, *decoded)
+	}
+	converted, err := gbin.ToJSON(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tree map[string]any
+	if err := json.Unmarshal(converted, &tree); err != nil || tree["id"] == nil || tree["created"] != "" {
+		t.Fatalf("expected fields named by json tags, got %s (%v)", converted, err)
+	}
+}
+
+func TestFromJSONErrors(t *testing.T) {
+	for _, input := range []string{
+		`{"Small": 128}`,
+		`{"Small": 1.5}`,
+		`{"Big": 9223372036854775808}`,
+		`{"Unknown": 1}`,
+		`{"Blob": "not base64"}`,
+		`{"When": 5}`,
+		`{"Big": 1} {}`,
+		`{"Big": `,
+	} {
+		if _, err := gbin.FromJSON[fixture]([]byte(input)); err == nil {
+			t.Fatalf("expected an error converting %s", input)
+		}
+	}
+}
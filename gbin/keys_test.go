@@ -0,0 +1,185 @@
+package gbin_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+	"github.com/lspaccatrosi16/go-libs/structures/cartesian"
+	"github.com/lspaccatrosi16/go-libs/structures/mpq"
+	"github.com/lspaccatrosi16/go-libs/structures/set"
+)
+
+type pointKey struct {
+	X, Y int
+}
+
+type labelKey struct {
+	Name  string
+	Point pointKey
+	Tags  [2]string
+}
+
+type keyRecord struct {
+	Points map[pointKey]string
+	Labels map[labelKey][]int
+	Cells  map[cartesian.Coordinate]bool
+	Pairs  map[[2]string]float64
+}
+
+func TestCompositeKeys(t *testing.T) {
+	data := keyRecord{
+		Points: map[pointKey]string{{1, 2}: "a", {-1, 0}: "b"},
+		Labels: map[labelKey][]int{{"l", pointKey{3, 4}, [2]string{"x", "y"}}: {1, 2}},
+		Cells:  map[cartesian.Coordinate]bool{{0, 0}: true, {5, -5}: false},
+		Pairs:  map[[2]string]float64{{"a", "b"}: 1.5},
+	}
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}, {gbin.WithCanonical()}} {
+		if !runTest(data, opts...) {
+			t.Fatalf("failed to round trip composite keys with %d options", len(opts))
+		}
+	}
+
+	encoded, err := gbin.Marshal(data.Points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var untyped any
+	err = gbin.Unmarshal(encoded, &untyped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := reflect.ValueOf(untyped); v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.Struct || v.Len() != 2 {
+		t.Fatalf("expected a map with struct keys, got %#v", untyped)
+	}
+}
+
+func TestPointerKeys(t *testing.T) {
+	a, b := 1, 2
+	data := map[*int]string{&a: "a", &b: "b"}
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[map[*int]string]().Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[int]string{}
+	for k, v := range *decoded {
+		found[*k] = v
+	}
+	if !reflect.DeepEqual(found, map[int]string{1: "a", 2: "b"}) {
+		t.Fatalf("expected keys pointing to 1 and 2, got %v", found)
+	}
+}
+
+func TestStructureTypes(t *testing.T) {
+	grid := cartesian.CoordinateGrid[string]{}
+	grid.Add(cartesian.Coordinate{0, 1}, "a")
+	grid.Add(cartesian.Coordinate{2, 1}, "b")
+	queue := mpq.Queue[string]{}
+	queue.Add("later", 5)
+	queue.Add("sooner", 1)
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}} {
+		if !runTest(grid, opts...) || !runTest(queue, opts...) {
+			t.Fatal("failed to round trip a grid or queue")
+		}
+		s := set.NewSet[cartesian.Coordinate]()
+		s.Add(cartesian.Coordinate{1, 1}, cartesian.Coordinate{2, 3})
+		encoded, err := gbin.NewEncoder[set.Set[cartesian.Coordinate]](opts...).Encode(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := gbin.NewDecoder[set.Set[cartesian.Coordinate]]().Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !decoded.Contains(cartesian.Coordinate{1, 1}) || !decoded.Contains(cartesian.Coordinate{2, 3}) || decoded.Contains(cartesian.Coordinate{0, 0}) {
+			t.Fatal("decoded set does not hold the values added")
+		}
+		// a set which never had values added decodes without a map, which
+		// adding to creates
+		encoded, err = gbin.NewEncoder[set.Set[int]](opts...).Encode(&set.Set[int]{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		empty, err := gbin.NewDecoder[set.Set[int]]().Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		empty.Add(1)
+		if !empty.Contains(1) {
+			t.Fatal("expected a value added to a decoded set to be held")
+		}
+	}
+
+	a, b := set.NewSet[int](), set.NewSet[int]()
+	for i := 0; i < 20; i++ {
+		a.Add(i)
+		b.Add(19 - i)
+	}
+	ha, err := gbin.Hash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb, err := gbin.Hash(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ha != hb {
+		t.Fatal("expected equal sets to hash the same")
+	}
+}
+
+func TestDynamicCompositeKeys(t *testing.T) {
+	data := keyRecord{
+		Points: map[pointKey]string{{1, 2}: "a"},
+		Cells:  map[cartesian.Coordinate]bool{{3, 4}: true},
+	}
+	encoded, err := gbin.NewEncoder[keyRecord](gbin.WithSchema()).Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := gbin.ReadSchema(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := gbin.DecodeDynamic(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := tree.(map[string]any)["Points"].(map[any]any)
+	if points[gbin.CompositeKey(`{"X":1,"Y":2}`)] != "a" {
+		t.Fatalf("expected a composite key, got %v", points)
+	}
+	reencoded, err := gbin.EncodeDynamic(tree, schema, gbin.WithSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, reencoded) {
+		t.Fatalf("expected % x, got % x", encoded, reencoded)
+	}
+
+	input := `{"Points": {"{\"X\": 5, \"Y\": 6}": "b"}, "Cells": {"[7, 8]": true}}`
+	dec := json.NewDecoder(bytes.NewReader([]byte(input)))
+	dec.UseNumber()
+	var jsonTree any
+	err = dec.Decode(&jsonTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := gbin.EncodeDynamic(jsonTree, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[keyRecord]().Decode(fromJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Points[pointKey{5, 6}] != "b" || !decoded.Cells[cartesian.Coordinate{7, 8}] {
+		t.Fatalf("unexpected decoded value %#v", decoded)
+	}
+}
@@ -0,0 +1,284 @@
+package gbin_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+func TestMaxDepth(t *testing.T) {
+	data := [][][]int{{{1}}}
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gbin.NewDecoder[[][][]int](gbin.WithMaxDepth(4)).Decode(encoded); err != nil {
+		t.Fatal(err)
+	}
+	_, err = gbin.NewDecoder[[][][]int](gbin.WithMaxDepth(3)).Decode(encoded)
+	if !errors.Is(err, gbin.ErrLimitExceeded) {
+		t.Fatalf("expected the depth limit to be exceeded, got %v", err)
+	}
+
+	// without a target type, nesting is limited through interfaces
+	var nested any = 1
+	for i := 0; i < 100; i++ {
+		nested = []any{nested}
+	}
+	holder := struct{ Value any }{nested}
+	encoded, err = gbin.Marshal(holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gbin.NewDecoder[struct{ Value any }](gbin.WithMaxDepth(50)).Decode(encoded)
+	if !errors.Is(err, gbin.ErrLimitExceeded) {
+		t.Fatalf("expected the depth limit to be exceeded, got %v", err)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	data := make([]int, 100)
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gbin.NewDecoder[[]int](gbin.WithMaxSize(uint64(len(encoded)))).Decode(encoded); err != nil {
+		t.Fatal(err)
+	}
+	_, err = gbin.NewDecoder[[]int](gbin.WithMaxSize(uint64(len(encoded) - 1))).Decode(encoded)
+	if !errors.Is(err, gbin.ErrLimitExceeded) {
+		t.Fatalf("expected the size limit to be exceeded, got %v", err)
+	}
+}
+
+func TestMaxLength(t *testing.T) {
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}} {
+		data := map[string][]int{"a": {1, 2, 3}}
+		encoded, err := gbin.NewEncoder[map[string][]int](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gbin.NewDecoder[map[string][]int](gbin.WithMaxLength(3)).Decode(encoded); err != nil {
+			t.Fatal(err)
+		}
+		_, err = gbin.NewDecoder[map[string][]int](gbin.WithMaxLength(2)).Decode(encoded)
+		if !errors.Is(err, gbin.ErrLimitExceeded) {
+			t.Fatalf("expected the length limit to be exceeded, got %v", err)
+		}
+		_, err = gbin.NewDecoder[struct{ Value any }](gbin.WithMaxLength(2)).Decode(wrapAny(t, data, opts))
+		if !errors.Is(err, gbin.ErrLimitExceeded) {
+			t.Fatalf("expected the length limit to be exceeded without a target type, got %v", err)
+		}
+	}
+}
+
+func wrapAny(t *testing.T, v any, opts []gbin.Option) []byte {
+	holder := struct{ Value any }{v}
+	encoded, err := gbin.NewEncoder[struct{ Value any }](opts...).Encode(&holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestErrorOffset(t *testing.T) {
+	data := []string{"a", "b"}
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gbin.NewDecoder[[]int]().Decode(encoded)
+	var decodeErr *gbin.Error
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected a *gbin.Error, got %T", err)
+	}
+	if decodeErr.Offset == 0 || decodeErr.Offset > uint64(len(encoded)) {
+		t.Fatalf("offset %d is outside of the %d bytes of input", decodeErr.Offset, len(encoded))
+	}
+}
+
+func TestLeftoverPayload(t *testing.T) {
+	x := 5
+	encoded, err := gbin.Marshal(&x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lengthen the pointer's payload by two bytes which nothing reads
+	padded := append([]byte{encoded[0], encoded[1] + 2}, encoded[2:]...)
+	padded = append(padded, 0, 0)
+	_, err = gbin.NewDecoder[*int]().Decode(padded)
+	if err == nil || !strings.Contains(err.Error(), "2 bytes left") {
+		t.Fatalf("expected 2 bytes to be reported left over, got %v", err)
+	}
+}
+
+// compactHeader writes the header of a compact value with a payload of n bytes
+func compactHeader(code gbin.EncodedType, n int) []byte {
+	if n < 7 {
+		return []byte{byte(code)<<3 | byte(n)}
+	}
+	return binary.AppendUvarint([]byte{byte(code)<<3 | 7}, uint64(n))
+}
+
+func TestConstructedSize(t *testing.T) {
+	opts := []gbin.Option{gbin.WithMaxSize(1 << 20), gbin.WithMaxLength(1 << 12)}
+	allocated := func(decode func() error) (uint64, error) {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := decode()
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc, err
+	}
+
+	// each nil pointer describes the large type it points at, which is not
+	// allocated
+	ptrs := struct{ Value any }{make([]*[1 << 21]int64, 1000)}
+	encoded, err := gbin.NewEncoder[struct{ Value any }](gbin.WithCompact()).Encode(&ptrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded *struct{ Value any }
+	n, err := allocated(func() (err error) {
+		decoded, err = gbin.NewDecoder[struct{ Value any }](opts...).Decode(encoded)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*decoded, ptrs) {
+		t.Fatalf("expected %d nil pointers, got %#v", len(ptrs.Value.([]*[1 << 21]int64)), decoded.Value)
+	}
+	if n > 1<<24 {
+		t.Fatalf("decoding %d bytes allocated %d bytes", len(encoded), n)
+	}
+
+	// nil elements of a large type are each created as its zero value
+	payload := append([]byte{byte(gbin.ARRAY)}, binary.AppendUvarint(nil, 1<<21)...)
+	payload = append(payload, byte(gbin.INT64))
+	for i := 0; i < 1000; i++ {
+		payload = append(payload, byte(gbin.INVALID)<<3)
+	}
+	slice := append(compactHeader(gbin.SLICE, len(payload)), payload...)
+	field := append(append([]byte{byte(gbin.STRING)<<3 | 5}, "Value"...), compactHeader(gbin.INTERFACE, len(slice))...)
+	field = append(field, slice...)
+	crafted := append(append([]byte{encoded[0]}, compactHeader(gbin.STRUCT, len(field))...), field...)
+	n, err = allocated(func() error {
+		_, err := gbin.NewDecoder[struct{ Value any }](opts...).Decode(crafted)
+		return err
+	})
+	if !errors.Is(err, gbin.ErrLimitExceeded) {
+		t.Fatalf("expected ErrLimitExceeded, got %v", err)
+	}
+	if n > 1<<26 {
+		t.Fatalf("decoding %d bytes allocated %d bytes", len(crafted), n)
+	}
+}
+
+// fuzzSeeds returns encodings of a variety of values in both formats, and with
+// a schema
+func fuzzSeeds(f *testing.F) [][]byte {
+	root := &refNode{Value: 1, Lookup: map[string]*refNode{}}
+	root.Children = []*refNode{{Value: 2, Parent: root}}
+	root.Lookup["self"] = root
+	values := []func(opts ...gbin.Option) ([]byte, error){
+		func(opts ...gbin.Option) ([]byte, error) {
+			v := portableValue()
+			return gbin.NewEncoder[portableRecord](opts...).Encode(&v)
+		},
+		func(opts ...gbin.Option) ([]byte, error) {
+			return gbin.NewEncoder[*refNode](append(opts, gbin.WithReferences())...).Encode(&root)
+		},
+		func(opts ...gbin.Option) ([]byte, error) {
+			v := map[string][]int{"a": {1, -2}, "b": {}}
+			return gbin.NewEncoder[map[string][]int](opts...).Encode(&v)
+		},
+		func(opts ...gbin.Option) ([]byte, error) {
+			v := []userID{{"usr", 1}}
+			return gbin.NewEncoder[[]userID](opts...).Encode(&v)
+		},
+		func(opts ...gbin.Option) ([]byte, error) {
+			v := struct{ Value any }{[]any{1, "a", [2]bool{true}, map[int8]string{1: "b"}, &circle{R: 1}}}
+			return gbin.NewEncoder[struct{ Value any }](opts...).Encode(&v)
+		},
+	}
+	seeds := [][]byte{}
+	for _, encode := range values {
+		for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}, {gbin.WithSchema()}, {gbin.WithCompression(gbin.Flate)}} {
+			encoded, err := encode(opts...)
+			if err != nil {
+				f.Fatal(err)
+			}
+			seeds = append(seeds, encoded)
+		}
+	}
+	golden, err := filepath.Glob(filepath.Join("testdata", "*.gbin"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range golden {
+		encoded, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		seeds = append(seeds, encoded)
+	}
+	return seeds
+}
+
+// fuzzDecode decodes data into T, checking that failures are reported as a
+// *gbin.Error
+func fuzzDecode[T any](t *testing.T, data []byte) {
+	decoder := gbin.NewDecoder[T](gbin.WithMaxSize(1<<20), gbin.WithMaxLength(1<<12))
+	decoded, err := decoder.Decode(data)
+	var decodeErr *gbin.Error
+	if err != nil && !errors.As(err, &decodeErr) {
+		t.Fatalf("decoding into %T failed with %T: %v", *new(T), err, err)
+	}
+	// decoding into the result again reuses all of it
+	if decoded != nil {
+		err = decoder.DecodeInto(data, decoded)
+		if err != nil {
+			t.Fatalf("decoding into %T failed when reused: %v", *new(T), err)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecode[portableRecord](t, data)
+		fuzzDecode[*refNode](t, data)
+		fuzzDecode[map[string][]int](t, data)
+		fuzzDecode[[]userID](t, data)
+		fuzzDecode[struct{ Value any }](t, data)
+		tree, err := gbin.DecodeDynamic(data, gbin.WithMaxSize(1<<20), gbin.WithMaxLength(1<<12))
+		var decodeErr *gbin.Error
+		if err != nil && !errors.As(err, &decodeErr) {
+			t.Fatalf("decoding dynamically failed with %T: %v", err, err)
+		}
+		err = gbin.Dump(io.Discard, data, gbin.WithMaxSize(1<<20), gbin.WithMaxLength(1<<12))
+		if err != nil && !errors.As(err, &decodeErr) {
+			t.Fatalf("dumping failed with %T: %v", err, err)
+		}
+		for _, path := range []string{"Value", "[1]", `Lookup["a"].Children[0]`, "[0][0]"} {
+			_, err = gbin.DecodePath[any](data, path, gbin.WithMaxSize(1<<20), gbin.WithMaxLength(1<<12))
+			if err != nil && !errors.As(err, &decodeErr) {
+				t.Fatalf("decoding %s failed with %T: %v", path, err, err)
+			}
+		}
+		// encoding the tree with its schema may fail, but must not panic
+		if schema, err := gbin.ReadSchema(data); err == nil && tree != nil {
+			gbin.EncodeDynamic(tree, schema)
+		}
+	})
+}
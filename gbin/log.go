@@ -0,0 +1,413 @@
+package gbin
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A Log is a file of records, each the encoding of a value framed by its
+// length and checksum, so that a record torn by a crash part way through
+// appending it is detected and discarded when the log is next opened.
+//
+// LOG: MAGIC, VERSION, RECORD...
+// RECORD: UINT32 PAYLOAD LENGTH, PAYLOAD, UINT32 CRC
+//
+// The checksum is the CRC-32 (Castagnoli) of the length and payload, and the
+// payload is the encoding of the value with the options of the log.
+
+const LOG_VERSION = 1
+
+var logMagic = [4]byte{'g', 'b', 'l', 'g'}
+
+// logHeaderLen is the length of a log before its first record
+const logHeaderLen = len(logMagic) + 1
+
+// recordHeaderLen is the length of a record before its payload, and
+// recordOverhead the length of a record besides its payload
+const (
+	recordHeaderLen = 4
+	recordOverhead  = recordHeaderLen + 4
+)
+
+// Log is an append-only file of values of type T. Records are addressed by
+// their offset, counted from the first record, which is 0. Errors decoding
+// records are reported with offsets counted from the same place.
+//
+// A Log is safe for concurrent use by multiple goroutines, and values may be
+// read while others are appended. Appended records are written to the file
+// before Append returns, but are only durable once Sync has been called.
+type Log[T any] struct {
+	mu   sync.Mutex
+	f    *os.File
+	enc  *Encoder[T]
+	dec  *Decoder[T]
+	opts *options
+	// end is the offset at which the next record is appended
+	end int64
+}
+
+// OpenLog opens the log at path, creating it if it does not exist. Every
+// record is read to check its checksum. A torn record at the end of the log,
+// left by a crash while appending it, is truncated away; any other corrupt
+// record fails with an error wrapping ErrCorrupt. The options are used to
+// encode and decode records.
+func OpenLog[T any](path string, opts ...Option) (*Log[T], error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, ioError("opening log", err)
+	}
+	l := &Log[T]{
+		f:    f,
+		enc:  NewEncoder[T](opts...),
+		dec:  NewDecoder[T](opts...),
+		opts: newOptions(opts),
+	}
+	err = l.recover()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// recover checks the header of the log, writing it to a new log, and finds
+// the end of its last intact record, truncating anything after it which is
+// a torn record
+func (l *Log[T]) recover() error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return ioError("opening log", err)
+	}
+	header := append(logMagic[:], LOG_VERSION)
+	existing := make([]byte, min(info.Size(), int64(logHeaderLen)))
+	_, err = l.f.ReadAt(existing, 0)
+	if err != nil {
+		return ioError("reading log header", err)
+	}
+	if len(existing) < logHeaderLen && bytes.HasPrefix(header, existing) {
+		// the log is new, or was torn while it was created
+		_, err = l.f.WriteAt(header, 0)
+		if err == nil {
+			err = l.f.Truncate(int64(logHeaderLen))
+		}
+		return ioError("writing log header", err)
+	}
+	if len(existing) < logHeaderLen || !bytes.Equal(existing[:len(logMagic)], logMagic[:]) {
+		return logError(0, fmt.Errorf("%w: %s is not a gbin log", ErrCorrupt, l.f.Name()))
+	} else if existing[len(logMagic)] != LOG_VERSION {
+		return logError(0, fmt.Errorf("unsupported log version %d", existing[len(logMagic)]))
+	}
+
+	size := info.Size() - int64(logHeaderLen)
+	r := bufio.NewReader(io.NewSectionReader(l.f, int64(logHeaderLen), size))
+	// records are only checksummed here, so WithMaxSize is not applied, as a
+	// log holding a record longer than it could otherwise never be opened
+	for l.end < size {
+		record, err := l.readRecord(r, l.end, size, 0)
+		if err == nil {
+			l.end += int64(len(record))
+			continue
+		}
+		torn, tornErr := l.torn(err, size)
+		if tornErr != nil {
+			return tornErr
+		} else if !torn && errors.Is(err, ErrTruncated) {
+			return logError(l.end, fmt.Errorf("%w: record runs past the end of the log, but intact records follow it", ErrCorrupt))
+		} else if !torn {
+			return err
+		}
+		return ioError("truncating torn record", l.f.Truncate(int64(logHeaderLen)+l.end))
+	}
+	return nil
+}
+
+// torn reports whether the record at the end of the log, which failed to be
+// read with err, was torn while it was appended, so that it is the last
+// record in the log. It was if it runs past the end of the file with no
+// intact record after it, or is followed only by the zeros the file system
+// may leave when extending the file.
+func (l *Log[T]) torn(err error, size int64) (bool, error) {
+	if errors.Is(err, ErrTruncated) {
+		intact, err := l.intactAfter(size)
+		return !intact, err
+	} else if !errors.Is(err, ErrCorrupt) {
+		return false, nil
+	}
+	var header [recordHeaderLen]byte
+	_, rerr := l.f.ReadAt(header[:], int64(logHeaderLen)+l.end)
+	if rerr != nil {
+		return false, ioError("reading log", rerr)
+	}
+	end := l.end + recordOverhead + int64(BYTE_ORDER.Uint32(header[:]))
+	r := bufio.NewReader(io.NewSectionReader(l.f, int64(logHeaderLen)+end, size-end))
+	for {
+		b, rerr := r.ReadByte()
+		if rerr == io.EOF {
+			return true, nil
+		} else if rerr != nil {
+			return false, ioError("reading log", rerr)
+		} else if b != 0 {
+			return false, nil
+		}
+	}
+}
+
+// intactAfter reports whether an intact record starts anywhere after the
+// record at the end of the log, as one does if the length of that record was
+// corrupted rather than torn
+func (l *Log[T]) intactAfter(size int64) (bool, error) {
+	var header [recordHeaderLen]byte
+	for offset := l.end + 1; offset <= size-recordOverhead; offset++ {
+		_, err := l.f.ReadAt(header[:], int64(logHeaderLen)+offset)
+		if err != nil {
+			return false, ioError("reading log", err)
+		}
+		length := int64(BYTE_ORDER.Uint32(header[:]))
+		if length == 0 || length > size-offset-recordOverhead {
+			continue
+		}
+		src := io.NewSectionReader(l.f, int64(logHeaderLen)+offset, size-offset)
+		_, err = l.readRecord(src, offset, size, 0)
+		if err == nil {
+			return true, nil
+		} else if !errors.Is(err, ErrCorrupt) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// readRecord reads the whole of the record at offset from r, checking its
+// checksum. The log ends at size, and records longer than maxSize are
+// rejected unless it is 0.
+func (l *Log[T]) readRecord(r io.Reader, offset, size int64, maxSize uint64) ([]byte, error) {
+	var header [recordHeaderLen]byte
+	if size-offset < recordOverhead {
+		return nil, logError(offset, truncatedError("the log ends within a record header"))
+	}
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, logError(offset, err)
+	}
+	length := int64(BYTE_ORDER.Uint32(header[:]))
+	if length == 0 {
+		return nil, logError(offset, fmt.Errorf("%w: record has no payload", ErrCorrupt))
+	} else if length > size-offset-recordOverhead {
+		return nil, logError(offset, truncatedError("record of %d bytes runs past the end of the log", length))
+	} else if maxSize > 0 && uint64(length) > maxSize {
+		return nil, logError(offset, limitError("record of %d bytes is longer than %d bytes", length, maxSize))
+	}
+	record := make([]byte, length+recordOverhead)
+	copy(record, header[:])
+	_, err = io.ReadFull(r, record[recordHeaderLen:])
+	if err != nil {
+		return nil, logError(offset, err)
+	}
+	sum := crc32.Checksum(record[:recordHeaderLen+length], castagnoli)
+	if expected := BYTE_ORDER.Uint32(record[recordHeaderLen+length:]); sum != expected {
+		return nil, logError(offset, fmt.Errorf("%w: checksum 0x%08x does not match the record, which sums to 0x%08x", ErrCorrupt, expected, sum))
+	}
+	return record, nil
+}
+
+// recordPayload returns the payload of a record read by readRecord
+func recordPayload(record []byte) []byte {
+	return record[recordHeaderLen : len(record)-recordOverhead+recordHeaderLen]
+}
+
+// logError wraps an error reading the record at offset in a log. Errors from
+// decoding the record have their offsets counted from the start of the log.
+func logError(offset int64, err error) error {
+	tr := newTrace()
+	tr.Push("record")
+	position := uint64(offset)
+	if inner, ok := err.(*Error); ok {
+		position += recordHeaderLen + inner.Offset
+	}
+	return newError(tr, err, position, false)
+}
+
+// Append encodes data and appends it to the log, returning the offset of its
+// record. Values whose encodings are longer than WithMaxSize allows are
+// rejected, as their records could not be read.
+func (l *Log[T]) Append(data *T) (int64, error) {
+	payload, err := l.enc.Encode(data)
+	if err != nil {
+		return 0, err
+	}
+	if uint64(len(payload)) > 1<<32-1 {
+		return 0, wrapEncode(fmt.Errorf("encoding of %d bytes is too long for a log record", len(payload)))
+	} else if l.opts.maxSize > 0 && uint64(len(payload)) > l.opts.maxSize {
+		return 0, newError(newTrace(), limitError("encoding of %d bytes is longer than the %d bytes a record may be", len(payload), l.opts.maxSize), 0, true)
+	}
+	record := make([]byte, 0, len(payload)+recordOverhead)
+	record = BYTE_ORDER.AppendUint32(record, uint32(len(payload)))
+	record = append(record, payload...)
+	record = BYTE_ORDER.AppendUint32(record, crc32.Checksum(record, castagnoli))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	offset := l.end
+	_, err = l.f.WriteAt(record, int64(logHeaderLen)+offset)
+	if err != nil {
+		// leave no partial record for the next append to follow
+		l.f.Truncate(int64(logHeaderLen) + offset)
+		return 0, ioError("appending to log", err)
+	}
+	l.end += int64(len(record))
+	return offset, nil
+}
+
+// Size returns the offset at which the next record will be appended
+func (l *Log[T]) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.end
+}
+
+// Sync commits the records appended so far to stable storage
+func (l *Log[T]) Sync() error {
+	return ioError("syncing log", l.f.Sync())
+}
+
+// Close closes the file of the log
+func (l *Log[T]) Close() error {
+	return ioError("closing log", l.f.Close())
+}
+
+// Compact writes the records of the log for which keep returns true to a new
+// log at path, replacing any file there once it is complete. Records are
+// copied as they were appended, and those appended while compacting, even
+// from keep, are not included. To replace the log with its compaction, close it and rename the
+// new log over it.
+func (l *Log[T]) Compact(path string, keep func(offset int64, value *T) bool) (err error) {
+	if abs, err := filepath.Abs(path); err == nil && abs == l.path() {
+		return wrapEncode(fmt.Errorf("cannot compact %s into itself", path))
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return ioError("compacting log", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	w := bufio.NewWriter(tmp)
+	_, err = w.Write(append(logMagic[:], LOG_VERSION))
+	if err != nil {
+		return ioError("compacting log", err)
+	}
+	r := l.NewReader(0)
+	r.end = l.Size()
+	for {
+		offset := r.Offset()
+		value, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if !keep(offset, value) {
+			continue
+		}
+		_, err = w.Write(r.record)
+		if err != nil {
+			return ioError("compacting log", err)
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	return ioError("compacting log", err)
+}
+
+// path returns the absolute path of the log's file
+func (l *Log[T]) path() string {
+	abs, err := filepath.Abs(l.f.Name())
+	if err != nil {
+		return l.f.Name()
+	}
+	return abs
+}
+
+// LogReader reads the records of a Log in order. Unlike the Log, it must
+// not be used by multiple goroutines at once.
+type LogReader[T any] struct {
+	l      *Log[T]
+	offset int64
+	// end is the offset at which reading stops, or -1 to read every record
+	// appended before each read
+	end int64
+	// record is the whole of the record last read, as Compact copies it
+	record []byte
+}
+
+// NewReader creates a LogReader which reads the records of the log from the
+// one at offset, which must be 0 or an offset returned by Append or Offset
+func (l *Log[T]) NewReader(offset int64) *LogReader[T] {
+	return &LogReader[T]{l: l, offset: offset, end: -1}
+}
+
+// Offset returns the offset of the next record to be read
+func (r *LogReader[T]) Offset() int64 {
+	return r.offset
+}
+
+// Read decodes the next record. It returns io.EOF once every record appended
+// before it was called has been read, after which further records may be
+// appended and read.
+func (r *LogReader[T]) Read() (*T, error) {
+	decoded := new(T)
+	err := r.read(decoded, false)
+	if err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// ReadInto decodes the next record into dst, reusing its memory as
+// DecodeInto does. It returns io.EOF as Read does.
+func (r *LogReader[T]) ReadInto(dst *T) error {
+	return r.read(dst, true)
+}
+
+func (r *LogReader[T]) read(dst *T, reuse bool) error {
+	size := r.end
+	if size < 0 {
+		size = r.l.Size()
+	}
+	if r.offset >= size {
+		return io.EOF
+	}
+	src := io.NewSectionReader(r.l.f, int64(logHeaderLen)+r.offset, size-r.offset)
+	record, err := r.l.readRecord(src, r.offset, size, r.l.opts.maxSize)
+	if err != nil {
+		return err
+	}
+	data := recordPayload(record)
+	br := getReader(bytes.NewReader(data))
+	defer putReader(br)
+	err = r.l.dec.decode(br, len(data), dst, reuse)
+	if err != nil {
+		return logError(r.offset, err)
+	}
+	r.record = record
+	r.offset += int64(len(record))
+	return nil
+}
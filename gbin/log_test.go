@@ -0,0 +1,300 @@
+package gbin_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+// appendRecords appends data to a new log at path, returning the offsets of
+// its records
+func appendRecords(t *testing.T, path string, data []benchRecord, opts ...gbin.Option) []int64 {
+	l, err := gbin.OpenLog[benchRecord](path, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	offsets := []int64{}
+	for i := range data {
+		offset, err := l.Append(&data[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offset)
+	}
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	return offsets
+}
+
+// readRecords reads every record of the log at path from offset
+func readRecords(t *testing.T, l *gbin.Log[benchRecord], offset int64) []benchRecord {
+	r := l.NewReader(offset)
+	read := []benchRecord{}
+	for {
+		value, err := r.Read()
+		if err == io.EOF {
+			return read
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		read = append(read, *value)
+	}
+}
+
+func TestLog(t *testing.T) {
+	data := benchRecords()[:10]
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}, {gbin.WithCompression(gbin.Gzip)}} {
+		path := filepath.Join(t.TempDir(), "records.log")
+		offsets := appendRecords(t, path, data[:5], opts...)
+		l, err := gbin.OpenLog[benchRecord](path, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 5; i < len(data); i++ {
+			offset, err := l.Append(&data[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			offsets = append(offsets, offset)
+		}
+		if read := readRecords(t, l, 0); !reflect.DeepEqual(read, data) {
+			t.Fatalf("expected %v, got %v", data, read)
+		}
+		if read := readRecords(t, l, offsets[7]); !reflect.DeepEqual(read, data[7:]) {
+			t.Fatalf("expected %v, got %v", data[7:], read)
+		}
+
+		// a reader at the end reads records appended after it
+		r := l.NewReader(l.Size())
+		if _, err := r.Read(); err != io.EOF {
+			t.Fatalf("expected io.EOF, got %v", err)
+		}
+		if _, err := l.Append(&data[0]); err != nil {
+			t.Fatal(err)
+		}
+		var reused benchRecord
+		if err := r.ReadInto(&reused); err != nil || !reflect.DeepEqual(reused, data[0]) {
+			t.Fatalf("expected %v, got %v (%v)", data[0], reused, err)
+		}
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLogTornRecord(t *testing.T) {
+	data := benchRecords()[:3]
+	path := filepath.Join(t.TempDir(), "records.log")
+	offsets := appendRecords(t, path, data)
+	full, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the log header is 5 bytes
+	last := 5 + offsets[2]
+	tears := map[string][]byte{
+		"within the header":   full[:last+2],
+		"within the payload":  full[:len(full)-10],
+		"before the checksum": full[:len(full)-4],
+		"with a bad checksum": append(append([]byte{}, full[:len(full)-1]...), full[len(full)-1]^0xff),
+		"followed by zeros":   append(append([]byte{}, full[:len(full)-4]...), make([]byte, 64)...),
+	}
+	for name, torn := range tears {
+		if err := os.WriteFile(path, torn, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		l, err := gbin.OpenLog[benchRecord](path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if read := readRecords(t, l, 0); !reflect.DeepEqual(read, data[:2]) {
+			t.Fatalf("%s: expected the first two records, got %v", name, read)
+		}
+		if _, err := l.Append(&data[2]); err != nil {
+			t.Fatal(err)
+		}
+		if read := readRecords(t, l, 0); !reflect.DeepEqual(read, data) {
+			t.Fatalf("%s: expected every record once appended again, got %v", name, read)
+		}
+		l.Close()
+	}
+}
+
+func TestLogCorrupt(t *testing.T) {
+	data := benchRecords()[:3]
+	path := filepath.Join(t.TempDir(), "records.log")
+	offsets := appendRecords(t, path, data)
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the log header is 5 bytes
+	encoded[5+offsets[1]+10] ^= 0xff
+	if err := os.WriteFile(path, encoded, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = gbin.OpenLog[benchRecord](path)
+	var logErr *gbin.Error
+	if !errors.Is(err, gbin.ErrCorrupt) || !errors.As(err, &logErr) || logErr.Offset != uint64(offsets[1]) {
+		t.Fatalf("expected the second record to be corrupt, got %v", err)
+	}
+
+	// a record whose length runs past the end of the log is only torn if no
+	// intact record follows it
+	encoded[5+offsets[1]+10] ^= 0xff
+	encoded[5+offsets[1]] = 0x7f
+	if err := os.WriteFile(path, encoded, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = gbin.OpenLog[benchRecord](path)
+	if !errors.Is(err, gbin.ErrCorrupt) || !errors.As(err, &logErr) || logErr.Offset != uint64(offsets[1]) {
+		t.Fatalf("expected the second record to be corrupt, got %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != int64(len(encoded)) {
+		t.Fatalf("expected the corrupt log to be left as it was, got %v (%v)", info.Size(), err)
+	}
+
+	for _, content := range []string{"not a log", "xx", ""} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		l, err := gbin.OpenLog[benchRecord](path)
+		if content == "" && err == nil {
+			l.Close()
+		} else if content == "" {
+			t.Fatalf("expected an empty file to become a log, got %v", err)
+		} else if content != "" && !errors.Is(err, gbin.ErrCorrupt) {
+			t.Fatalf("expected %q to be rejected as not a log, got %v", content, err)
+		}
+	}
+}
+
+func TestLogMaxSize(t *testing.T) {
+	data := benchRecords()[:2]
+	path := filepath.Join(t.TempDir(), "records.log")
+	l, err := gbin.OpenLog[benchRecord](path, gbin.WithMaxSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Append(&data[0]); !errors.Is(err, gbin.ErrLimitExceeded) {
+		t.Fatalf("expected a record longer than the limit to be rejected, got %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// records written without the limit are still recovered with it, and
+	// only fail to be read
+	appendRecords(t, path, data)
+	l, err = gbin.OpenLog[benchRecord](path, gbin.WithMaxSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err := l.NewReader(0).Read(); !errors.Is(err, gbin.ErrLimitExceeded) {
+		t.Fatalf("expected reading a record longer than the limit to fail, got %v", err)
+	}
+}
+
+func TestLogFileErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "records.log")
+	_, err := gbin.OpenLog[benchRecord](path)
+	if !errors.Is(err, os.ErrNotExist) || strings.Contains(err.Error(), "decode") {
+		t.Fatalf("expected the file system's error, got %v", err)
+	}
+	l, err := gbin.OpenLog[benchRecord](filepath.Join(t.TempDir(), "records.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected closing twice to fail with os.ErrClosed, got %v", err)
+	}
+}
+
+func TestLogCompact(t *testing.T) {
+	data := benchRecords()[:10]
+	dir := t.TempDir()
+	path := filepath.Join(dir, "records.log")
+	offsets := appendRecords(t, path, data)
+	l, err := gbin.OpenLog[benchRecord](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	compacted := filepath.Join(dir, "compacted.log")
+	kept := []int64{}
+	err = l.Compact(compacted, func(offset int64, value *benchRecord) bool {
+		kept = append(kept, offset)
+		// records appended while compacting are left out
+		if _, err := l.Append(value); err != nil {
+			t.Error(err)
+		}
+		return value.ID%2 == 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(kept, offsets) {
+		t.Fatalf("expected keep to be called with %v, got %v", offsets, kept)
+	}
+	if err := l.Compact(path, func(int64, *benchRecord) bool { return true }); err == nil {
+		t.Fatal("expected compacting a log into itself to fail")
+	}
+
+	c, err := gbin.OpenLog[benchRecord](compacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	expected := []benchRecord{}
+	for i := 0; i < len(data); i += 2 {
+		expected = append(expected, data[i])
+	}
+	if read := readRecords(t, c, 0); !reflect.DeepEqual(read, expected) {
+		t.Fatalf("expected %v, got %v", expected, read)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected only the two logs to be left, got %v (%v)", entries, err)
+	}
+}
+
+func TestLogConcurrentAppend(t *testing.T) {
+	l, err := gbin.OpenLog[benchRecord](filepath.Join(t.TempDir(), "records.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	data := benchRecords()
+	wg := sync.WaitGroup{}
+	for i := range data {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := l.Append(&data[i]); err != nil {
+				t.Error(err)
+			}
+			readRecords(t, l, 0)
+		}(i)
+	}
+	wg.Wait()
+	seen := map[int64]bool{}
+	for _, record := range readRecords(t, l, 0) {
+		seen[record.ID] = true
+	}
+	if len(seen) != len(data) {
+		t.Fatalf("expected %d records, got %d", len(data), len(seen))
+	}
+}
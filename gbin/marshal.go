@@ -0,0 +1,281 @@
+package gbin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"fmt"
+	"reflect"
+)
+
+// Marshaler is implemented by types which encode themselves. MarshalGbin must
+// return a single complete gbin encoded value, which is written in place of
+// the value's reflective encoding. Marshal can be used to produce it.
+type Marshaler interface {
+	MarshalGbin() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types which decode themselves. UnmarshalGbin
+// is passed the complete encoded value written by the type's MarshalGbin, and
+// must copy it if it is retained.
+type Unmarshaler interface {
+	UnmarshalGbin([]byte) error
+}
+
+var (
+	marshalerType         = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType       = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Codec converts values of a single type to and from bytes, allowing types
+// which cannot be modified to be encoded. It is created with NewCodec and
+// registered on an Encoder or Decoder with WithCodecs.
+type Codec struct {
+	typ       reflect.Type
+	marshal   func(reflect.Value) ([]byte, error)
+	unmarshal func([]byte, reflect.Value) error
+}
+
+// NewCodec creates a codec for values of type V. Codecs apply to V itself,
+// and therefore also to the targets of pointers to V.
+func NewCodec[V any](marshal func(V) ([]byte, error), unmarshal func([]byte) (V, error)) Codec {
+	typ := reflect.TypeOf((*V)(nil)).Elem()
+	if typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Interface {
+		panic("codec type must not be a pointer or interface")
+	}
+	return Codec{
+		typ: typ,
+		marshal: func(v reflect.Value) ([]byte, error) {
+			return marshal(v.Interface().(V))
+		},
+		unmarshal: func(data []byte, target reflect.Value) error {
+			decoded, err := unmarshal(data)
+			if err != nil {
+				return err
+			}
+			target.Set(reflect.ValueOf(&decoded).Elem())
+			return nil
+		},
+	}
+}
+
+// Marshal encodes v using the default options. v is encoded as it is given,
+// so encoding a pointer encodes the pointer and the value it points to.
+func Marshal(v any) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{})
+	w := bufio.NewWriter(buf)
+	err := encodeValue(w, addressableValue(v), newOptions(nil))
+	if err != nil {
+		return nil, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return nil, writeError(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// addressableValue returns a copy of v which is addressable, so that methods
+// with pointer receivers are found on the values within it
+func addressableValue(v any) reflect.Value {
+	value := reflect.ValueOf(v)
+	if value.IsValid() {
+		addressable := reflect.New(value.Type()).Elem()
+		addressable.Set(value)
+		value = addressable
+	}
+	return value
+}
+
+// Unmarshal decodes data into the value pointed to by v using the default
+// options
+func Unmarshal(data []byte, v any) (err error) {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Pointer || target.IsNil() {
+		return wrapDecode(fmt.Errorf("unmarshal target must be a non nil pointer, not %T", v))
+	}
+	tf := newDecodeTransformer(bufio.NewReader(bytes.NewReader(data)), newTrace(), newOptions(nil))
+	defer recoverError(&err, func(err error) error {
+		return decodeError(tf, err)
+	})
+	tf.sized, tf.size = true, uint64(len(data))
+	as := newAssigner(tf)
+	err = tf.begin()
+	if err == nil {
+		err = as.assign(target.Elem())
+	}
+	if err != nil {
+		return decodeError(tf, err)
+	}
+	return nil
+}
+
+// encode_custom encodes v with a registered codec or its own marshaling
+// methods, reporting whether one was used. Pointers are never marshaled
+// directly; the value they point to is checked instead. The output of the
+// measuring pass is kept and written, so that each value is only marshaled
+// once and what is written always matches what was measured.
+func (t *encodeTransformer) encode_custom(v reflect.Value) (bool, error) {
+	if v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface || v.Kind() == reflect.Invalid {
+		return false, nil
+	}
+	vt := v.Type()
+	_, hasCodec := t.opts.codecs[vt]
+	if hasCodec {
+		t.stack.PushType("codec", vt)
+	} else if planOf(vt).marshaler != nil {
+		t.stack.PushType("marshaler", vt)
+	} else {
+		return false, nil
+	}
+	seq := t.seq
+	t.seq++
+	out, known := t.marshaled[seq]
+	if known {
+		delete(t.marshaled, seq)
+	} else {
+		var err error
+		out, err = t.marshal(v)
+		if err != nil {
+			return true, err
+		}
+		if t.measuring {
+			t.marshaled[seq] = out
+		}
+	}
+	var err error
+	if out.raw {
+		err = t.write(out.data)
+	} else {
+		err = t.format_encode(out.objectType, out.data)
+	}
+	if err != nil {
+		return true, err
+	}
+	t.stack.Pop()
+	return true, nil
+}
+
+// marshaled is the output of a codec or marshaling method
+type marshaled struct {
+	objectType EncodedType
+	data       []byte
+	// raw is set if data is a complete encoded value to be written as it is
+	raw bool
+}
+
+// marshal runs the codec or marshaling method of v
+func (t *encodeTransformer) marshal(v reflect.Value) (marshaled, error) {
+	if codec, ok := t.opts.codecs[v.Type()]; ok {
+		data, err := codec.marshal(v)
+		return marshaled{objectType: BYTES, data: data}, err
+	}
+	plan := planOf(v.Type())
+	switch m := receiver(v, plan.marshalerPtr).(type) {
+	case Marshaler:
+		data, err := m.MarshalGbin()
+		if err == nil {
+			err = validateEncoded(data)
+		}
+		return marshaled{objectType: EMBEDDED, data: data, raw: !t.opts.compact}, err
+	case encoding.BinaryMarshaler:
+		data, err := m.MarshalBinary()
+		return marshaled{objectType: BYTES, data: data}, err
+	default:
+		data, err := m.(encoding.TextMarshaler).MarshalText()
+		return marshaled{objectType: STRING, data: data}, err
+	}
+}
+
+// validateEncoded checks that data holds exactly one encoded value
+func validateEncoded(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("marshaler returned no data")
+	}
+	lenLen := int(lengthBytes(data[0]))
+	if len(data) < 1+lenLen {
+		return fmt.Errorf("marshaler returned a truncated header")
+	}
+	payloadLen := uint64(0)
+	for _, b := range data[1 : 1+lenLen] {
+		payloadLen = payloadLen<<8 | uint64(b)
+	}
+	if uint64(len(data)-1-lenLen) != payloadLen {
+		return fmt.Errorf("marshaler returned %d bytes of payload but header specifies %d", len(data)-1-lenLen, payloadLen)
+	}
+	return nil
+}
+
+// visit_custom decodes into target with a registered codec or the target's
+// own unmarshaling methods, reporting whether one was used
+func (a *assigner) visit_custom(target reflect.Value) (bool, error) {
+	if target.Kind() == reflect.Pointer || target.Kind() == reflect.Interface {
+		return false, nil
+	}
+	tt := target.Type()
+	if codec, ok := a.tf.opts.codecs[tt]; ok {
+		a.stack.PushType("codec", tt)
+		data, err := a.read_payload(BYTES)
+		if err != nil {
+			return true, err
+		}
+		err = codec.unmarshal(data, target)
+		if err != nil {
+			return true, err
+		}
+		a.stack.Pop()
+		return true, nil
+	}
+	plan := planOf(tt)
+	if plan.unmarshaler == nil {
+		return false, nil
+	}
+	a.stack.PushType("unmarshaler", tt)
+	var err error
+	switch u := receiver(target, plan.unmarshalerPtr).(type) {
+	case Unmarshaler:
+		var data []byte
+		if a.tf.compact {
+			data, err = a.read_payload(EMBEDDED)
+		} else {
+			data, err = a.tf.read_raw()
+		}
+		if err == nil {
+			err = u.UnmarshalGbin(data)
+		}
+	case encoding.BinaryUnmarshaler:
+		var data []byte
+		data, err = a.read_payload(BYTES)
+		if err == nil {
+			err = u.UnmarshalBinary(data)
+		}
+	case encoding.TextUnmarshaler:
+		var data []byte
+		data, err = a.read_payload(STRING)
+		if err == nil {
+			err = u.UnmarshalText(data)
+		}
+	}
+	if err != nil {
+		return true, err
+	}
+	a.stack.Pop()
+	return true, nil
+}
+
+// read_payload reads the payload of the next value, which must be of the
+// given type
+func (a *assigner) read_payload(expected EncodedType) ([]byte, error) {
+	objectType, payloadLen, err := a.tf.header()
+	if err != nil {
+		return nil, err
+	}
+	if objectType != expected {
+		return nil, mismatch(controlName(expected), controlName(objectType), "expected encoded %s but found %s", controlName(expected), controlName(objectType))
+	}
+	return a.tf.readN(payloadLen)
+}
@@ -0,0 +1,180 @@
+package gbin_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+type userID struct {
+	prefix string
+	n      uint32
+}
+
+func (id userID) MarshalGbin() ([]byte, error) {
+	return gbin.Marshal([]any{id.prefix, id.n})
+}
+
+func (id *userID) UnmarshalGbin(data []byte) error {
+	var parts []any
+	err := gbin.Unmarshal(data, &parts)
+	if err != nil {
+		return err
+	}
+	if len(parts) != 2 {
+		return errors.New("malformed user id")
+	}
+	prefix, okPrefix := parts[0].(string)
+	n, okN := parts[1].(uint32)
+	if !okPrefix || !okN {
+		return errors.New("malformed user id")
+	}
+	id.prefix, id.n = prefix, n
+	return nil
+}
+
+type vendorPoint struct {
+	x, y int32
+}
+
+var vendorPointCodec = gbin.NewCodec(
+	func(p vendorPoint) ([]byte, error) {
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint32(buf, uint32(p.x))
+		binary.BigEndian.PutUint32(buf[4:], uint32(p.y))
+		return buf, nil
+	},
+	func(data []byte) (vendorPoint, error) {
+		if len(data) != 8 {
+			return vendorPoint{}, errors.New("malformed point")
+		}
+		return vendorPoint{int32(binary.BigEndian.Uint32(data)), int32(binary.BigEndian.Uint32(data[4:]))}, nil
+	},
+)
+
+func TestMarshaler(t *testing.T) {
+	id := userID{"usr", 42}
+	data := struct {
+		ID     userID
+		Ptr    *userID
+		Nil    *userID
+		IDs    []userID
+		ByID   map[userID]string
+		Nested map[string]*userID
+	}{
+		ID:     id,
+		Ptr:    &userID{"ptr", 1},
+		IDs:    []userID{{"a", 1}, {"b", 2}},
+		ByID:   map[userID]string{id: "self"},
+		Nested: map[string]*userID{"x": {"x", 3}},
+	}
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+}
+
+func TestBinaryAndTextMarshaler(t *testing.T) {
+	type stamped struct {
+		At      time.Time
+		Expires *time.Time
+		Big     *big.Int
+		Bigs    []big.Int
+	}
+	at := time.Date(2024, 2, 29, 12, 30, 0, 500, time.UTC)
+	expires := at.Add(time.Hour)
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	data := stamped{At: at, Expires: &expires, Big: huge, Bigs: []big.Int{*big.NewInt(-7)}}
+	encoded, err := gbin.NewEncoder[stamped]().Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[stamped]().Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.At.Equal(at) || !decoded.Expires.Equal(expires) {
+		t.Fatalf("times do not match: %v %v", decoded.At, decoded.Expires)
+	}
+	if decoded.Big.Cmp(huge) != 0 || len(decoded.Bigs) != 1 || decoded.Bigs[0].Int64() != -7 {
+		t.Fatalf("big ints do not match: %v %v", decoded.Big, decoded.Bigs)
+	}
+}
+
+func TestCodec(t *testing.T) {
+	type shape struct {
+		Origin vendorPoint
+		Points []*vendorPoint
+	}
+	data := shape{Origin: vendorPoint{1, -1}, Points: []*vendorPoint{{2, 3}, nil}}
+	encoder := gbin.NewEncoder[shape](gbin.WithCodecs(vendorPointCodec))
+	decoder := gbin.NewDecoder[shape](gbin.WithCodecs(vendorPointCodec))
+	encoded, err := encoder.Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decoder.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, *decoded) {
+		t.Fatalf("expected %#v but got %#v", data, *decoded)
+	}
+	if _, err := gbin.NewDecoder[shape]().Decode(encoded); err == nil {
+		t.Fatal("expected decoding without the codec to fail")
+	}
+}
+
+type badMarshaler struct{}
+
+func (badMarshaler) MarshalGbin() ([]byte, error) {
+	return []byte{0x38, 0x01}, nil
+}
+
+func TestInvalidMarshaler(t *testing.T) {
+	data := struct{ A badMarshaler }{}
+	if _, err := gbin.Marshal(data); err == nil {
+		t.Fatal("expected truncated marshaler output to be rejected")
+	}
+}
+
+// counter marshals to a longer string each time it is marshaled
+type counter struct {
+	calls *int
+}
+
+func (c counter) MarshalText() ([]byte, error) {
+	if c.calls == nil {
+		return nil, nil
+	}
+	*c.calls++
+	return []byte(strings.Repeat("x", *c.calls)), nil
+}
+
+func TestMarshalOnce(t *testing.T) {
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}, {gbin.WithReferences()}, {gbin.WithCanonical()}} {
+		calls := 0
+		data := [][]counter{{{&calls}}, {{&calls}, {&calls}}}
+		encoded, err := gbin.NewEncoder[[][]counter](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != 3 {
+			t.Fatalf("expected each value to be marshaled once, got %d calls", calls)
+		}
+		var decoded [][]string
+		err = gbin.Unmarshal(encoded, &decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := [][]string{{"x"}, {"xx", "xxx"}}
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Fatalf("expected %v, got %v", expected, decoded)
+		}
+	}
+}
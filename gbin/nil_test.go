@@ -0,0 +1,179 @@
+package gbin_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+type nullable struct {
+	NilSlice   []int
+	EmptySlice []int
+	NilMap     map[string]int
+	EmptyMap   map[string]int
+	NilBytes   []byte
+	EmptyBytes []byte
+	NilPtr     *int
+	PtrToNil   *[]int
+	Nested     [][]int
+	Values     map[string][]int
+	Array      [2]map[int]bool
+	Any        any
+	Typed      any
+}
+
+type names []string
+
+func init() {
+	gbin.Register("names", names{})
+}
+
+func TestNilAndEmpty(t *testing.T) {
+	data := nullable{
+		EmptySlice: []int{},
+		EmptyMap:   map[string]int{},
+		EmptyBytes: []byte{},
+		PtrToNil:   new([]int),
+		Nested:     [][]int{nil, {}},
+		Values:     map[string][]int{"nil": nil, "empty": {}},
+		Array:      [2]map[int]bool{nil, {}},
+		Any:        []any{[]int(nil), map[string]int{}},
+		Typed:      names(nil),
+	}
+	for _, test := range []struct {
+		opts       []gbin.Option
+		references bool
+	}{
+		{nil, false},
+		{[]gbin.Option{gbin.WithCompact()}, false},
+		{[]gbin.Option{gbin.WithReferences()}, true},
+		{[]gbin.Option{gbin.WithCanonical()}, false},
+		{[]gbin.Option{gbin.WithEnvelope()}, false},
+	} {
+		opts := test.opts
+		// a nil slice behind a pointer or in an unregistered interface is
+		// written empty, so that it is not read as a nil pointer or lose its
+		// type, unless pointers are written as references
+		expected := data
+		if !test.references {
+			expected.PtrToNil = &[]int{}
+		}
+		expected.Any = []any{[]int{}, map[string]int{}}
+		encoded, err := gbin.NewEncoder[nullable](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := gbin.NewDecoder[nullable](opts...).Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*decoded, expected) {
+			t.Fatalf("expected %#v, got %#v", expected, *decoded)
+		}
+
+		// decoding into a value replaces its containers with nil
+		dst := nullable{NilSlice: []int{1}, NilMap: map[string]int{"a": 1}, NilBytes: []byte{1}, Nested: [][]int{{1}, {2}}}
+		err = gbin.NewDecoder[nullable](opts...).DecodeInto(encoded, &dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(dst, expected) {
+			t.Fatalf("expected %#v, got %#v", expected, dst)
+		}
+	}
+}
+
+func TestNilDynamic(t *testing.T) {
+	encoded, err := gbin.Marshal(map[string][]int{"nil": nil, "empty": {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := gbin.DecodeDynamic(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[any]any{"nil": nil, "empty": []any{}}
+	if !reflect.DeepEqual(tree, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, tree)
+	}
+
+	// decoding into an interface rebuilds the original types
+	var decoded any
+	err = gbin.Unmarshal(encoded, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := map[string][]int{"nil": nil, "empty": {}}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Fatalf("expected %#v, got %#v", original, decoded)
+	}
+}
+
+func TestNilView(t *testing.T) {
+	encoded, err := gbin.Marshal(nullable{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	view, err := gbin.NewView(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slice, err := view.Field("NilSlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := slice.Len(); err != nil || n != 0 {
+		t.Fatalf("expected length 0, got %d (%v)", n, err)
+	}
+	if _, err := slice.Index(0); !errors.Is(err, gbin.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	m, err := view.Field("NilMap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Key("a"); !errors.Is(err, gbin.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	var decoded []int
+	if err := slice.Decode(&decoded); err != nil || decoded != nil {
+		t.Fatalf("expected nil, got %#v (%v)", decoded, err)
+	}
+}
+
+func TestNoOutput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	func() {
+		defer func() { os.Stdout = stdout }()
+		data := nullable{Nested: [][]int{nil, {}}, Any: map[string]any{"a": 1}}
+		encoded, err := gbin.Marshal(data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var decoded nullable
+		if err := gbin.Unmarshal(encoded, &decoded); err != nil {
+			t.Error(err)
+		}
+		if _, err := gbin.DecodeDynamic(encoded); err != nil {
+			t.Error(err)
+		}
+	}()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) > 0 {
+		t.Fatalf("expected nothing to be printed, got %q", out)
+	}
+}
@@ -0,0 +1,152 @@
+package gbin
+
+import "reflect"
+
+// Option configures an Encoder or Decoder. Options which only affect one of
+// the two are ignored by the other, so the same options can be passed to both.
+type Option func(*options)
+
+type options struct {
+	codecs     map[reflect.Type]*Codec
+	references bool
+	compact    bool
+	schema     bool
+	canonical  bool
+	jsonTags   bool
+	envelope   bool
+	// compression is the compression used by encoders, and compressions
+	// are those decoders know besides Gzip and Flate
+	compression  *Compression
+	compressions map[byte]*Compression
+	maxDepth     int
+	maxSize      uint64
+	maxLength    int
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		codecs:       map[reflect.Type]*Codec{},
+		compressions: map[byte]*Compression{},
+		maxDepth:     DEFAULT_MAX_DEPTH,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithCodecs registers codecs for third party types. A codec takes precedence
+// over any marshaling methods the type has.
+func WithCodecs(codecs ...Codec) Option {
+	return func(o *options) {
+		for i := range codecs {
+			o.codecs[codecs[i].typ] = &codecs[i]
+		}
+	}
+}
+
+// WithReferences makes the encoder preserve pointer identity. Each pointer
+// target is encoded once, the first time it is reached, and later pointers to
+// it are encoded as references. Decoding then restores shared pointers as
+// shared, and cyclic structures can be encoded. Decoders need no option to
+// read data encoded in this way.
+func WithReferences() Option {
+	return func(o *options) {
+		o.references = true
+	}
+}
+
+// WithCompact makes the encoder use the compact format, which uses variable
+// length integers, describes the type of each container's contents once and
+// packs slices, arrays and maps of scalars without per element headers.
+// Decoders detect the format automatically, so need no option to read it.
+func WithCompact() Option {
+	return func(o *options) {
+		o.compact = true
+	}
+}
+
+// WithSchema makes the encoder begin each value with a schema describing its
+// type, which can be read with ReadSchema. Decoders skip the schema, so need
+// no option to read data encoded in this way.
+func WithSchema() Option {
+	return func(o *options) {
+		o.schema = true
+	}
+}
+
+// WithCanonical makes the encoder produce the same bytes for equal values, by
+// writing the entries of maps in the order of their encoded keys and writing
+// every zero and NaN float the same way. Maps with many entries are slower to
+// encode. The output of Marshalers, including methods generated by gbingen, is
+// written as it is given. Decoders need no option to read data encoded in this
+// way.
+func WithCanonical() Option {
+	return func(o *options) {
+		o.canonical = true
+	}
+}
+
+// WithEnvelope makes the encoder wrap each value in an envelope recording its
+// length and checksum, so that decoders reject truncated and corrupt input
+// with an error wrapping ErrCorrupt. The value is encoded in memory before it
+// is written. Decoders detect envelopes, so need no option to read them.
+func WithEnvelope() Option {
+	return func(o *options) {
+		o.envelope = true
+	}
+}
+
+// WithCompression makes the encoder wrap each value in an envelope, as
+// WithEnvelope does, compressing its encoding with c. Decoders read Gzip and
+// Flate compressed envelopes without options; other compressions must be
+// passed to decoders with WithCompression.
+func WithCompression(c Compression) Option {
+	if c.ID == 0 || c.NewWriter == nil || c.NewReader == nil {
+		panic("gbin: a Compression needs a non-zero ID, NewWriter and NewReader")
+	}
+	return func(o *options) {
+		o.envelope = true
+		o.compression = &c
+		o.compressions[c.ID] = &c
+	}
+}
+
+// WithMaxDepth limits how deeply values may be nested in decoded input. A
+// limit is always enforced, as deeply nested input would otherwise exhaust
+// the stack; it is DEFAULT_MAX_DEPTH unless set.
+func WithMaxDepth(depth int) Option {
+	return func(o *options) {
+		o.maxDepth = depth
+	}
+}
+
+// WithMaxSize limits the number of bytes of input a decoder reads for a
+// single value. There is no limit by default, except on the decompressed
+// payloads of envelopes, which are limited to DEFAULT_MAX_DECOMPRESSED_SIZE
+// unless set.
+func WithMaxSize(size uint64) Option {
+	return func(o *options) {
+		o.maxSize = size
+	}
+}
+
+// WithMaxLength limits the number of elements of a decoded slice, array or
+// map, and the number of fields of a decoded struct. There is no limit by
+// default.
+func WithMaxLength(length int) Option {
+	return func(o *options) {
+		o.maxLength = length
+	}
+}
+
+// WithJSONTags makes struct fields without a gbin tag be named by their json
+// tag, honouring its omitempty option and "-", so that types already tagged
+// for encoding/json need no gbin tags. A gbin tag takes precedence over a
+// json tag. The same option must be passed to encoders and decoders, and it
+// has no effect on the methods generated by gbingen, which follow gbin tags.
+func WithJSONTags() Option {
+	return func(o *options) {
+		o.jsonTags = true
+	}
+}
@@ -0,0 +1,160 @@
+package gbin
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// PathKind is the kind of step a PathElem takes into a value
+type PathKind uint8
+
+const (
+	// PathField steps into the field of a struct
+	PathField PathKind = iota
+	// PathIndex steps into an element of a slice or array
+	PathIndex
+	// PathKey steps into the value of a map entry
+	PathKey
+)
+
+// PathElem is a step of the path from a value to a value inside it, which
+// errors give to where encoding or decoding failed. Pointers, interfaces and
+// custom encodings are passed through without a step, and failures reading
+// a map key have the path of the map.
+type PathElem struct {
+	Kind PathKind
+	// Field is the encoded name of the field stepped into by PathField
+	Field string
+	// Index is the index of the element stepped into by PathIndex
+	Index int
+	// Key is the key of the entry stepped into by PathKey
+	Key any
+}
+
+// String formats the step as it would be written in Go, as .Field, [2] or
+// ["key"]
+func (e PathElem) String() string {
+	switch e.Kind {
+	case PathField:
+		return "." + e.Field
+	case PathIndex:
+		return fmt.Sprintf("[%d]", e.Index)
+	}
+	if s, ok := e.Key.(string); ok {
+		return fmt.Sprintf("[%q]", s)
+	}
+	return fmt.Sprintf("[%v]", e.Key)
+}
+
+// FormatPath formats a path as it would be written in Go, such as
+// .Items[2].Name
+func FormatPath(path []PathElem) string {
+	buf := strings.Builder{}
+	for _, elem := range path {
+		buf.WriteString(elem.String())
+	}
+	return buf.String()
+}
+
+// trace records where encoding or decoding has reached. A frame is pushed on
+// entering each value and popped on leaving it, so the frames left when an
+// error is returned lead to where it occurred. Frames are only formatted when
+// an error is, so entering a value does not allocate.
+type trace struct {
+	frames []frame
+}
+
+type frame struct {
+	label string
+	// typ is the type of the value entered, if it is given
+	typ reflect.Type
+	// elem is the step into a value which the frame takes, if step is set,
+	// and key is the key of a PathKey step
+	elem PathElem
+	key  reflect.Value
+	step bool
+}
+
+func newTrace() *trace {
+	return &trace{}
+}
+
+// Push enters a value described by label
+func (t *trace) Push(label string) {
+	t.frames = append(t.frames, frame{label: label})
+}
+
+// PushType enters a value of type typ described by label
+func (t *trace) PushType(label string, typ reflect.Type) {
+	t.frames = append(t.frames, frame{label: label, typ: typ})
+}
+
+// Field enters the field of a struct encoded as name
+func (t *trace) Field(name string) {
+	t.frames = append(t.frames, frame{elem: PathElem{Kind: PathField, Field: name}, step: true})
+}
+
+// Index enters the element of a slice or array at index i
+func (t *trace) Index(i int) {
+	t.frames = append(t.frames, frame{elem: PathElem{Kind: PathIndex, Index: i}, step: true})
+}
+
+// Key enters the value of the map entry with key k
+func (t *trace) Key(k reflect.Value) {
+	t.frames = append(t.frames, frame{elem: PathElem{Kind: PathKey}, key: k, step: true})
+}
+
+// Pop leaves the value last entered
+func (t *trace) Pop() {
+	if len(t.frames) > 0 {
+		t.frames = t.frames[:len(t.frames)-1]
+	}
+}
+
+// String describes every value entered, as /label/label/
+func (t *trace) String() string {
+	buf := strings.Builder{}
+	buf.WriteString("/")
+	for _, f := range t.frames {
+		buf.WriteString(f.String())
+		buf.WriteString("/")
+	}
+	return buf.String()
+}
+
+func (f frame) String() string {
+	if !f.step && f.typ != nil {
+		return fmt.Sprintf("%s(%s)", f.label, f.typ)
+	} else if !f.step {
+		return f.label
+	}
+	switch f.elem.Kind {
+	case PathField:
+		return fmt.Sprintf("field[%s]", f.elem.Field)
+	case PathIndex:
+		return fmt.Sprintf("el%d", f.elem.Index)
+	}
+	return fmt.Sprintf("val[%v]", f.path().Key)
+}
+
+// path returns the step taken by the frame, giving the key of a PathKey step
+// as a value if it can be represented as one
+func (f frame) path() PathElem {
+	elem := f.elem
+	if elem.Kind == PathKey && f.key.IsValid() && f.key.CanInterface() {
+		elem.Key = f.key.Interface()
+	}
+	return elem
+}
+
+// Path returns the steps taken by the values entered
+func (t *trace) Path() []PathElem {
+	path := []PathElem{}
+	for _, f := range t.frames {
+		if f.step {
+			path = append(path, f.path())
+		}
+	}
+	return path
+}
@@ -0,0 +1,105 @@
+package gbin
+
+import (
+	"reflect"
+	"sync"
+)
+
+// typePlan holds everything gbin derives from a type by reflection, so that
+// it is worked out once per type rather than for every value encoded or
+// decoded. Plans are immutable once built and shared by every goroutine.
+type typePlan struct {
+	// fields are the encodable fields of a struct type named by their gbin
+	// tags, and jsonFields those named by their json tags where they have no
+	// gbin tag
+	fields     fieldSet
+	jsonFields fieldSet
+	// embedded finds the structs embedded in a struct type by the names they
+	// were encoded under before their fields were promoted
+	embedded map[string]int
+	// marshaler and unmarshaler are the first of the marshaling interfaces the
+	// type implements, and marshalerPtr and unmarshalerPtr are set if it is
+	// only implemented by a pointer to the type
+	marshaler      reflect.Type
+	marshalerPtr   bool
+	unmarshaler    reflect.Type
+	unmarshalerPtr bool
+}
+
+// plans caches the plan of every type seen, keyed by reflect.Type
+var plans sync.Map
+
+// planOf returns the plan for typ, building it the first time typ is seen
+func planOf(typ reflect.Type) *typePlan {
+	if plan, ok := plans.Load(typ); ok {
+		return plan.(*typePlan)
+	}
+	plan := &typePlan{}
+	if typ.Kind() == reflect.Struct {
+		plan.fields = newFieldSet(typ, false)
+		plan.jsonFields = newFieldSet(typ, true)
+		plan.embedded = embeddedStructs(typ)
+	}
+	plan.marshaler, plan.marshalerPtr = implements(typ, marshalerType, binaryMarshalerType, textMarshalerType)
+	plan.unmarshaler, plan.unmarshalerPtr = implements(typ, unmarshalerType, binaryUnmarshalerType, textUnmarshalerType)
+	actual, _ := plans.LoadOrStore(typ, plan)
+	return actual.(*typePlan)
+}
+
+// fieldSet holds the encodable fields of a struct type, and finds them by
+// their encoded names and aliases
+type fieldSet struct {
+	fields []fieldInfo
+	byName map[string]fieldInfo
+	err    error
+}
+
+func newFieldSet(st reflect.Type, jsonTags bool) fieldSet {
+	fields, err := structFields(st, jsonTags)
+	set := fieldSet{fields: fields, byName: map[string]fieldInfo{}, err: err}
+	for _, field := range fields {
+		set.byName[field.name] = field
+		for _, alias := range field.aliases {
+			set.byName[alias] = field
+		}
+	}
+	return set
+}
+
+// fieldsFor returns the fields of the struct type, named as configured by
+// opts
+func (p *typePlan) fieldsFor(opts *options) *fieldSet {
+	if opts != nil && opts.jsonTags {
+		return &p.jsonFields
+	}
+	return &p.fields
+}
+
+// implements returns the first of the interfaces given which typ or a pointer
+// to it implements, and whether only the pointer does
+func implements(typ reflect.Type, ifaces ...reflect.Type) (reflect.Type, bool) {
+	pt := reflect.PointerTo(typ)
+	for _, iface := range ifaces {
+		if typ.Implements(iface) {
+			return iface, false
+		} else if pt.Implements(iface) {
+			return iface, true
+		}
+	}
+	return nil, false
+}
+
+// receiver returns v, or its address if ptr is set, as an interface value.
+// Values which are not addressable are copied so that methods with pointer
+// receivers are found regardless of where v came from.
+func receiver(v reflect.Value, ptr bool) any {
+	if !ptr {
+		return v.Interface()
+	}
+	if !v.CanAddr() {
+		addressable := reflect.New(v.Type()).Elem()
+		addressable.Set(v)
+		v = addressable
+	}
+	return v.Addr().Interface()
+}
@@ -0,0 +1,175 @@
+package gbin_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+type portableRecord struct {
+	Int      int
+	Uint     uint
+	Uintptr  uintptr
+	Int8     int8
+	Int16    int16
+	Int32    int32
+	Int64    int64
+	Uint8    uint8
+	Uint16   uint16
+	Uint32   uint32
+	Uint64   uint64
+	Float32  float32
+	Float64  float64
+	Complex  complex128
+	Bool     bool
+	String   string
+	Array    [3]int
+	Slice    []int64
+	Map      map[string]uint
+	Ptr      *int
+	Nil      *int
+	Any      interface{}
+	Renamed  string   `gbin:"renamed"`
+	Optional []string `gbin:",omitempty"`
+}
+
+func portableValue() portableRecord {
+	ptr := -1 << 30
+	return portableRecord{
+		Int:     -1 << 30,
+		Uint:    1<<32 - 1,
+		Uintptr: 0xfffffff0,
+		Int8:    -128,
+		Int16:   -32768,
+		Int32:   -1 << 31,
+		Int64:   -1 << 62,
+		Uint8:   255,
+		Uint16:  65535,
+		Uint32:  1<<32 - 1,
+		Uint64:  1<<64 - 1,
+		Float32: 1.5,
+		Float64: -2.25e100,
+		Complex: complex(1, -1),
+		Bool:    true,
+		String:  "portable ✓",
+		Array:   [3]int{1, -2, 3},
+		Slice:   []int64{1 << 40, -1 << 40},
+		Map:     map[string]uint{"only": 7},
+		Ptr:     &ptr,
+		Any:     "held in an interface",
+		Renamed: "tagged",
+	}
+}
+
+// TestGolden checks that the encoding is identical on every platform, by
+// comparing against files which are committed to the repository
+func TestGolden(t *testing.T) {
+	data := portableValue()
+	encoded, err := gbin.NewEncoder[portableRecord]().Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := readGolden(t, "portable.gbin", encoded)
+	if !bytes.Equal(encoded, golden) {
+		t.Fatalf("encoding differs from golden file:\n% x\n% x", encoded, golden)
+	}
+	decoded, err := gbin.NewDecoder[portableRecord]().Decode(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, *decoded) {
+		t.Fatalf("expected %#v but got %#v", data, *decoded)
+	}
+}
+
+// TestGoldenCompact checks the compact format against a committed file in
+// the same way as TestGolden
+func TestGoldenCompact(t *testing.T) {
+	data := portableValue()
+	encoded, err := gbin.NewEncoder[portableRecord](gbin.WithCompact()).Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := readGolden(t, "compact.gbin", encoded)
+	if !bytes.Equal(encoded, golden) {
+		t.Fatalf("encoding differs from golden file:\n% x\n% x", encoded, golden)
+	}
+	decoded, err := gbin.NewDecoder[portableRecord]().Decode(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, *decoded) {
+		t.Fatalf("expected %#v but got %#v", data, *decoded)
+	}
+}
+
+// TestGoldenOverflow checks that an int which only fits in 64 bits decodes on
+// 64-bit platforms, and produces an error rather than truncating elsewhere
+func TestGoldenOverflow(t *testing.T) {
+	type wide struct {
+		Wide  int64
+		Int   int
+		Slice []uint
+	}
+	big := int64(1) << 40
+	data := wide{Wide: big, Int: 0, Slice: []uint{1}}
+	if strconv.IntSize == 64 {
+		data.Int = int(big)
+		data.Slice = []uint{uint(big)}
+	}
+	var encoded []byte
+	if strconv.IntSize == 64 {
+		var err error
+		encoded, err = gbin.NewEncoder[wide]().Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden := readGolden(t, "overflow.gbin", encoded)
+	decoded, err := gbin.NewDecoder[wide]().Decode(golden)
+	if strconv.IntSize == 64 {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(data, *decoded) {
+			t.Fatalf("expected %#v but got %#v", data, *decoded)
+		}
+	} else if err == nil || !strings.Contains(err.Error(), "overflows int") {
+		t.Fatalf("expected overflow error but got %v", err)
+	}
+
+	wideOnly, err := gbin.NewDecoder[struct{ Int int64 }]().Decode(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wideOnly.Int != big {
+		t.Fatalf("expected int to decode into int64 on every platform, got %d", wideOnly.Int)
+	}
+}
+
+// readGolden returns the contents of a golden file, first rewriting it with
+// encoded if the -update flag is set
+func readGolden(t *testing.T, name string, encoded []byte) []byte {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update && encoded != nil {
+		err := os.WriteFile(path, encoded, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return golden
+}
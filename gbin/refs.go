@@ -0,0 +1,170 @@
+package gbin
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// refKey identifies the target of a pointer. The type is included because a
+// struct and its first field share an address.
+type refKey struct {
+	addr uintptr
+	typ  reflect.Type
+}
+
+// encode_ref_ptr encodes a non nil pointer when references are enabled. The
+// first time a target is reached it is written as a REFPTR, which is
+// implicitly numbered by the order in which REFPTRs appear in the encoding.
+// Later pointers to the same target are written as a REF holding that number.
+//
+// PAYLOAD (REFPTR): ZERO VALUE, ENCODED VALUE POINTED AT
+// PAYLOAD (REF): BINARY ENCODED UINT64 REFERENCE NUMBER
+func (t *encodeTransformer) encode_ref_ptr(value reflect.Value) error {
+	key := refKey{value.Pointer(), value.Type()}
+	if id, seen := t.refs[key]; seen {
+		return t.encode_fixed("ref", REF, id)
+	}
+	id := uint64(len(t.refOrder))
+	t.refs[key] = id
+	t.refOrder = append(t.refOrder, key)
+	t.stack.Push("refptr")
+	err := t.format_container(REFPTR, func() error {
+		err := t.encode_zero(value.Type().Elem())
+		if err != nil {
+			return err
+		}
+		return t.encode(value.Elem())
+	})
+	if err != nil {
+		return err
+	}
+	t.stack.Pop()
+	return nil
+}
+
+// decode_ref_ptr decodes a REFPTR without a target type
+func (t *decodeTransformer) decode_ref_ptr(stop uint64) (*reflect.Value, error) {
+	t.stack.Push("refptr")
+	end := t.offset + stop
+	elType, _, err := t.zero()
+	if err != nil {
+		return nil, err
+	}
+	ptr := reflect.New(elType)
+	t.refs = append(t.refs, ptr)
+	inner, err := t.decode()
+	if err != nil {
+		return nil, err
+	}
+	err = t.expectEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	if inner.IsValid() {
+		if !inner.Type().AssignableTo(elType) {
+			return nil, mismatch(elType, inner.Type(), "referenced value of type %s does not match %s", inner.Type(), elType)
+		}
+		ptr.Elem().Set(*inner)
+	} else {
+		// the target is allocated before it is read, so that references
+		// within it resolve, and is not backed by the input when nil
+		err = t.construct(elType)
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.stack.Pop()
+	return &ptr, nil
+}
+
+// decode_ref decodes a REF, returning the pointer decoded for its REFPTR
+func (t *decodeTransformer) decode_ref(stop uint64) (*reflect.Value, error) {
+	t.stack.Push("ref")
+	var id uint64
+	err := t.readFixed(stop, &id)
+	if err != nil {
+		return nil, err
+	}
+	if t.detached {
+		return nil, fmt.Errorf("reference %d cannot be resolved from part way through the input; decode the value holding its target instead", id)
+	} else if id >= uint64(len(t.refs)) {
+		return nil, fmt.Errorf("reference %d has not been defined", id)
+	}
+	ptr := t.refs[id]
+	t.stack.Pop()
+	return &ptr, nil
+}
+
+// visit_ref_ptr decodes a REFPTR into a pointer target. The pointer is
+// allocated and recorded before the value it points to is decoded, so that
+// references back to it from within that value resolve to the same pointer.
+func (a *assigner) visit_ref_ptr(target reflect.Value, payloadLen uint64) error {
+	ref := target.Type()
+	if ref.Kind() != reflect.Pointer {
+		return mismatch(ref.Kind(), reflect.Pointer, "type %s does not match reference type of %s", reflect.Pointer, ref.Kind())
+	}
+	a.stack.Push("refptr")
+	end := a.tf.offset + payloadLen
+	err := a.skip_zeros(1)
+	if err != nil {
+		return err
+	}
+	ptr := reflect.New(ref.Elem())
+	a.tf.refs = append(a.tf.refs, ptr)
+	err = a.visit(ptr.Elem())
+	if err != nil {
+		return err
+	}
+	err = a.tf.expectEnd(end)
+	if err != nil {
+		return err
+	}
+	target.Set(ptr)
+	a.stack.Pop()
+	return nil
+}
+
+// visit_ref decodes a REF into a pointer target
+func (a *assigner) visit_ref(target reflect.Value, payloadLen uint64) error {
+	ptr, err := a.tf.decode_ref(payloadLen)
+	if err != nil {
+		return err
+	}
+	if !ptr.Type().AssignableTo(target.Type()) {
+		return mismatch(target.Type(), ptr.Type(), "reference to %s cannot be assigned to %s", ptr.Type(), target.Type())
+	}
+	target.Set(*ptr)
+	return nil
+}
+
+// mapEntry is a key of a map and the value stored under it
+type mapEntry struct {
+	key, value reflect.Value
+}
+
+// map_entries returns the entries of m, in the order of their encodings if the
+// encoding is canonical. Entries are read together rather than by looking up
+// each key, which finds nothing for a NaN key. The order in which containers
+// are reached decides which of the lengths found while measuring belongs to
+// each, and with references which pointers are written in full, so the
+// entries of each map are fixed the first time it is walked to keep later
+// passes over it consistent with the measuring pass. Sorted entries are kept
+// in the same way, so that each map is only sorted once.
+func (t *encodeTransformer) map_entries(m reflect.Value) ([]mapEntry, error) {
+	if entries, ok := t.mapEntries[m.Pointer()]; ok && len(entries) == m.Len() {
+		return entries, nil
+	}
+	entries := make([]mapEntry, 0, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		entries = append(entries, mapEntry{iter.Key(), iter.Value()})
+	}
+	if t.opts.canonical {
+		err := t.sort_entries(m.Type(), entries)
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.mapEntries[m.Pointer()] = entries
+	return entries, nil
+}
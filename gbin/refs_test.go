@@ -0,0 +1,144 @@
+package gbin_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+type refNode struct {
+	Value    int
+	Parent   *refNode
+	Children []*refNode
+	Lookup   map[string]*refNode
+}
+
+type refTree struct {
+	Value    int
+	Children []*refTree
+}
+
+type refList struct {
+	Value int
+	Next  *refList
+	Prev  *refList
+}
+
+func TestSharedPointers(t *testing.T) {
+	type owners struct {
+		A, B  *int
+		Named map[string]*int
+	}
+	shared := 42
+	data := owners{A: &shared, B: &shared, Named: map[string]*int{"x": &shared, "y": &shared}}
+	encoded, err := gbin.NewEncoder[owners](gbin.WithReferences()).Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[owners]().Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, *decoded) {
+		t.Fatalf("expected %#v but got %#v", data, *decoded)
+	}
+	if decoded.A != decoded.B || decoded.Named["x"] != decoded.A || decoded.Named["y"] != decoded.A {
+		t.Fatal("shared pointers were not restored as shared")
+	}
+	*decoded.A = 7
+	if *decoded.Named["x"] != 7 {
+		t.Fatal("write through one pointer not visible through another")
+	}
+
+	plain, err := gbin.NewEncoder[owners]().Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := gbin.NewDecoder[owners]().Decode(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.A == copied.B {
+		t.Fatal("pointers were shared without references enabled")
+	}
+}
+
+func TestCycles(t *testing.T) {
+	root := &refNode{Value: 1, Lookup: map[string]*refNode{}}
+	for i := 2; i < 5; i++ {
+		child := &refNode{Value: i, Parent: root}
+		root.Children = append(root.Children, child)
+		root.Lookup[string(rune('a'+i))] = child
+	}
+	root.Lookup["self"] = root
+
+	encoded, err := gbin.NewEncoder[*refNode](gbin.WithReferences()).Encode(&root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[*refNode]().Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := *decoded
+	if got.Value != 1 || len(got.Children) != 3 || got.Lookup["self"] != got {
+		t.Fatalf("unexpected root %#v", got)
+	}
+	for i, child := range got.Children {
+		if child.Value != i+2 || child.Parent != got {
+			t.Fatalf("child %d does not point back to the root", i)
+		}
+		if got.Lookup[string(rune('a'+i+2))] != child {
+			t.Fatalf("child %d is not shared with the lookup", i)
+		}
+	}
+
+	_, err = gbin.NewEncoder[*refNode]().Encode(&root)
+	if err == nil {
+		t.Fatal("expected cycle without references to fail")
+	}
+}
+
+func TestRecursiveType(t *testing.T) {
+	data := refTree{Value: 1, Children: []*refTree{{Value: 2, Children: []*refTree{}}, {Value: 3, Children: []*refTree{{Value: 4, Children: []*refTree{}}}}}}
+	if pass := runTest(data); !pass {
+		t.Fail()
+	}
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded any
+	if err := gbin.Unmarshal(encoded, &decoded); err == nil {
+		t.Fatal("expected decoding a recursive type without a target type to fail")
+	}
+}
+
+func TestLongChain(t *testing.T) {
+	head := &refList{}
+	tail := head
+	for i := 1; i < 10000; i++ {
+		next := &refList{Value: i, Prev: tail}
+		tail.Next = next
+		tail = next
+	}
+	encoded, err := gbin.NewEncoder[*refList](gbin.WithReferences()).Encode(&head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gbin.NewDecoder[*refList]().Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for node := *decoded; node != nil; node = node.Next {
+		if node.Value != count || (node.Next != nil && node.Next.Prev != node) {
+			t.Fatalf("list broken at %d", count)
+		}
+		count++
+	}
+	if count != 10000 {
+		t.Fatalf("expected 10000 nodes but found %d", count)
+	}
+}
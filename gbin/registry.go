@@ -0,0 +1,146 @@
+package gbin
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Values held by interfaces are written as TYPED values, with the name their
+// concrete type is registered under, so that they decode as that type. The
+// values of unregistered types are written as INTERFACE values instead, and
+// decoded as the type their encoding describes, which loses named types.
+//
+// PAYLOAD (TYPED): STRING TYPE NAME, ENCODED VALUE
+
+var registry = struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+	names map[reflect.Type]string
+}{
+	types: map[string]reflect.Type{},
+	names: map[reflect.Type]string{},
+}
+
+// Register records the concrete type of value under name, so that values of
+// the type held by interfaces are decoded as it. Types must be registered
+// before values of them are encoded in or decoded into an interface, and by
+// the same name wherever the data is read; pointer types are registered
+// separately from the types they point to. Like encoding/gob, Register panics
+// if the type or name is already registered differently.
+func Register(name string, value any) {
+	if name == "" {
+		panic("gbin: cannot register a type with an empty name")
+	}
+	if value == nil {
+		panic("gbin: cannot register the type of a nil value")
+	}
+	rt := reflect.TypeOf(value)
+	registry.Lock()
+	defer registry.Unlock()
+	if other, ok := registry.types[name]; ok && other != rt {
+		panic(fmt.Sprintf("gbin: registering duplicate types for %q: %s != %s", name, other, rt))
+	}
+	if other, ok := registry.names[rt]; ok && other != name {
+		panic(fmt.Sprintf("gbin: registering duplicate names for %s: %q != %q", rt, other, name))
+	}
+	registry.types[name] = rt
+	registry.names[rt] = name
+}
+
+func registeredName(rt reflect.Type) (string, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	name, ok := registry.names[rt]
+	return name, ok
+}
+
+func registeredType(name string) (reflect.Type, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	rt, ok := registry.types[name]
+	return rt, ok
+}
+
+// encode_typed encodes v, the value held by an interface, as a TYPED value if
+// its type is registered
+func (t *encodeTransformer) encode_typed(v reflect.Value) error {
+	name, ok := registeredName(v.Type())
+	if !ok {
+		// nil within an interface is read as a nil interface, losing the type
+		// which an empty value keeps
+		return t.format_container(INTERFACE, func() error {
+			return t.encode(t.emptyIfNil(v))
+		})
+	}
+	t.stack.Push(fmt.Sprintf("typed(%s)", name))
+	err := t.format_container(TYPED, func() error {
+		err := t.encode_string(name)
+		if err != nil {
+			return err
+		}
+		return t.encode(v)
+	})
+	if err != nil {
+		return err
+	}
+	t.stack.Pop()
+	return nil
+}
+
+// read_type reads the name of a TYPED value, returning the type registered
+// under it
+func (t *decodeTransformer) read_type() (reflect.Type, error) {
+	name, err := t.read_name("type name")
+	if err != nil {
+		return nil, err
+	}
+	rt, ok := registeredType(name)
+	if !ok {
+		return nil, fmt.Errorf("type name %q is not registered", name)
+	}
+	return rt, nil
+}
+
+// decode_typed decodes a TYPED value without a target type, as a new value of
+// its registered type
+func (t *decodeTransformer) decode_typed(stop uint64) (*reflect.Value, error) {
+	t.stack.Push("typed")
+	end := t.offset + stop
+	rt, err := t.read_type()
+	if err != nil {
+		return nil, err
+	}
+	decoded := reflect.New(rt).Elem()
+	err = newAssigner(t).visit(decoded)
+	if err != nil {
+		return nil, err
+	}
+	err = t.expectEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	t.stack.Pop()
+	return &decoded, nil
+}
+
+// visit_typed decodes a TYPED value into a target which is not an interface,
+// which the value is decoded into directly whatever its registered type
+func (a *assigner) visit_typed(target reflect.Value, payloadLen uint64) error {
+	a.stack.Push("typed")
+	end := a.tf.offset + payloadLen
+	_, err := a.tf.read_name("type name")
+	if err != nil {
+		return err
+	}
+	err = a.visit(target)
+	if err != nil {
+		return err
+	}
+	err = a.tf.expectEnd(end)
+	if err != nil {
+		return err
+	}
+	a.stack.Pop()
+	return nil
+}
@@ -0,0 +1,142 @@
+package gbin_test
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+type shape interface {
+	Area() float64
+}
+
+type circle struct {
+	R float64
+}
+
+func (c *circle) Area() float64 { return math.Pi * c.R * c.R }
+
+type square struct {
+	S float64
+}
+
+func (s square) Area() float64 { return s.S * s.S }
+
+type triangle struct {
+	B, H float64
+}
+
+func (t triangle) Area() float64 { return t.B * t.H / 2 }
+
+func init() {
+	gbin.Register("circle", &circle{})
+	gbin.Register("square", square{})
+}
+
+type drawing struct {
+	Main   shape
+	All    []shape
+	ByName map[string]shape
+	Any    any
+	None   shape
+}
+
+func TestRegisteredInterfaces(t *testing.T) {
+	c := &circle{R: 2}
+	data := drawing{
+		Main:   c,
+		All:    []shape{square{S: 3}, c},
+		ByName: map[string]shape{"c": &circle{R: 1}},
+		Any:    square{S: 1},
+	}
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}, {gbin.WithSchema()}} {
+		if pass := runTest(data, opts...); !pass {
+			t.Fatal("failed to round trip registered types")
+		}
+	}
+	// pointers to registered types keep their identity WithReferences
+	for _, opts := range [][]gbin.Option{{gbin.WithReferences()}, {gbin.WithReferences(), gbin.WithCompact()}} {
+		encoded, err := gbin.NewEncoder[drawing](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := gbin.NewDecoder[drawing]().Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Main.(*circle) != decoded.All[1].(*circle) {
+			t.Fatal("expected the shared circle to decode to one pointer")
+		}
+	}
+	// without a target type, registered values decode as their type
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var untyped any
+	err = gbin.Unmarshal(encoded, &untyped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := reflect.ValueOf(untyped).FieldByName("All").Interface().([]any)
+	if !reflect.DeepEqual([]any{data.All[0], data.All[1]}, all) {
+		t.Fatalf("expected %#v, got %#v", data.All, all)
+	}
+	tree, err := gbin.DecodeDynamic(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main := tree.(map[string]any)["Main"]; !reflect.DeepEqual(main, map[string]any{"R": 2.0}) {
+		t.Fatalf("expected the circle to decode dynamically as its fields, got %#v", main)
+	}
+}
+
+func TestUnregisteredInterfaces(t *testing.T) {
+	data := drawing{Main: triangle{B: 1, H: 2}}
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gbin.NewDecoder[drawing]().Decode(encoded)
+	if err == nil || !strings.Contains(err.Error(), "gbin.Register") {
+		t.Fatalf("expected an error suggesting registration, got %v", err)
+	}
+
+	w := gbin.NewWriter()
+	start := w.Begin(gbin.TYPED)
+	w.String("hexagon")
+	w.Int(6)
+	w.End(start)
+	encoded, err = w.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value any
+	err = gbin.Unmarshal(encoded, &value)
+	if err == nil || !strings.Contains(err.Error(), `type name "hexagon" is not registered`) {
+		t.Fatalf("expected an error for an unregistered name, got %v", err)
+	}
+}
+
+func TestRegisterConflicts(t *testing.T) {
+	// registering the same name and type again is allowed
+	gbin.Register("circle", &circle{})
+	for _, register := range []func(){
+		func() { gbin.Register("circle", square{}) },
+		func() { gbin.Register("box", square{}) },
+		func() { gbin.Register("", triangle{}) },
+		func() { gbin.Register("nil", nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected Register to panic")
+				}
+			}()
+			register()
+		}()
+	}
+}
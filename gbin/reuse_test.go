@@ -0,0 +1,128 @@
+package gbin_test
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+type reusable struct {
+	Name    string
+	Scores  []float64
+	Attrs   map[string]int32
+	Nested  []reusable
+	Ptr     *int
+	Grid    [2][]int
+	Limit   int `gbin:",default=7"`
+	Removed []string
+}
+
+func TestDecodeInto(t *testing.T) {
+	n := 3
+	data := reusable{
+		Name:   "new",
+		Scores: []float64{1, 2},
+		Attrs:  map[string]int32{"a": 1},
+		Nested: []reusable{{Name: "child", Scores: []float64{3}}},
+		Ptr:    &n,
+		Grid:   [2][]int{{1}, {2, 3}},
+	}
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}} {
+		encoded, err := gbin.NewEncoder[reusable](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := gbin.NewDecoder[reusable]().Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := 9
+		dst := reusable{
+			Name:    "old",
+			Scores:  make([]float64, 5, 8),
+			Attrs:   map[string]int32{"stale": 2},
+			Nested:  []reusable{{Name: "stale", Attrs: map[string]int32{"x": 1}}, {}},
+			Ptr:     &old,
+			Limit:   100,
+			Removed: []string{"stale"},
+		}
+		scores := &dst.Scores[:1][0]
+		attrs := reflect.ValueOf(dst.Attrs).Pointer()
+		err = gbin.NewDecoder[reusable]().DecodeInto(encoded, &dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*expected, dst) {
+			t.Fatalf("expected %+v, got %+v", *expected, dst)
+		}
+		if &dst.Scores[0] != scores {
+			t.Fatal("expected the backing array of the slice to be reused")
+		}
+		if reflect.ValueOf(dst.Attrs).Pointer() != attrs {
+			t.Fatal("expected the map to be reused")
+		}
+		if old != 9 {
+			t.Fatal("expected the value pointed to not to be overwritten")
+		}
+	}
+}
+
+func TestStreamReadInto(t *testing.T) {
+	records := [][]string{{"a", "b", "c"}, {"d"}, {}}
+	buf := bytes.NewBuffer([]byte{})
+	sw := gbin.NewEncoder[[]string]().NewStreamWriter(buf)
+	for i := range records {
+		if err := sw.Write(&records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sr := gbin.NewDecoder[[]string]().NewStreamReader(buf)
+	var dst []string
+	for i := 0; ; i++ {
+		err := sr.ReadInto(&dst)
+		if err == io.EOF {
+			if i != len(records) {
+				t.Fatalf("expected %d records but read %d", len(records), i)
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(records[i], dst) {
+			t.Fatalf("expected %v but got %v", records[i], dst)
+		}
+	}
+}
+
+func TestUnmarshalFailureLeavesTarget(t *testing.T) {
+	encoded, err := gbin.Marshal(struct{ Scores []string }{[]string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := reusable{Name: "kept"}
+	if err := gbin.Unmarshal(encoded, &dst); err == nil {
+		t.Fatal("expected decoding strings into floats to fail")
+	}
+	if !reflect.DeepEqual(dst, reusable{Name: "kept"}) {
+		t.Fatalf("expected the target to be unchanged, got %+v", dst)
+	}
+}
+
+func BenchmarkDecodeInto(b *testing.B) {
+	data := benchRecords()
+	encoded, err := gbin.Marshal(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	decoder := gbin.NewDecoder[[]benchRecord]()
+	var dst []benchRecord
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := decoder.DecodeInto(encoded, &dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package gbin
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A schema is written WithSchema as a SCHEMA value ahead of the value it
+// describes, and ahead of the FORMAT byte of compact encodings, so always has
+// a standard header. Its payload is the type descriptor of the value, as used
+// by the compact format, whichever format the value itself is in.
+//
+// PAYLOAD (SCHEMA): DESCRIPTOR
+
+// ErrNoSchema is returned by ReadSchema for data encoded without a schema
+var ErrNoSchema = errors.New("data has no schema")
+
+// Schema describes the type of an encoded value
+type Schema struct {
+	// Kind is the kind of the value. Values with custom encodings, which
+	// each carry their own type, are described as reflect.Interface, and a
+	// recursive type within itself as reflect.Invalid.
+	Kind reflect.Kind
+	// Len is the length of arrays
+	Len int
+	// Key is the key type of maps
+	Key *Schema
+	// Elem is the element type of pointers, slices, arrays and maps
+	Elem *Schema
+	// Fields are the fields of structs, under their encoded names
+	Fields []SchemaField
+}
+
+// SchemaField is a field of a struct Schema
+type SchemaField struct {
+	Name string
+	Type *Schema
+}
+
+// String describes s in the syntax of Go types
+func (s *Schema) String() string {
+	switch s.Kind {
+	case reflect.Invalid:
+		return "<recursive>"
+	case reflect.Interface:
+		return "any"
+	case reflect.Pointer:
+		return "*" + s.Elem.String()
+	case reflect.Slice:
+		return "[]" + s.Elem.String()
+	case reflect.Array:
+		return fmt.Sprintf("[%d]%s", s.Len, s.Elem)
+	case reflect.Map:
+		return fmt.Sprintf("map[%s]%s", s.Key, s.Elem)
+	case reflect.Struct:
+		fields := []string{}
+		for _, field := range s.Fields {
+			fields = append(fields, fmt.Sprintf("%s %s", field.Name, field.Type))
+		}
+		if len(fields) == 0 {
+			return "struct {}"
+		}
+		return fmt.Sprintf("struct { %s }", strings.Join(fields, "; "))
+	default:
+		return s.Kind.String()
+	}
+}
+
+// schemaOf converts a type descriptor to a Schema
+func schemaOf(desc *typeDesc) *Schema {
+	if desc == nil {
+		return nil
+	}
+	s := &Schema{
+		Len:  int(desc.length),
+		Key:  schemaOf(desc.key),
+		Elem: schemaOf(desc.elem),
+	}
+	if desc.code != INVALID {
+		s.Kind = controlKind[desc.code]
+	}
+	for _, field := range desc.fields {
+		s.Fields = append(s.Fields, SchemaField{field.name, schemaOf(field.desc)})
+	}
+	return s
+}
+
+// goType creates a Go type for values described by s, with which values of a
+// generic tree are encoded. Struct fields are given generated names and tagged
+// with their encoded names, and values described as any are held raw.
+func (s *Schema) goType() (reflect.Type, error) {
+	if s == nil {
+		return nil, fmt.Errorf("schema is missing a type")
+	}
+	switch s.Kind {
+	case reflect.Invalid:
+		return nil, fmt.Errorf("cannot encode a recursive type from its schema")
+	case reflect.Interface:
+		return reflect.TypeOf(dynamicValue{}), nil
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		elem, err := s.Elem.goType()
+		if err != nil {
+			return nil, err
+		}
+		if s.Kind == reflect.Pointer {
+			return reflect.PointerTo(elem), nil
+		} else if s.Kind == reflect.Slice {
+			return reflect.SliceOf(elem), nil
+		} else if s.Len < 0 {
+			return nil, fmt.Errorf("schema has an array of negative length %d", s.Len)
+		}
+		return arrayOf(uint64(s.Len), elem)
+	case reflect.Map:
+		key, err := s.Key.goType()
+		if err != nil {
+			return nil, err
+		}
+		if !key.Comparable() {
+			return nil, fmt.Errorf("found illegal key type for map: %s", s.Key)
+		}
+		elem, err := s.Elem.goType()
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, elem), nil
+	case reflect.Struct:
+		fields := []reflect.StructField{}
+		for i, field := range s.Fields {
+			ft, err := field.Type.goType()
+			if err != nil {
+				return nil, err
+			}
+			tag := field.Name
+			if tag == "" || strings.Contains(tag, ",") {
+				return nil, fmt.Errorf("schema has a field named %q, which cannot be encoded", tag)
+			} else if tag == "-" {
+				tag = "-,"
+			}
+			fields = append(fields, reflect.StructField{
+				Name: fmt.Sprintf("F%d", i),
+				Type: ft,
+				Tag:  reflect.StructTag(fmt.Sprintf("gbin:%q", tag)),
+			})
+		}
+		return structOf(fields)
+	}
+	if typ, ok := kindComparableType[s.Kind]; ok {
+		return typ, nil
+	}
+	return nil, fmt.Errorf("schema has unsupported kind %s", s.Kind)
+}
+
+// ReadSchema returns the schema written ahead of data, which must have been
+// encoded WithSchema. Options are only needed to read envelopes compressed
+// by a Compression other than Gzip and Flate.
+func ReadSchema(data []byte, opts ...Option) (schema *Schema, err error) {
+	tf := newDecodeTransformer(bufio.NewReader(bytes.NewReader(data)), newTrace(), newOptions(opts))
+	tf.sized, tf.size = true, uint64(len(data))
+	defer recoverError(&err, func(err error) error {
+		return decodeError(tf, err)
+	})
+	env, err := tf.open_envelope()
+	if err != nil {
+		return nil, decodeError(tf, err)
+	} else if env != nil {
+		tf = env.payload
+	}
+	err = tf.begin()
+	if err != nil {
+		return nil, decodeError(tf, err)
+	}
+	if tf.schema == nil {
+		return nil, ErrNoSchema
+	}
+	return schemaOf(tf.schema), nil
+}
+
+// encode_schema writes a SCHEMA value describing values of type zt
+func (t *encodeTransformer) encode_schema(zt reflect.Type) error {
+	t.stack.Push("schema")
+	buf := bytes.NewBuffer([]byte{})
+	opts := *t.opts
+	opts.compact = true
+	inner := newEncodeTransformer(bufio.NewWriter(buf), &opts)
+	err := inner.encode_descriptor(zt)
+	if err == nil {
+		err = inner.w.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	// the header is in the standard format, as the format of the value is
+	// only detected after its schema
+	err = t.write(append(appendHeader(nil, SCHEMA, uint64(buf.Len())), buf.Bytes()...))
+	if err != nil {
+		return err
+	}
+	t.stack.Pop()
+	return nil
+}
+
+// read_schema reads the descriptor held by a SCHEMA value
+func (t *decodeTransformer) read_schema() (*typeDesc, error) {
+	t.stack.Push("schema")
+	_, payloadLen, err := t.header()
+	if err != nil {
+		return nil, err
+	}
+	inner, err := t.embedded(payloadLen)
+	if err != nil {
+		return nil, err
+	}
+	inner.compact = true
+	desc, err := inner.descriptor()
+	if err != nil {
+		return nil, err
+	}
+	if inner.offset != payloadLen {
+		return nil, fmt.Errorf("schema is followed by %d bytes", payloadLen-inner.offset)
+	}
+	t.stack.Pop()
+	return desc, nil
+}
@@ -0,0 +1,483 @@
+package gbin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned, wrapped in an *Error, when a View is stepped into
+// a struct field, element or map entry which the encoded value does not have
+var ErrNotFound = errors.New("value not found")
+
+// View is an encoded value which is only decoded as far as it is navigated.
+// Stepping into a struct field, element or map entry skips the values before
+// it by their length prefixes without decoding them, so that a single value
+// can be read cheaply out of a large encoding. Pointers, interfaces and
+// registered types are passed through as if they were the value they hold.
+//
+// A View reads the data it was created from, which must not be modified
+// while the View or any View stepped to from it is in use. Views are
+// immutable, so may be shared between goroutines.
+type View struct {
+	data    []byte
+	offset  uint64
+	compact bool
+	// packed is set for an element of the compact format written without a
+	// header, whose type is given by code
+	packed bool
+	code   EncodedType
+	opts   *options
+	path   []PathElem
+	// detached is set for values within the one encoded, from which
+	// references encoded WithReferences cannot be resolved, as they are
+	// numbered from the start of the encoding
+	detached bool
+}
+
+// NewView creates a View of the value encoded in data, which may be in
+// either format and have a schema or be in an envelope. Options limiting
+// decoding and giving compressions apply.
+func NewView(data []byte, opts ...Option) (view *View, err error) {
+	tf := newDecodeTransformer(getReader(bytes.NewReader(data)), newTrace(), newOptions(opts))
+	defer putReader(tf.data)
+	defer recoverError(&err, func(err error) error {
+		return decodeError(tf, err)
+	})
+	tf.sized, tf.size = true, uint64(len(data))
+	env, err := tf.open_envelope()
+	if err != nil {
+		return nil, decodeError(tf, err)
+	} else if env != nil {
+		data = env.data
+		tf = env.payload
+	}
+	err = tf.begin()
+	if err != nil {
+		return nil, decodeError(tf, err)
+	}
+	return &View{data: data, offset: tf.offset, compact: tf.compact, opts: tf.opts}, nil
+}
+
+// DecodePath decodes the value at path in data, given as by ParsePath, into
+// a value of type T. Only the values leading to it are read.
+func DecodePath[T any](data []byte, path string, opts ...Option) (T, error) {
+	var decoded T
+	view, err := NewView(data, opts...)
+	if err == nil {
+		view, err = view.Path(path)
+	}
+	if err == nil {
+		err = view.Decode(&decoded)
+	}
+	return decoded, err
+}
+
+// Path returns a View of the value at path within v, given as by ParsePath
+func (v *View) Path(path string) (*View, error) {
+	elems, err := ParsePath(path)
+	if err != nil {
+		return nil, wrapDecode(err)
+	}
+	for _, elem := range elems {
+		v, err = v.Step(elem)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// Step returns a View of the value which elem steps to within v. A PathIndex
+// step into a map finds the entry whose key is the index.
+func (v *View) Step(elem PathElem) (*View, error) {
+	switch elem.Kind {
+	case PathField:
+		return v.Field(elem.Field)
+	case PathIndex:
+		return v.Index(elem.Index)
+	default:
+		return v.Key(elem.Key)
+	}
+}
+
+// Field returns a View of the struct field encoded as name
+func (v *View) Field(name string) (*View, error) {
+	elem := PathElem{Kind: PathField, Field: name}
+	return v.find(&elem, func(tf *decodeTransformer, objectType EncodedType, end uint64) (*View, error) {
+		if objectType != STRUCT {
+			return nil, mismatch(reflect.Struct, controlName(objectType), "cannot take field %s of %s", name, controlName(objectType))
+		}
+		for tf.offset < end {
+			key, err := tf.read_name("struct key")
+			if err != nil {
+				return nil, err
+			}
+			if key == name {
+				return v.child(tf, elem, nil), nil
+			}
+			err = tf.skip_value()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("%w: struct has no field %s", ErrNotFound, name)
+	})
+}
+
+// Index returns a View of the element at index i of a slice or array, or of
+// the entry of a map whose key is i
+func (v *View) Index(i int) (*View, error) {
+	elem := PathElem{Kind: PathIndex, Index: i}
+	return v.find(&elem, func(tf *decodeTransformer, objectType EncodedType, end uint64) (*View, error) {
+		if objectType == MAP {
+			return v.entry(tf, end, elem, i)
+		} else if objectType == INVALID {
+			return nil, fmt.Errorf("%w: index %d is out of range of nil", ErrNotFound, i)
+		} else if objectType != SLICE && objectType != ARRAY {
+			return nil, mismatch(reflect.Slice, controlName(objectType), "cannot index %s", controlName(objectType))
+		}
+		descs, err := tf.element_types(1)
+		if err != nil {
+			return nil, err
+		}
+		for n := 0; tf.offset < end && i >= 0; n++ {
+			if n == i {
+				return v.child(tf, elem, descs[0]), nil
+			}
+			err = tf.skip_element(descs[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("%w: index %d is out of range", ErrNotFound, i)
+	})
+}
+
+// Key returns a View of the value of the map entry with the given key.
+// Numeric keys match keys of any numeric type with the same value, and
+// strings match keys of other scalar types as they are parsed in struct tag
+// defaults, and struct and array keys as a CompositeKey does.
+func (v *View) Key(key any) (*View, error) {
+	elem := PathElem{Kind: PathKey, Key: key}
+	return v.find(&elem, func(tf *decodeTransformer, objectType EncodedType, end uint64) (*View, error) {
+		if objectType == INVALID {
+			return nil, fmt.Errorf("%w: nil map has no key %v", ErrNotFound, key)
+		} else if objectType != MAP {
+			return nil, mismatch(reflect.Map, controlName(objectType), "cannot look up key %v in %s", key, controlName(objectType))
+		}
+		return v.entry(tf, end, elem, key)
+	})
+}
+
+// Kind returns the kind of the value, which is reflect.Invalid for nil and
+// reflect.Slice for the bytes written by binary marshaling methods
+func (v *View) Kind() (reflect.Kind, error) {
+	if v.packed {
+		return controlKind[v.code], nil
+	}
+	var kind reflect.Kind
+	_, err := v.find(nil, func(tf *decodeTransformer, objectType EncodedType, end uint64) (*View, error) {
+		if objectType == BYTES {
+			kind = reflect.Slice
+		} else {
+			kind = controlKind[objectType]
+		}
+		return nil, nil
+	})
+	return kind, err
+}
+
+// Len returns the number of fields of a struct, elements of a slice or
+// array, or entries of a map, counting them without decoding them. The
+// length of nil, such as a nil slice or map, is 0.
+func (v *View) Len() (int, error) {
+	n := 0
+	_, err := v.find(nil, func(tf *decodeTransformer, objectType EncodedType, end uint64) (*View, error) {
+		// struct fields are a name and a value, skipped as map entries are
+		descs := []*typeDesc{nil, nil}
+		var err error
+		switch objectType {
+		case INVALID:
+			return nil, nil
+		case STRUCT:
+		case SLICE, ARRAY:
+			descs, err = tf.element_types(1)
+		case MAP:
+			descs, err = tf.element_types(2)
+		default:
+			return nil, mismatch(reflect.Slice, controlName(objectType), "cannot take the length of %s", controlName(objectType))
+		}
+		for ; err == nil && tf.offset < end; n++ {
+			err = tf.skip_element(descs[0])
+			if err == nil && len(descs) == 2 {
+				err = tf.skip_element(descs[1])
+			}
+		}
+		return nil, err
+	})
+	return n, err
+}
+
+// Decode decodes the value into the value pointed to by target, as Unmarshal
+// does. References encoded WithReferences can only be decoded from the View
+// returned by NewView, as they are numbered from the start of the encoding.
+func (v *View) Decode(target any) (err error) {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Pointer || value.IsNil() {
+		return wrapDecode(fmt.Errorf("decode target must be a non nil pointer, not %T", target))
+	}
+	tf := v.open()
+	defer putReader(tf.data)
+	defer recoverError(&err, func(err error) error {
+		return decodeError(tf, err)
+	})
+	as := newAssigner(tf)
+	if v.packed {
+		err = as.visit_element(value.Elem(), &typeDesc{code: v.code})
+	} else {
+		err = as.visit(value.Elem())
+	}
+	return decodeError(tf, err)
+}
+
+// open returns a transformer reading from the start of the view's value
+func (v *View) open() *decodeTransformer {
+	tf := newDecodeTransformer(getReader(bytes.NewReader(v.data[v.offset:])), newTrace(), v.opts)
+	for _, elem := range v.path {
+		pushElem(tf.stack, elem)
+	}
+	tf.offset, tf.sized, tf.size = v.offset, true, uint64(len(v.data))
+	tf.compact, tf.detached = v.compact, v.detached
+	return tf
+}
+
+// find reads the header of the view's value, passing through the values
+// which wrap it, and calls step with the type of the value and the offset at
+// which its payload ends. A step of elem is added to the path of errors.
+func (v *View) find(elem *PathElem, step func(tf *decodeTransformer, objectType EncodedType, end uint64) (*View, error)) (view *View, err error) {
+	tf := v.open()
+	defer putReader(tf.data)
+	defer recoverError(&err, func(err error) error {
+		return decodeError(tf, err)
+	})
+	if v.packed {
+		return nil, decodeError(tf, mismatch(reflect.Struct, controlKind[v.code], "cannot step into %s", controlName(v.code)))
+	}
+	objectType, payloadLen, err := tf.unwrap()
+	if err != nil {
+		return nil, decodeError(tf, err)
+	}
+	view, err = step(tf, objectType, tf.offset+payloadLen)
+	if err != nil && elem != nil && errors.Is(err, ErrNotFound) {
+		pushElem(tf.stack, *elem)
+	}
+	return view, decodeError(tf, err)
+}
+
+// child returns a View of the value at the position of tf, which is a packed
+// element if desc describes one
+func (v *View) child(tf *decodeTransformer, elem PathElem, desc *typeDesc) *View {
+	child := &View{data: v.data, offset: tf.offset, compact: tf.compact, opts: v.opts, detached: true}
+	child.code, child.packed = packed(desc)
+	child.path = append(append([]PathElem{}, v.path...), elem)
+	return child
+}
+
+// entry finds the value of the map entry with the given key, reading the map
+// from its descriptors
+func (v *View) entry(tf *decodeTransformer, end uint64, elem PathElem, key any) (*View, error) {
+	descs, err := tf.element_types(2)
+	if err != nil {
+		return nil, err
+	}
+	for tf.offset < end {
+		k, err := tf.decode_element(descs[0])
+		if err != nil {
+			return nil, err
+		}
+		if matchKey(key, k) {
+			return v.child(tf, elem, descs[1]), nil
+		}
+		err = tf.skip_element(descs[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: map has no key %v", ErrNotFound, key)
+}
+
+// unwrap reads the header of the next value, passing through the pointers,
+// interfaces, registered types and embedded encodings which wrap it
+func (t *decodeTransformer) unwrap() (EncodedType, uint64, error) {
+	for {
+		objectType, payloadLen, err := t.header()
+		if err != nil {
+			return INVALID, 0, err
+		}
+		switch objectType {
+		case INTERFACE:
+		case TYPED:
+			_, err = t.read_name("type name")
+		case PTR, REFPTR:
+			_, err = t.element_types(1)
+		case EMBEDDED:
+			t.compact = false
+		case REF:
+			return INVALID, 0, fmt.Errorf("cannot follow a reference encoded WithReferences; decode the value holding it instead")
+		default:
+			return objectType, payloadLen, nil
+		}
+		if err != nil {
+			return INVALID, 0, err
+		}
+	}
+}
+
+// skip_element discards the next element of a container described by desc
+func (t *decodeTransformer) skip_element(desc *typeDesc) error {
+	if code, ok := packed(desc); ok {
+		_, err := t.read_packed(code)
+		return err
+	}
+	return t.skip_value()
+}
+
+// matchKey reports whether the map key decoded is the key looked up
+func matchKey(key any, decoded *reflect.Value) bool {
+	kv := reflect.ValueOf(key)
+	if !kv.IsValid() || !decoded.IsValid() {
+		return !kv.IsValid() && !decoded.IsValid()
+	}
+	if ck, ok := key.(CompositeKey); ok {
+		kv = reflect.ValueOf(string(ck))
+	}
+	if kv.Kind() == reflect.String && decoded.Kind() != reflect.String {
+		parsed, ok := parseKey(kv.String(), decoded.Type())
+		if !ok {
+			return false
+		}
+		kv = parsed
+	}
+	switch {
+	case kv.CanInt() && decoded.CanInt():
+		return kv.Int() == decoded.Int()
+	case kv.CanUint() && decoded.CanUint():
+		return kv.Uint() == decoded.Uint()
+	case kv.CanInt() && decoded.CanUint():
+		return kv.Int() >= 0 && uint64(kv.Int()) == decoded.Uint()
+	case kv.CanUint() && decoded.CanInt():
+		return decoded.Int() >= 0 && kv.Uint() == uint64(decoded.Int())
+	case kv.CanFloat() && decoded.CanFloat():
+		return kv.Float() == decoded.Float()
+	case kv.Kind() == decoded.Kind() && kv.Type().ConvertibleTo(decoded.Type()) && kv.Type().Comparable():
+		return kv.Convert(decoded.Type()).Interface() == decoded.Interface()
+	}
+	return false
+}
+
+// parseKey parses a key given as a string into typ, a scalar parsed as struct
+// tag defaults are or a struct or array written as JSON
+func parseKey(s string, typ reflect.Type) (reflect.Value, bool) {
+	if typ.Kind() != reflect.Struct && typ.Kind() != reflect.Array {
+		parsed, err := parseDefault(typ, s)
+		if err != nil {
+			return reflect.Value{}, false
+		}
+		return *parsed, true
+	}
+	tree, err := keyTree(s, "key")
+	if err != nil {
+		return reflect.Value{}, false
+	}
+	parsed := reflect.New(typ).Elem()
+	if newTreeAssigner(newOptions(nil)).assign(parsed, tree, "key") != nil {
+		return reflect.Value{}, false
+	}
+	return parsed, true
+}
+
+// pushElem enters the step elem on tr
+func pushElem(tr *trace, elem PathElem) {
+	switch elem.Kind {
+	case PathField:
+		tr.Field(elem.Field)
+	case PathIndex:
+		tr.Index(elem.Index)
+	default:
+		tr.Key(reflect.ValueOf(elem.Key))
+	}
+}
+
+// ParsePath parses a path written as FormatPath writes it, such as
+// .Items[2].Name or Lookup["k"]. Bracketed integers are indexes, quoted
+// strings and other text in brackets are map keys, and the leading dot may be
+// left out.
+func ParsePath(path string) ([]PathElem, error) {
+	elems := []PathElem{}
+	rest := path
+	for i := 0; rest != ""; i++ {
+		switch {
+		case rest[0] == '[':
+			elem, n, err := parseBracket(rest)
+			if err != nil {
+				return nil, fmt.Errorf("path %q: %s", path, err.Error())
+			}
+			elems = append(elems, elem)
+			rest = rest[n:]
+		case rest[0] == '.' || i == 0:
+			rest = strings.TrimPrefix(rest, ".")
+			n := strings.IndexAny(rest, ".[]")
+			if n < 0 {
+				n = len(rest)
+			}
+			if n == 0 {
+				return nil, fmt.Errorf("path %q has an empty field name", path)
+			}
+			elems = append(elems, PathElem{Kind: PathField, Field: rest[:n]})
+			rest = rest[n:]
+		default:
+			return nil, fmt.Errorf("path %q has unexpected text %q", path, rest)
+		}
+	}
+	return elems, nil
+}
+
+// parseBracket parses the bracketed step which begins s, returning it and
+// its length. Brackets and quoted strings may be nested within it, as in
+// JSON keys.
+func parseBracket(s string) (PathElem, int, error) {
+	if quoted, err := strconv.QuotedPrefix(s[1:]); err == nil && strings.HasPrefix(s[1+len(quoted):], "]") {
+		key, _ := strconv.Unquote(quoted)
+		return PathElem{Kind: PathKey, Key: key}, len(quoted) + 2, nil
+	}
+	depth := 0
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted, err := strconv.QuotedPrefix(s[i:])
+			if err != nil {
+				return PathElem{}, 0, fmt.Errorf("malformed string in %s", s)
+			}
+			i += len(quoted) - 1
+		case '[', '{':
+			depth++
+		case '}':
+			depth--
+		case ']':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			text := s[1:i]
+			if index, err := strconv.Atoi(text); err == nil {
+				return PathElem{Kind: PathIndex, Index: index}, i + 1, nil
+			}
+			return PathElem{Kind: PathKey, Key: text}, i + 1, nil
+		}
+	}
+	return PathElem{}, 0, fmt.Errorf("unterminated [ in %s", s)
+}
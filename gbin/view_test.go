@@ -0,0 +1,189 @@
+package gbin_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lspaccatrosi16/go-libs/gbin"
+)
+
+type viewItem struct {
+	Name  string
+	Tags  []string
+	Score *float64
+}
+
+type viewRecord struct {
+	ID     int64
+	Items  []viewItem
+	Lookup map[string]viewItem
+	Counts map[uint16]int32
+	Points map[pointKey]string
+	Any    any
+	Grid   [2][2]int8
+}
+
+func viewData() viewRecord {
+	score := 2.5
+	return viewRecord{
+		ID:     7,
+		Items:  []viewItem{{Name: "a"}, {Name: "b", Tags: []string{"x", "y"}, Score: &score}},
+		Lookup: map[string]viewItem{"k": {Name: "c"}},
+		Counts: map[uint16]int32{3: -3, 4: -4},
+		Points: map[pointKey]string{{1, 2}: "p"},
+		Any:    []int{10, 20},
+		Grid:   [2][2]int8{{1, 2}, {3, 4}},
+	}
+}
+
+func TestDecodePath(t *testing.T) {
+	data := viewData()
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}, {gbin.WithSchema()}, {gbin.WithCompression(gbin.Gzip)}} {
+		encoded, err := gbin.NewEncoder[viewRecord](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name, err := gbin.DecodePath[string](encoded, ".Items[1].Name"); err != nil || name != "b" {
+			t.Fatalf("expected b, got %q and %v", name, err)
+		}
+		if tag, err := gbin.DecodePath[string](encoded, "Items[1].Tags[1]"); err != nil || tag != "y" {
+			t.Fatalf("expected y, got %q and %v", tag, err)
+		}
+		if score, err := gbin.DecodePath[*float64](encoded, ".Items[1].Score"); err != nil || *score != 2.5 {
+			t.Fatalf("expected 2.5, got %v and %v", score, err)
+		}
+		if item, err := gbin.DecodePath[viewItem](encoded, `.Lookup["k"]`); err != nil || item.Name != "c" {
+			t.Fatalf("expected c, got %v and %v", item, err)
+		}
+		if count, err := gbin.DecodePath[int32](encoded, ".Counts[4]"); err != nil || count != -4 {
+			t.Fatalf("expected -4, got %v and %v", count, err)
+		}
+		if p, err := gbin.DecodePath[string](encoded, `.Points[{"X":1,"Y":2}]`); err != nil || p != "p" {
+			t.Fatalf("expected p, got %q and %v", p, err)
+		}
+		if el, err := gbin.DecodePath[int](encoded, ".Any[1]"); err != nil || el != 20 {
+			t.Fatalf("expected 20, got %v and %v", el, err)
+		}
+		if row, err := gbin.DecodePath[[2]int8](encoded, ".Grid[1]"); err != nil || row != [2]int8{3, 4} {
+			t.Fatalf("expected [3 4], got %v and %v", row, err)
+		}
+		whole, err := gbin.DecodePath[viewRecord](encoded, "")
+		if err != nil || whole.ID != 7 || whole.Items[1].Name != "b" || whole.Counts[4] != -4 {
+			t.Fatalf("expected the whole value, got %v and %v", whole, err)
+		}
+	}
+}
+
+func TestView(t *testing.T) {
+	data := viewData()
+	for _, opts := range [][]gbin.Option{nil, {gbin.WithCompact()}} {
+		encoded, err := gbin.NewEncoder[viewRecord](opts...).Encode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		view, err := gbin.NewView(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, err := view.Field("Items")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := items.Len(); err != nil || n != 2 {
+			t.Fatalf("expected 2 items, got %d and %v", n, err)
+		}
+		if kind, err := items.Kind(); err != nil || kind != reflect.Slice {
+			t.Fatalf("expected a slice, got %s and %v", kind, err)
+		}
+		first, err := items.Index(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		score, err := first.Field("Score")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind, err := score.Kind(); err != nil || kind != reflect.Invalid {
+			t.Fatalf("expected a nil score, got %s and %v", kind, err)
+		}
+		counts, err := view.Path(".Counts")
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := counts.Key(uint16(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int32
+		if err := value.Decode(&count); err != nil || count != -3 {
+			t.Fatalf("expected -3, got %d and %v", count, err)
+		}
+
+		_, err = view.Path(".Items[5].Name")
+		if !errors.Is(err, gbin.ErrNotFound) {
+			t.Fatalf("expected a missing index, got %v", err)
+		} else if path := gbin.FormatPath(asError(t, err).Path); path != ".Items[5]" {
+			t.Fatalf("expected the path of the missing index, got %s", path)
+		}
+		_, err = view.Path(".Lookup.Name")
+		if !errors.Is(err, gbin.ErrTypeMismatch) {
+			t.Fatalf("expected a type mismatch, got %v", err)
+		}
+	}
+}
+
+func TestViewReferences(t *testing.T) {
+	shared := &refNode{Value: 1}
+	data := []*refNode{shared, shared}
+	encoded, err := gbin.NewEncoder[[]*refNode](gbin.WithReferences()).Encode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := gbin.DecodePath[int](encoded, "[0].Value"); err != nil || value != 1 {
+		t.Fatalf("expected 1, got %d and %v", value, err)
+	}
+	if _, err := gbin.DecodePath[int](encoded, "[1].Value"); err == nil {
+		t.Fatal("expected following a reference to fail")
+	}
+	if _, err := gbin.DecodePath[[]*refNode](encoded, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	path := []gbin.PathElem{
+		{Kind: gbin.PathField, Field: "Items"},
+		{Kind: gbin.PathIndex, Index: 2},
+		{Kind: gbin.PathKey, Key: "a]b"},
+		{Kind: gbin.PathKey, Key: `[1,"]"]`},
+		{Kind: gbin.PathField, Field: "Name"},
+	}
+	parsed, err := gbin.ParsePath(gbin.FormatPath(path[:3]) + `[[1,"]"]].Name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, path) {
+		t.Fatalf("expected %v, got %v", path, parsed)
+	}
+	for _, bad := range []string{".", "a..b", "a[1", "a]"} {
+		if _, err := gbin.ParsePath(bad); err == nil {
+			t.Fatalf("expected %q to fail to parse", bad)
+		}
+	}
+}
+
+// BenchmarkDecodePath reads the last of many values, which skips the others
+// rather than decoding them
+func BenchmarkDecodePath(b *testing.B) {
+	encoded, err := gbin.Marshal(benchRecords())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := gbin.DecodePath[string](encoded, "[99].Name"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
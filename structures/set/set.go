@@ -2,30 +2,33 @@ package set
 
 var exists = struct{}{}
 
-type mSet[T comparable] map[T]struct{}
-
 type Set[T comparable] struct {
-	m mSet[T]
+	// Values holds the members of the set. It is exported so that sets can be
+	// encoded, such as with gbin.
+	Values map[T]struct{}
 }
 
 func (s *Set[T]) Add(vals ...T) {
+	if s.Values == nil {
+		s.Values = make(map[T]struct{})
+	}
 	for _, val := range vals {
-		s.m[val] = exists
+		s.Values[val] = exists
 	}
 }
 
 func (s *Set[T]) Contains(k T) bool {
-	_, ok := s.m[k]
+	_, ok := s.Values[k]
 	return ok
 }
 
 func (s *Set[T]) Remove(k T) {
-	delete(s.m, k)
+	delete(s.Values, k)
 }
 
 func (s *Set[T]) GetIterator() func() (T, bool) {
 	var valArr = []T{}
-	for v := range s.m {
+	for v := range s.Values {
 		valArr = append(valArr, v)
 	}
 	i := 0
@@ -43,6 +46,6 @@ func (s *Set[T]) GetIterator() func() (T, bool) {
 
 func NewSet[T comparable]() *Set[T] {
 	s := &Set[T]{}
-	s.m = make(mSet[T])
+	s.Values = make(map[T]struct{})
 	return s
 }